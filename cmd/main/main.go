@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"log"
 	"net"
@@ -11,38 +12,312 @@ import (
 	"path/filepath"
 	"rest-api-go/internal/config"
 	"rest-api-go/internal/handlers"
+	authHandler "rest-api-go/internal/handlers/auth"
+	"rest-api-go/internal/handlers/router"
+	"rest-api-go/internal/publisher"
 	service "rest-api-go/internal/service/domain"
-	storage "rest-api-go/internal/storage/mongodb"
+	"rest-api-go/internal/service/domain/auth"
+	"rest-api-go/internal/service/domain/ids"
+	outboxService "rest-api-go/internal/service/domain/outbox"
+	"rest-api-go/internal/service/domain/password"
+	userService "rest-api-go/internal/service/domain/user"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/cache"
+	"rest-api-go/internal/storage/memory"
+	mongoStorage "rest-api-go/internal/storage/mongodb"
+	"rest-api-go/internal/storage/postgres"
 	"rest-api-go/pkg/client/mongodb"
+	"rest-api-go/pkg/client/postgresql"
 	"rest-api-go/pkg/logging"
 	"time"
 
-	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func main() {
 	logger := logging.GetLogger()
 	logger.Info("create router")
-	router := httprouter.New()
+	router := router.New()
 
 	cfg := config.GetConfig()
-	cfgMongo := cfg.MongoDB
-	mongoDBClient, err := mongodb.NewClient(context.Background(),
-		cfgMongo.Host, cfgMongo.Port, cfgMongo.Username, cfgMongo.Password,
-		cfgMongo.Database, cfgMongo.AuthDB)
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "migrate":
+			err = runMigrate(context.Background(), cfg, logger, os.Args[2:])
+		case "reencrypt":
+			err = runReencrypt(context.Background(), cfg, logger, os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q, use migrate or reencrypt", os.Args[1])
+		}
+		if err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+	repositories, err := newRepository(context.Background(), cfg, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if cfg.Encryption.Enabled {
+		logger.Infof("encrypt users with the keyring %s", cfg.Encryption.Keyring)
+		if _, err := encryptRepository(repositories, cfg); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	if cfg.Cache.Enabled {
+		logger.Infof("cache up to %d users for %s", cfg.Cache.Size, cfg.Cache.TTL)
+		cached := cache.NewUserRepository(repositories.User, cfg.Cache.Size, cfg.Cache.TTL)
+		repositories.User = cached
+		repositories.Transactor = cache.NewTransactor(repositories.Transactor, cached)
+		expvar.Publish("user_cache", expvar.Func(func() interface{} { return cached.Stats() }))
+	}
+	idStrategy, err := ids.New(cfg.Storage.IDStrategy)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	passwords, err := newPasswordPolicy(cfg, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	hasher, err := newPasswordHasher(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	tokens, err := newTokens(cfg, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	services := service.NewService(repositories, idStrategy, passwords, hasher, tokens, logger)
+
+	logger.Info("start purger of deleted users")
+	purger := userService.NewPurger(logger, repositories.User,
+		cfg.SoftDelete.Retention, cfg.SoftDelete.PurgeInterval)
+	go purger.Run(context.Background())
 
+	if services.AuthService != nil {
+		logger.Info("start purger of expired refresh tokens")
+		refreshTokenPurger := auth.NewPurger(logger, repositories.RefreshToken, cfg.Auth.PurgeInterval)
+		go refreshTokenPurger.Run(context.Background())
+	}
+
+	logger.Info("start outbox relay")
+	eventPublisher, err := newPublisher(cfg)
 	if err != nil {
-		panic(err)
+		logger.Fatal(err)
 	}
-	storage := storage.NewRepository(mongoDBClient, cfg.MongoDB.Collection, logger)
-	services := service.NewService(storage, logger)
+	relay := outboxService.NewRelay(logger, repositories.Outbox, eventPublisher,
+		cfg.Outbox.PollInterval, cfg.Outbox.BatchSize)
+	go relay.Run(context.Background())
+
 	handlers.RegisterHandlers(router, services, logger)
 	logger.Info("register handlers")
 
-	run(router, cfg)
+	tenants := handlers.TenantResolver{
+		Header:  cfg.Tenancy.Header,
+		Domain:  cfg.Tenancy.Domain,
+		Default: cfg.Tenancy.Default,
+		Global:  []string{authHandler.JWKSUrl},
+	}
+	if cfg.Admin.Enabled {
+		go runAdmin(cfg)
+	}
+	// the actor is checked against the tenant, so it is resolved first
+	run(handlers.WithTenant(handlers.WithActor(router, services.AuthService), tenants), cfg)
+
+}
 
+// newPasswordPolicy builds the password policy of password_policy, loading
+// the breached password list if one is configured
+func newPasswordPolicy(cfg *config.Config, logger *logging.Logger) (*password.Policy, error) {
+	cfgPolicy := cfg.PasswordPolicy
+	policy := &password.Policy{
+		MinLength:      cfgPolicy.MinLength,
+		MaxLength:      cfgPolicy.MaxLength,
+		RequireLower:   cfgPolicy.RequireLower,
+		RequireUpper:   cfgPolicy.RequireUpper,
+		RequireDigit:   cfgPolicy.RequireDigit,
+		RequireSymbol:  cfgPolicy.RequireSymbol,
+		ForbidPersonal: cfgPolicy.ForbidPersonal,
+	}
+	if cfgPolicy.BreachedFile != "" {
+		breached, err := password.LoadBreachedList(cfgPolicy.BreachedFile)
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("loaded %d breached passwords from %s", breached.Len(), cfgPolicy.BreachedFile)
+		policy.Breached = breached
+	}
+	return policy, nil
 }
-func run(router *httprouter.Router, cfg *config.Config) {
+
+// newPasswordHasher hashes with the algorithm of password_hashing and keeps
+// verifying the hashes of the other one
+func newPasswordHasher(cfg *config.Config) (password.PasswordHasher, error) {
+	cfgHashing := cfg.PasswordHashing
+	if cfgHashing.BcryptCost < bcrypt.MinCost || cfgHashing.BcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	cfgArgon := cfgHashing.Argon2id
+	if cfgArgon.Memory == 0 || cfgArgon.Iterations == 0 || cfgArgon.Parallelism == 0 ||
+		cfgArgon.SaltLength < 8 || cfgArgon.KeyLength < 16 {
+		return nil, fmt.Errorf("argon2id needs memory, iterations and parallelism, a salt of at least 8 bytes and a key of at least 16")
+	}
+	bcryptHasher := password.Bcrypt{Cost: cfgHashing.BcryptCost}
+	argonHasher := password.Argon2id{
+		Memory:      cfgArgon.Memory,
+		Iterations:  cfgArgon.Iterations,
+		Parallelism: cfgArgon.Parallelism,
+		SaltLength:  cfgArgon.SaltLength,
+		KeyLength:   cfgArgon.KeyLength,
+	}
+	switch cfgHashing.Algorithm {
+	case "argon2id":
+		return password.NewHasher(argonHasher, bcryptHasher), nil
+	case "bcrypt":
+		return password.NewHasher(bcryptHasher, argonHasher), nil
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm %q, use argon2id or bcrypt", cfgHashing.Algorithm)
+	}
+}
+
+// newTokens loads the signing keys of auth, signing in stays disabled
+// unless auth.enabled is set
+func newTokens(cfg *config.Config, logger *logging.Logger) (auth.Tokens, error) {
+	cfgAuth := cfg.Auth
+	if !cfgAuth.Enabled {
+		return auth.Tokens{}, nil
+	}
+	if cfgAuth.AccessTokenTTL <= 0 || cfgAuth.RefreshTokenTTL <= 0 {
+		return auth.Tokens{}, fmt.Errorf("access and refresh token ttls must be positive")
+	}
+	if cfgAuth.PurgeInterval <= 0 {
+		return auth.Tokens{}, fmt.Errorf("refresh token purge interval must be positive")
+	}
+	logger.Infof("sign tokens with the key %s", cfgAuth.SigningKey)
+	signer, err := auth.LoadSigner(cfgAuth.SigningKey, cfgAuth.PreviousKeys)
+	if err != nil {
+		return auth.Tokens{}, err
+	}
+	return auth.Tokens{
+		Signer:          signer,
+		Issuer:          cfgAuth.Issuer,
+		Audience:        cfgAuth.Audience,
+		AccessTokenTTL:  cfgAuth.AccessTokenTTL,
+		RefreshTokenTTL: cfgAuth.RefreshTokenTTL,
+	}, nil
+}
+
+// newRepository builds the storage backend selected by storage.driver
+func newRepository(ctx context.Context, cfg *config.Config, logger *logging.Logger) (*storage.Repository, error) {
+	logger.Infof("use %s storage", cfg.Storage.Driver)
+	switch cfg.Storage.Driver {
+	case "memory":
+		return memory.NewRepository(logger), nil
+	case "mongodb", "":
+		database, collections, err := newMongoDatabase(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		migrator, err := mongoStorage.NewMigrator(database, collections, cfg.Tenancy.Default, logger)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.MongoDB.MigrateOnStart {
+			logger.Info("apply mongodb migrations")
+			if err := migrator.Up(ctx); err != nil {
+				return nil, err
+			}
+		} else if err := warnPendingMigrations(ctx, migrator, logger); err != nil {
+			return nil, err
+		}
+		if cfg.MongoDB.Transactions {
+			if err := mongoStorage.CheckTransactions(ctx, database); err != nil {
+				return nil, err
+			}
+		} else {
+			logger.Warn("mongodb transactions are disabled, a failed write may leave a user without its history or events")
+		}
+		return mongoStorage.NewRepository(database, collections, cfg.MongoDB.Transactions, logger), nil
+	case "postgres":
+		cfgPostgres := cfg.PostgreSQL
+		db, err := postgresql.NewClient(ctx,
+			cfgPostgres.Host, cfgPostgres.Port, cfgPostgres.Username, cfgPostgres.Password,
+			cfgPostgres.Database, cfgPostgres.SSLMode)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("apply postgres migrations")
+		if err := postgres.Migrate(ctx, db, cfg.Tenancy.Default, logger); err != nil {
+			return nil, err
+		}
+		return postgres.NewRepository(db, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %s", cfg.Storage.Driver)
+	}
+}
+
+// newMongoDatabase connects to the database configured under mongodb
+func newMongoDatabase(ctx context.Context, cfg *config.Config) (*mongo.Database, mongoStorage.Collections, error) {
+	cfgMongo := cfg.MongoDB
+	collections := mongoStorage.Collections{
+		Users:         cfgMongo.Collection,
+		History:       cfgMongo.HistoryCollection,
+		Outbox:        cfgMongo.OutboxCollection,
+		RefreshTokens: cfgMongo.RefreshTokenCollection,
+	}
+	database, err := mongodb.NewClient(ctx, mongodb.Config{
+		URI:                    cfgMongo.URI,
+		Host:                   cfgMongo.Host,
+		Port:                   cfgMongo.Port,
+		Database:               cfgMongo.Database,
+		AuthDB:                 cfgMongo.AuthDB,
+		Username:               cfgMongo.Username,
+		Password:               cfgMongo.Password,
+		ReplicaSet:             cfgMongo.ReplicaSet,
+		ReadPreference:         cfgMongo.ReadPreference,
+		TLS:                    mongodb.TLSConfig(cfgMongo.TLS),
+		MaxPoolSize:            cfgMongo.MaxPoolSize,
+		MinPoolSize:            cfgMongo.MinPoolSize,
+		ConnectTimeout:         cfgMongo.ConnectTimeout,
+		ServerSelectionTimeout: cfgMongo.ServerSelectionTimeout,
+	})
+	return database, collections, err
+}
+
+// newPublisher builds the event publisher selected by outbox.publisher
+func newPublisher(cfg *config.Config) (publisher.Publisher, error) {
+	switch cfg.Outbox.Publisher {
+	case "stdout", "":
+		return publisher.NewWriterPublisher(os.Stdout), nil
+	case "file":
+		return publisher.NewFilePublisher(cfg.Outbox.File)
+	default:
+		return nil, fmt.Errorf("unknown outbox publisher: %s", cfg.Outbox.Publisher)
+	}
+}
+
+// runAdmin serves the expvar counters, such as the statistics of the user
+// cache, on the admin listener. It has no authentication, bind it to an
+// address only operators can reach.
+func runAdmin(cfg *config.Config) {
+	logger := logging.GetLogger()
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	address := fmt.Sprintf("%s:%s", cfg.Admin.BindIp, cfg.Admin.Port)
+	logger.Infof("Admin server is listening port %s", address)
+	server := &http.Server{
+		Addr:         address,
+		Handler:      mux,
+		WriteTimeout: 10 * time.Second,
+		ReadTimeout:  10 * time.Second,
+	}
+	log.Fatalln(server.ListenAndServe())
+}
+
+func run(handler http.Handler, cfg *config.Config) {
 	logger := logging.GetLogger()
 	logger.Info("run server")
 
@@ -69,7 +344,7 @@ func run(router *httprouter.Router, cfg *config.Config) {
 	}
 
 	server := &http.Server{
-		Handler:      router,
+		Handler:      handler,
 		WriteTimeout: 10 * time.Second,
 		ReadTimeout:  10 * time.Second,
 	}
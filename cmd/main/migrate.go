@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"rest-api-go/internal/config"
+	mongoStorage "rest-api-go/internal/storage/mongodb"
+	"rest-api-go/internal/storage/postgres"
+	"rest-api-go/pkg/client/postgresql"
+	"rest-api-go/pkg/logging"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = "usage: app migrate up|down [steps]|status"
+
+// runMigrate runs the migrate subcommand against the configured storage
+// driver and returns without starting the server
+func runMigrate(ctx context.Context, cfg *config.Config, logger *logging.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	switch cfg.Storage.Driver {
+	case "mongodb", "":
+		database, collections, err := newMongoDatabase(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		migrator, err := mongoStorage.NewMigrator(database, collections, cfg.Tenancy.Default, logger)
+		if err != nil {
+			return err
+		}
+		switch args[0] {
+		case "up":
+			return migrator.Up(ctx)
+		case "down":
+			steps := 1
+			if len(args) > 1 {
+				if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+					return fmt.Errorf("steps must be a positive number, got %q", args[1])
+				}
+			}
+			return migrator.Down(ctx, steps)
+		case "status":
+			statuses, err := migrator.Status(ctx)
+			if err != nil {
+				return err
+			}
+			return printMigrationStatus(statuses)
+		}
+	case "postgres":
+		// postgres migrations are plain SQL files and only go up
+		if args[0] != "up" {
+			return fmt.Errorf("the postgres driver only supports migrate up")
+		}
+		cfgPostgres := cfg.PostgreSQL
+		db, err := postgresql.NewClient(ctx,
+			cfgPostgres.Host, cfgPostgres.Port, cfgPostgres.Username, cfgPostgres.Password,
+			cfgPostgres.Database, cfgPostgres.SSLMode)
+		if err != nil {
+			return err
+		}
+		return postgres.Migrate(ctx, db, cfg.Tenancy.Default, logger)
+	default:
+		return fmt.Errorf("the %s storage driver has no migrations", cfg.Storage.Driver)
+	}
+	return errors.New(migrateUsage)
+}
+
+func printMigrationStatus(statuses []mongoStorage.MigrationStatus) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	return w.Flush()
+}
+
+// warnPendingMigrations logs the migrations left for the migrate subcommand
+// when they are not applied at startup
+func warnPendingMigrations(ctx context.Context, migrator *mongoStorage.Migrator, logger *logging.Logger) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			logger.Warnf("mongodb migration %04d_%s is pending, run: app migrate up", status.Version, status.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"rest-api-go/internal/config"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/encrypted"
+	"rest-api-go/pkg/logging"
+)
+
+// runReencrypt runs the reencrypt subcommand, which seals the users, history
+// and events of the given tenants, or of the default one, with the primary
+// key of the keyring
+func runReencrypt(ctx context.Context, cfg *config.Config, logger *logging.Logger, tenants []string) error {
+	if !cfg.Encryption.Enabled {
+		return errors.New("encryption is disabled, enable it before reencrypting")
+	}
+	if len(tenants) == 0 {
+		if cfg.Tenancy.Default == "" {
+			return errors.New("usage: app reencrypt tenant...")
+		}
+		tenants = []string{cfg.Tenancy.Default}
+	}
+	repositories, err := newRepository(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	reencryptions, err := encryptRepository(repositories, cfg)
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		for _, r := range reencryptions {
+			reencrypted, skipped, err := r.repository.Reencrypt(requestctx.WithTenant(ctx, tenant))
+			if err != nil {
+				return err
+			}
+			logger.Infof("tenant %s: reencrypted %d %s, skipped %d changed meanwhile", tenant, reencrypted, r.name, skipped)
+		}
+	}
+	logger.Info("run reencrypt again until nothing is skipped, a retired key must stay in the keyring " +
+		"until then and until the users deleted before the rotation are purged")
+	return nil
+}
+
+// reencryption names a repository that seals what it stored with older keys
+// again
+type reencryption struct {
+	name       string
+	repository interface {
+		Reencrypt(ctx context.Context) (reencrypted, skipped int, err error)
+	}
+}
+
+// encryptRepository encrypts the users, history and events of repositories
+// with the configured keyring and returns them for reencryption
+func encryptRepository(repositories *storage.Repository, cfg *config.Config) ([]reencryption, error) {
+	keyring, err := encrypted.LoadKeyring(cfg.Encryption.Keyring)
+	if err != nil {
+		return nil, err
+	}
+	users := encrypted.NewUserRepository(repositories.User, keyring)
+	records := encrypted.NewHistoryRepository(repositories.History, keyring)
+	events := encrypted.NewOutboxRepository(repositories.Outbox, keyring)
+	repositories.User, repositories.History, repositories.Outbox = users, records, events
+	return []reencryption{{"users", users}, {"history records", records}, {"events", events}}, nil
+}
@@ -1,16 +1,48 @@
 package apperrors
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
 
 var (
 	ErrNotFound = NewAppError(nil, "not found", "", "404")
+	ErrConflict = NewAppError(nil, "already exists", "", "409")
+	// ErrPreconditionFailed is returned when the version of a write is stale
+	ErrPreconditionFailed = NewAppError(nil, "user was modified by another request", "", "412")
+	// ErrBatchAborted marks the users of an all-or-nothing batch that were
+	// not created because another one failed
+	ErrBatchAborted = NewAppError(nil, "not created because another user of the batch failed", "", "424")
+	// ErrInvalidCredentials is returned by a sign in with an unknown login or
+	// a wrong password, which are not told apart
+	ErrInvalidCredentials = NewAppError(nil, "invalid login or password", "", "401")
+	// ErrInvalidRefreshToken is returned for a refresh token that is unknown,
+	// expired, revoked or was used before
+	ErrInvalidRefreshToken = NewAppError(nil, "invalid refresh token", "", "401")
+	// ErrInvalidAccessToken is returned for an access token that is
+	// malformed, expired or not signed by this service
+	ErrInvalidAccessToken = NewAppError(nil, "invalid access token", "", "401")
+	// ErrValidation is returned when a request breaks the rules of its fields
+	ErrValidation = NewAppError(nil, "validation failed", "", "422")
 )
 
+// FieldError is the violation of one rule by one field
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
 type AppError struct {
 	Err              error  `json:"-"`
 	Message          string `json:"message"`
 	DeveloperMessage string `json:"developer_message"`
 	Code             string `json:"code"`
+	Field            string `json:"field,omitempty"`
+	// Fields lists every violation of a validation error
+	Fields []FieldError `json:"fields,omitempty"`
 }
 
 func (e *AppError) Error() string {
@@ -27,11 +59,21 @@ func (e *AppError) Marshal() []byte {
 	return marshal
 }
 
+// StatusCode maps the error code to an HTTP status, falling back to 400.
+func (e *AppError) StatusCode() int {
+	code, err := strconv.Atoi(e.Code)
+	if err != nil || code < http.StatusBadRequest {
+		return http.StatusBadRequest
+	}
+	return code
+}
+
 func NewAppError(err error, message, developerMessage, code string) *AppError {
 	return &AppError{
 		Err:              err,
 		Message:          message,
 		DeveloperMessage: developerMessage,
+		Code:             code,
 	}
 }
 func SystemError(err error) *AppError {
@@ -40,3 +82,19 @@ func SystemError(err error) *AppError {
 func BadRequestError(message string) *AppError {
 	return NewAppError(nil, message, "bad request", "400")
 }
+
+// ConflictError reports a unique field that is already taken.
+// It wraps ErrConflict so callers can check it with errors.Is.
+func ConflictError(field string) *AppError {
+	appErr := NewAppError(ErrConflict, fmt.Sprintf("%s already exists", field), "duplicate key", "409")
+	appErr.Field = field
+	return appErr
+}
+
+// ValidationError reports every violation found in a request at once.
+// It wraps ErrValidation so callers can check it with errors.Is.
+func ValidationError(fields []FieldError) *AppError {
+	appErr := NewAppError(ErrValidation, "validation failed", "invalid fields", "422")
+	appErr.Fields = fields
+	return appErr
+}
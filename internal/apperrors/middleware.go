@@ -14,17 +14,12 @@ func Middleware(h appHandler) http.HandlerFunc {
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			if errors.As(err, &appErr) {
-				if errors.Is(err, ErrNotFound) {
-					w.WriteHeader(http.StatusNotFound)
-					w.Write(ErrNotFound.Marshal())
-					return
-				}
-				err = err.(*AppError)
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write(ErrNotFound.Marshal())
-
+				w.WriteHeader(appErr.StatusCode())
+				w.Write(appErr.Marshal())
+				return
 			}
-			w.WriteHeader(http.StatusTeapot)
+			// anything else is a failure of the service, not of the request
+			w.WriteHeader(http.StatusInternalServerError)
 			w.Write(SystemError(err).Marshal())
 		}
 	}
@@ -0,0 +1,38 @@
+package apperrors_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/storage"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"no error", nil, http.StatusOK},
+		{"app error", apperrors.ErrNotFound, http.StatusNotFound},
+		{"wrapped app error", fmt.Errorf("failed to delete user. error: %w", apperrors.ErrPreconditionFailed), http.StatusPreconditionFailed},
+		{"missing tenant", fmt.Errorf("failed to find users: %w", storage.ErrMissingTenant), http.StatusBadRequest},
+		{"internal error", errors.New("transaction aborted"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			handler := apperrors.Middleware(func(w http.ResponseWriter, r *http.Request) error {
+				return tt.err
+			})
+			recorder := httptest.NewRecorder()
+			handler(recorder, httptest.NewRequest(http.MethodGet, "/users", nil))
+			if recorder.Code != tt.want {
+				t.Fatalf("got status %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+}
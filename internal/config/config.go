@@ -3,6 +3,7 @@ package config
 import (
 	"rest-api-go/pkg/logging"
 	"sync"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
@@ -14,15 +15,149 @@ type Config struct {
 		BindIp string `yaml:"bind_ip"`
 		Port   string `yaml:"port"`
 	}
+	// Admin serves /debug/vars on a listener of its own, it has no
+	// authentication so keep it on a private address
+	Admin struct {
+		Enabled bool   `yaml:"enabled" env-default:"false"`
+		BindIp  string `yaml:"bind_ip" env-default:"127.0.0.1"`
+		Port    string `yaml:"port" env-default:"8081"`
+	} `yaml:"admin"`
+	Storage struct {
+		// Driver selects the storage backend: "mongodb", "postgres" or "memory"
+		Driver string `yaml:"driver" env-default:"mongodb"`
+		// IDStrategy generates user ids: "uuidv4", "uuidv7", "ulid" or "objectid"
+		IDStrategy string `yaml:"id_strategy" env-default:"uuidv7"`
+	} `yaml:"storage"`
+	Tenancy struct {
+		// Header names the tenant of a request
+		Header string `yaml:"header" env-default:"X-Tenant-ID"`
+		// Domain takes the tenant from the subdomain of the host, the tenant of
+		// acme.users.example.com is acme when Domain is users.example.com
+		Domain string `yaml:"domain"`
+		// Default is the tenant of requests that name none, empty rejects them.
+		// The migrations move data stored before tenancy to it
+		Default string `yaml:"default" env-default:"default"`
+	} `yaml:"tenancy"`
+	SoftDelete struct {
+		// Retention is how long deleted users can still be restored
+		Retention     time.Duration `yaml:"retention" env-default:"720h"`
+		PurgeInterval time.Duration `yaml:"purge_interval" env-default:"1h"`
+	} `yaml:"soft_delete"`
+	Cache struct {
+		// Enabled caches users looked up by id in front of the storage backend
+		Enabled bool          `yaml:"enabled" env-default:"false"`
+		Size    int           `yaml:"size" env-default:"10000"`
+		TTL     time.Duration `yaml:"ttl" env-default:"1m"`
+	} `yaml:"cache"`
+	PasswordPolicy struct {
+		// MinLength and MaxLength bound passwords in characters, 0 disables a bound
+		MinLength int `yaml:"min_length" env-default:"12"`
+		MaxLength int `yaml:"max_length" env-default:"128"`
+		// the character classes every password must hold
+		RequireLower  bool `yaml:"require_lower" env-default:"false"`
+		RequireUpper  bool `yaml:"require_upper" env-default:"false"`
+		RequireDigit  bool `yaml:"require_digit" env-default:"false"`
+		RequireSymbol bool `yaml:"require_symbol" env-default:"false"`
+		// ForbidPersonal rejects passwords holding the username or the email
+		ForbidPersonal bool `yaml:"forbid_personal" env-default:"true"`
+		// BreachedFile lists the SHA-1 of breached passwords one per line, as
+		// in the Pwned Passwords downloads, empty disables the check
+		BreachedFile string `yaml:"breached_file"`
+	} `yaml:"password_policy"`
+	PasswordHashing struct {
+		// Algorithm hashes new passwords: "argon2id" or "bcrypt". Hashes made
+		// with the other one or with other parameters are upgraded the next
+		// time their user proves the password
+		Algorithm  string `yaml:"algorithm" env-default:"argon2id"`
+		BcryptCost int    `yaml:"bcrypt_cost" env-default:"12"`
+		Argon2id   struct {
+			// Memory is in KiB
+			Memory      uint32 `yaml:"memory" env-default:"19456"`
+			Iterations  uint32 `yaml:"iterations" env-default:"2"`
+			Parallelism uint8  `yaml:"parallelism" env-default:"1"`
+			SaltLength  uint32 `yaml:"salt_length" env-default:"16"`
+			KeyLength   uint32 `yaml:"key_length" env-default:"32"`
+		} `yaml:"argon2id"`
+	} `yaml:"password_hashing"`
+	Auth struct {
+		// Enabled serves /auth/login, /auth/refresh, /auth/logout and
+		// /.well-known/jwks.json
+		Enabled bool `yaml:"enabled" env-default:"false"`
+		// SigningKey is the PEM file of the RSA or Ed25519 private key tokens
+		// are signed with, RSA keys sign with RS256 and Ed25519 keys with EdDSA
+		SigningKey string `yaml:"signing_key" env-default:"jwt.pem"`
+		// PreviousKeys are PEM files of keys that signed tokens which are not
+		// expired yet, they are still published in the JWKS
+		PreviousKeys   []string      `yaml:"previous_keys"`
+		Issuer         string        `yaml:"issuer" env-default:"rest-api-go"`
+		Audience       string        `yaml:"audience" env-default:"rest-api-go"`
+		AccessTokenTTL time.Duration `yaml:"access_token_ttl" env-default:"15m"`
+		// RefreshTokenTTL is how long a refresh token can be exchanged
+		RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" env-default:"720h"`
+		// PurgeInterval is how often expired refresh tokens are deleted
+		PurgeInterval time.Duration `yaml:"purge_interval" env-default:"1h"`
+	} `yaml:"auth"`
+	Encryption struct {
+		// Enabled encrypts the email of every user written from now on, as
+		// well as the emails in the history and the payloads of events, run
+		// the reencrypt subcommand to encrypt the stored users. Lists cannot
+		// be sorted by email while it is enabled, sort=email is a bad request.
+		Enabled bool `yaml:"enabled" env-default:"false"`
+		// Keyring is the JSON file holding the keys, see encrypted.Keyring
+		Keyring string `yaml:"keyring" env-default:"keyring.json"`
+	} `yaml:"encryption"`
+	Outbox struct {
+		PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+		BatchSize    int           `yaml:"batch_size" env-default:"100"`
+		// Publisher selects where events go: "stdout" or "file"
+		Publisher string `yaml:"publisher" env-default:"stdout"`
+		File      string `yaml:"file" env-default:"logs/events.ndjson"`
+	} `yaml:"outbox"`
 	MongoDB struct {
+		// URI is a full connection string, Host and Port are ignored when it is set
+		URI        string `json:"uri" yaml:"uri"`
 		Host       string `json:"host"`
 		Port       string `json:"port"`
 		Database   string `json:"database"`
-		AuthDB     string `json:"auth_db"`
+		AuthDB     string `json:"auth_db" yaml:"auth_db"`
 		Username   string `json:"username"`
 		Password   string `json:"password"`
 		Collection string `json:"collection"`
+		// HistoryCollection stores the change history of users
+		HistoryCollection string `json:"history_collection" yaml:"history_collection" env-default:"users_history"`
+		OutboxCollection  string `json:"outbox_collection" yaml:"outbox_collection" env-default:"users_outbox"`
+		// RefreshTokenCollection stores the refresh tokens of signed in users
+		RefreshTokenCollection string `json:"refresh_token_collection" yaml:"refresh_token_collection" env-default:"users_refresh_tokens"`
+		// Transactions need a replica set or a sharded cluster, startup fails
+		// without one. Without transactions a failed write may leave a user
+		// without its history or outbox events, and atomic batches are refused.
+		Transactions bool   `json:"transactions" yaml:"transactions" env-default:"false"`
+		ReplicaSet   string `json:"replica_set" yaml:"replica_set"`
+		// ReadPreference is a mode such as primary or secondaryPreferred
+		ReadPreference string `json:"read_preference" yaml:"read_preference"`
+		TLS            struct {
+			Enabled            bool   `json:"enabled" yaml:"enabled"`
+			CAFile             string `json:"ca_file" yaml:"ca_file"`
+			CertFile           string `json:"cert_file" yaml:"cert_file"`
+			KeyFile            string `json:"key_file" yaml:"key_file"`
+			InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+		} `json:"tls" yaml:"tls"`
+		MaxPoolSize            uint64        `json:"max_pool_size" yaml:"max_pool_size"`
+		MinPoolSize            uint64        `json:"min_pool_size" yaml:"min_pool_size"`
+		ConnectTimeout         time.Duration `json:"connect_timeout" yaml:"connect_timeout" env-default:"10s"`
+		ServerSelectionTimeout time.Duration `json:"server_selection_timeout" yaml:"server_selection_timeout" env-default:"30s"`
+		// MigrateOnStart applies pending migrations at startup, otherwise run
+		// the migrate subcommand before deploying
+		MigrateOnStart bool `json:"migrate_on_start" yaml:"migrate_on_start" env-default:"true"`
 	} `json:"mongodb"`
+	PostgreSQL struct {
+		Host     string `yaml:"host"`
+		Port     string `yaml:"port"`
+		Database string `yaml:"database"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		SSLMode  string `yaml:"ssl_mode" env-default:"disable"`
+	} `yaml:"postgresql"`
 }
 
 var instance *Config
@@ -0,0 +1,60 @@
+// Package auth holds the credentials users sign in with and the tokens they
+// get in return
+package auth
+
+import "time"
+
+// LoginDTO signs a user in with a username or an email and a password
+type LoginDTO struct {
+	Login    string `json:"login" validate:"required,max=254"`
+	Password string `json:"password" validate:"required"`
+}
+
+// RefreshDTO carries a refresh token to exchange or to revoke
+type RefreshDTO struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=128"`
+}
+
+// Token is the response of a successful sign in or refresh, shaped as in
+// RFC 6749
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// ExpiresIn is the lifetime of AccessToken in seconds
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken is a stored refresh token. Only the SHA-256 of the token is
+// stored, the token itself is only known to the client it was issued to.
+type RefreshToken struct {
+	// Hash is the hex SHA-256 of the token and identifies it
+	Hash     string `bson:"_id" json:"-"`
+	TenantID string `bson:"tenant_id" json:"-"`
+	UserID   string `bson:"user_id" json:"user_id"`
+	// FamilyID is shared by all the tokens rotated from one sign in
+	FamilyID  string    `bson:"family_id" json:"family_id"`
+	IssuedAt  time.Time `bson:"issued_at" json:"issued_at"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+	// RotatedAt is set once the token was exchanged for its successor
+	RotatedAt *time.Time `bson:"rotated_at,omitempty" json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// JWK is the public part of a signing key as in RFC 7517. RSA keys use N and
+// E, Ed25519 keys Crv and X.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is the document of /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
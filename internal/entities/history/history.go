@@ -0,0 +1,52 @@
+package history
+
+import (
+	"rest-api-go/internal/entities/user"
+	"time"
+)
+
+type Action string
+
+const (
+	ActionCreated  Action = "created"
+	ActionUpdated  Action = "updated"
+	ActionDeleted  Action = "deleted"
+	ActionRestored Action = "restored"
+	// ActionErased is the tombstone left when a user is erased
+	ActionErased Action = "erased"
+)
+
+// Change of one field. Before and After are left empty for secret fields
+// such as the password, only the fact that they changed is recorded.
+type Change struct {
+	Field  string `bson:"field" json:"field"`
+	Before string `bson:"before,omitempty" json:"before,omitempty"`
+	After  string `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// Record is an immutable entry in the change history of a user
+type Record struct {
+	ID        string    `bson:"_id,omitempty" json:"id"`
+	TenantID  string    `bson:"tenant_id" json:"-"`
+	UserID    string    `bson:"user_id" json:"user_id"`
+	Actor     string    `bson:"actor" json:"actor"`
+	Action    Action    `bson:"action" json:"action"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+	Changes   []Change  `bson:"changes,omitempty" json:"changes,omitempty"`
+}
+
+// Diff returns the changes between two versions of a user. Use a zero
+// before for a new user.
+func Diff(before, after user.User) []Change {
+	var changes []Change
+	if before.Username != after.Username {
+		changes = append(changes, Change{Field: "username", Before: before.Username, After: after.Username})
+	}
+	if before.Email != after.Email {
+		changes = append(changes, Change{Field: "email", Before: before.Email, After: after.Email})
+	}
+	if before.PasswordHash != after.PasswordHash {
+		changes = append(changes, Change{Field: "password"})
+	}
+	return changes
+}
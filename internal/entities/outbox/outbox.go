@@ -0,0 +1,33 @@
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event types published for users
+const (
+	UserCreated  = "user.created"
+	UserUpdated  = "user.updated"
+	UserDeleted  = "user.deleted"
+	UserRestored = "user.restored"
+	// UserErased tells consumers to erase their copies of the user as well
+	UserErased = "user.erased"
+)
+
+// Event is a domain event waiting in the outbox until a relay publishes it
+type Event struct {
+	ID          string          `bson:"_id,omitempty" json:"id"`
+	Type        string          `bson:"type" json:"type"`
+	TenantID    string          `bson:"tenant_id" json:"tenant_id"`
+	AggregateID string          `bson:"aggregate_id" json:"aggregate_id"`
+	Payload     json.RawMessage `bson:"payload" json:"payload"`
+	OccurredAt  time.Time       `bson:"occurred_at" json:"occurred_at"`
+	PublishedAt *time.Time      `bson:"published_at,omitempty" json:"-"`
+}
+
+// AnonymousPayload replaces the payload of the events of an erased aggregate
+func AnonymousPayload(aggregateID string) json.RawMessage {
+	payload, _ := json.Marshal(map[string]string{"id": aggregateID})
+	return payload
+}
@@ -0,0 +1,22 @@
+// Package privacy holds the results of data subject requests
+package privacy
+
+import (
+	"rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/entities/outbox"
+	"rest-api-go/internal/entities/user"
+	"time"
+)
+
+// Archive is everything stored about one user. The password hash and the
+// hashes of refresh tokens are left out, they are credentials rather than
+// data about the user.
+type Archive struct {
+	ExportedAt    time.Time           `json:"exported_at"`
+	Tenant        string              `json:"tenant"`
+	User          user.User           `json:"user"`
+	History       []history.Record    `json:"history"`
+	Events        []outbox.Event      `json:"events"`
+	RefreshTokens []auth.RefreshToken `json:"refresh_tokens"`
+}
@@ -0,0 +1,70 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Fields a user list can be sorted by.
+const (
+	FieldUsername = "username"
+	// FieldEmail cannot be sorted by when emails are encrypted
+	FieldEmail     = "email"
+	FieldCreatedAt = "created_at"
+)
+
+// SortField orders users by one field. Ties are always broken by ID.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ListQuery selects one page of users. Cursor is the NextCursor of the
+// previous page, or empty for the first one. Zero-valued filters are ignored.
+type ListQuery struct {
+	Limit  int
+	Cursor string
+
+	Email          string
+	Username       string
+	UsernamePrefix string
+	CreatedAfter   time.Time
+	Sort           []SortField
+}
+
+// Page is one page of users and the cursor of the page after it.
+// NextCursor is empty on the last page.
+type Page struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ParseSort parses a comma separated list of fields such as
+// "username,-created_at", where a leading minus means descending order.
+func ParseSort(s string) ([]SortField, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var sort []SortField
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		field := SortField{Field: strings.TrimSpace(part)}
+		if strings.HasPrefix(field.Field, "-") {
+			field.Field = field.Field[1:]
+			field.Desc = true
+		}
+		switch field.Field {
+		case FieldUsername, FieldEmail, FieldCreatedAt:
+		default:
+			return nil, fmt.Errorf("can't sort by %q", field.Field)
+		}
+		if seen[field.Field] {
+			return nil, fmt.Errorf("duplicate sort field %q", field.Field)
+		}
+		seen[field.Field] = true
+		sort = append(sort, field)
+	}
+	return sort, nil
+}
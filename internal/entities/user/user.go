@@ -1,30 +1,48 @@
 package user
 
-import (
-	"fmt"
+import "time"
 
-	"golang.org/x/crypto/bcrypt"
-)
+// MaxBatchSize is the most users a batch create may hold
+const MaxBatchSize = 10000
 
 type User struct {
-	ID           string `bson:"_id,omitempty" json:"id"`
-	Username     string `bson:"username" json:"username"`
-	PasswordHash string `bson:"password" json:"-"`
-	Email        string `bson:"email" json:"email"`
+	ID string `bson:"_id,omitempty" json:"id"`
+	// TenantID is set by the repositories from the context
+	TenantID     string     `bson:"tenant_id" json:"-"`
+	Username     string     `bson:"username" json:"username"`
+	PasswordHash string     `bson:"password" json:"-"`
+	Email        string     `bson:"email" json:"email"`
+	CreatedAt    time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time  `bson:"updated_at" json:"updated_at"`
+	DeletedAt    *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	// Version starts at 1 and is incremented by every write
+	Version int64 `bson:"version" json:"version"`
+	// EncryptedFields is the sealed envelope of the encrypted fields, opaque
+	// to the repositories that store it
+	EncryptedFields string `bson:"encrypted_fields,omitempty" json:"-"`
 }
 
 type CreateUserDTO struct {
-	Username string `json:"username" validate:"required"`
+	Username string `json:"username" validate:"required,min=3,max=64,regex=^[A-Za-z0-9._-]+$"`
 	Password string `json:"password" validate:"required"`
-	Email    string `json:"email" validate:"required"`
+	Email    string `json:"email" validate:"required,email,max=254"`
 }
 type UpdateUserDTO struct {
-	ID          string `json:"uuid,omitempty" bson:"_id,omitempty"`
-	Email       string `json:"email,omitempty" bson:"email,omitempty"`
-	Password    string `json:"password,omitempty" bson:"password,omitempty"`
-	Username    string `json:"username,omitempty" bson:"username,omitempty"`
+	ID       string `json:"uuid,omitempty" bson:"_id,omitempty"`
+	Email    string `json:"email,omitempty" bson:"email,omitempty" validate:"omitempty,email,max=254"`
+	Username string `json:"username,omitempty" bson:"username,omitempty" validate:"omitempty,min=3,max=64,regex=^[A-Za-z0-9._-]+$"`
+	// OldPassword authorizes every update, NewPassword replaces it
 	OldPassword string `json:"old_password,omitempty" bson:"-"`
 	NewPassword string `json:"new_password,omitempty" bson:"-"`
+	// Version is the expected current version taken from If-Match, 0 skips the check
+	Version int64 `json:"-" bson:"-"`
+}
+
+// BatchItem is the outcome of creating one user of a batch, Error is nil
+// when the user was created with ID
+type BatchItem struct {
+	ID    string
+	Error error
 }
 
 func NewUser(dto CreateUserDTO) *User {
@@ -35,17 +53,9 @@ func NewUser(dto CreateUserDTO) *User {
 }
 func UpdatedUser(dto UpdateUserDTO) *User {
 	return &User{
-		ID:           dto.ID,
-		Email:        dto.Email,
-		Username:     dto.Username,
-		PasswordHash: dto.Password,
-	}
-}
-
-func GeneratePasswordHash(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
-	if err != nil {
-		return "", fmt.Errorf("failed to hash password due to error %w", err)
+		ID:       dto.ID,
+		Email:    dto.Email,
+		Username: dto.Username,
+		Version:  dto.Version,
 	}
-	return string(hash), nil
 }
@@ -0,0 +1,54 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"rest-api-go/internal/entities/user"
+	"strconv"
+	"time"
+)
+
+// csvHeader lists the exported columns, the password hash is never one
+var csvHeader = []string{"id", "username", "email", "created_at", "updated_at", "version"}
+
+func init() {
+	Register("csv", Format{
+		ContentType: "text/csv; charset=utf-8",
+		Extension:   "csv",
+		NewWriter: func(w io.Writer) Writer {
+			return &csvWriter{writer: csv.NewWriter(w)}
+		},
+	})
+}
+
+type csvWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvWriter) Write(u user.User) error {
+	if !w.headerWritten {
+		if err := w.writer.Write(csvHeader); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+	return w.writer.Write([]string{
+		u.ID,
+		u.Username,
+		u.Email,
+		u.CreatedAt.UTC().Format(time.RFC3339Nano),
+		u.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		strconv.FormatInt(u.Version, 10),
+	})
+}
+func (w *csvWriter) Close() error {
+	if !w.headerWritten {
+		// an empty export still names its columns
+		if err := w.writer.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
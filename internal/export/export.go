@@ -0,0 +1,58 @@
+// Package export holds the formats users can be exported in. Formats
+// register themselves by name, so adding one needs no change elsewhere.
+package export
+
+import (
+	"io"
+	"rest-api-go/internal/entities/user"
+	"sort"
+	"sync"
+)
+
+// Writer writes users one at a time in a format. Close flushes what is
+// buffered and writes any trailer, it does not close the underlying writer.
+type Writer interface {
+	Write(u user.User) error
+	Close() error
+}
+
+// Format describes how to write an export
+type Format struct {
+	ContentType string
+	// Extension is the file extension without the dot
+	Extension string
+	NewWriter func(w io.Writer) Writer
+}
+
+var (
+	mu      sync.RWMutex
+	formats = map[string]Format{}
+)
+
+// Register makes a format available under name, replacing any format
+// registered under it before
+func Register(name string, format Format) {
+	mu.Lock()
+	defer mu.Unlock()
+	formats[name] = format
+}
+
+// Lookup returns the format registered under name
+func Lookup(name string) (Format, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	format, ok := formats[name]
+	return format, ok
+}
+
+// Names returns the names of all registered formats, sorted
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,33 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"rest-api-go/internal/entities/user"
+)
+
+func init() {
+	Register("ndjson", Format{
+		ContentType: "application/x-ndjson",
+		Extension:   "ndjson",
+		NewWriter: func(w io.Writer) Writer {
+			buffered := bufio.NewWriter(w)
+			return &ndjsonWriter{buffered: buffered, encoder: json.NewEncoder(buffered)}
+		},
+	})
+}
+
+// ndjsonWriter writes one JSON object per line. user.User never marshals
+// its password hash.
+type ndjsonWriter struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(u user.User) error {
+	return w.encoder.Encode(u)
+}
+func (w *ndjsonWriter) Close() error {
+	return w.buffered.Flush()
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"rest-api-go/internal/apperrors"
+	authEntity "rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/handlers/interfaces"
+	"rest-api-go/internal/handlers/router"
+	"rest-api-go/internal/service"
+
+	"rest-api-go/pkg/logging"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	loginUrl          = "/auth/login"
+	refreshUrl        = "/auth/refresh"
+	logoutUrl         = "/auth/logout"
+	revokeSessionsUrl = "/users/:uuid/sessions:revokeAll"
+	// JWKSUrl belongs to no tenant, the keys sign the tokens of all of them
+	JWKSUrl = "/.well-known/jwks.json"
+)
+
+type AuthHandler struct {
+	logger      *logging.Logger
+	authService service.AuthService
+}
+
+func NewAuthHandler(logger *logging.Logger, authService service.AuthService) interfaces.Handler {
+	return &AuthHandler{
+		logger:      logger,
+		authService: authService,
+	}
+}
+
+func (h *AuthHandler) Register(router *router.Router) {
+	router.HandlerFunc(http.MethodPost, loginUrl, apperrors.Middleware(h.Login))
+	router.HandlerFunc(http.MethodPost, refreshUrl, apperrors.Middleware(h.Refresh))
+	router.HandlerFunc(http.MethodPost, logoutUrl, apperrors.Middleware(h.Logout))
+	router.HandlerFunc(http.MethodGet, JWKSUrl, apperrors.Middleware(h.GetJWKS))
+	router.Route(http.MethodPost, revokeSessionsUrl, apperrors.Middleware(h.RevokeSessions))
+}
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("LOGIN")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.logger.Debug("decode login dto")
+	var login authEntity.LoginDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
+		return apperrors.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+
+	token, err := h.authService.Login(r.Context(), login)
+	if err != nil {
+		return err
+	}
+
+	h.logger.Debug("marshal token")
+	tokenBytes, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshall token. error: %w", err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(tokenBytes)
+	return nil
+}
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("REFRESH")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.logger.Debug("decode refresh dto")
+	var refresh authEntity.RefreshDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&refresh); err != nil {
+		return apperrors.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+
+	token, err := h.authService.Refresh(r.Context(), refresh)
+	if err != nil {
+		return err
+	}
+
+	h.logger.Debug("marshal token")
+	tokenBytes, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshall token. error: %w", err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(tokenBytes)
+	return nil
+}
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("LOGOUT")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.logger.Debug("decode refresh dto")
+	var refresh authEntity.RefreshDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&refresh); err != nil {
+		return apperrors.BadRequestError("invalid JSON scheme. check swagger API")
+	}
+
+	if err := h.authService.Logout(r.Context(), refresh); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+func (h *AuthHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("REVOKE SESSIONS")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.logger.Debug("get uuid from context")
+	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
+	userUUID := params.ByName("uuid")
+
+	if err := h.authService.RevokeSessions(r.Context(), userUUID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+func (h *AuthHandler) GetJWKS(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("GET JWKS")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.logger.Debug("marshal jwks")
+	jwksBytes, err := json.Marshal(h.authService.JWKS())
+	if err != nil {
+		return fmt.Errorf("failed to marshall jwks. error: %w", err)
+	}
+
+	// verifiers may cache the keys, a rotation publishes the next key ahead
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jwksBytes)
+	return nil
+}
@@ -1,7 +1,7 @@
 package interfaces
 
-import "github.com/julienschmidt/httprouter"
+import "rest-api-go/internal/handlers/router"
 
 type Handler interface {
-	Register(router *httprouter.Router)
+	Register(router *router.Router)
 }
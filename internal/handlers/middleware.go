@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"rest-api-go/internal/requestctx"
+	"strings"
+)
+
+// ActorHeader names the caller recorded in the user history when the
+// request carries no access token
+const ActorHeader = "X-Actor"
+
+// Authenticator verifies access tokens, such as service.AuthService
+type Authenticator interface {
+	Authenticate(token string) (userID, tenant string, err error)
+}
+
+// WithActor puts the caller of every request into its context. The caller
+// is the user a bearer access token was issued to, if authenticator accepts
+// it for the tenant of the request. Otherwise the X-Actor header is recorded
+// as unverified, it is only what the caller claims to be. authenticator may
+// be nil when signing in is disabled.
+func WithActor(next http.Handler, authenticator Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if actor := verifiedActor(r, authenticator); actor != "" {
+			r = r.WithContext(requestctx.WithActor(r.Context(), actor))
+		} else if actor := r.Header.Get(ActorHeader); actor != "" {
+			r = r.WithContext(requestctx.WithActor(r.Context(), requestctx.UnverifiedActor(actor)))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifiedActor returns the user of the bearer token of r, or "" if there is
+// no valid one for the tenant of r
+func verifiedActor(r *http.Request, authenticator Authenticator) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if authenticator == nil || !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	userID, tenant, err := authenticator.Authenticate(strings.TrimSpace(token))
+	if err != nil || tenant != requestctx.Tenant(r.Context()) {
+		return ""
+	}
+	return userID
+}
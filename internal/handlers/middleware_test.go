@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"rest-api-go/internal/requestctx"
+	"testing"
+)
+
+// tokens accepts the token "valid" of user u1 of tenant acme
+type tokens struct{}
+
+func (tokens) Authenticate(token string) (string, string, error) {
+	if token != "valid" {
+		return "", "", errors.New("invalid token")
+	}
+	return "u1", "acme", nil
+}
+
+func TestWithActor(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticator Authenticator
+		tenant        string
+		authorization string
+		actor         string
+		want          string
+	}{
+		{"nothing", tokens{}, "acme", "", "", requestctx.AnonymousActor},
+		{"bearer token", tokens{}, "acme", "Bearer valid", "", "u1"},
+		{"bearer token over header", tokens{}, "acme", "bearer valid", "admin", "u1"},
+		{"token of another tenant", tokens{}, "globex", "Bearer valid", "admin", "unverified:admin"},
+		{"invalid token", tokens{}, "acme", "Bearer forged", "", requestctx.AnonymousActor},
+		{"other scheme", tokens{}, "acme", "Basic valid", "admin", "unverified:admin"},
+		{"header only", tokens{}, "acme", "", "admin", "unverified:admin"},
+		{"signing in disabled", nil, "acme", "Bearer valid", "admin", "unverified:admin"},
+	}
+	for _, tt := range tests {
+		var got string
+		handler := WithActor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = requestctx.Actor(r.Context())
+		}), tt.authenticator)
+		req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		req = req.WithContext(requestctx.WithTenant(req.Context(), tt.tenant))
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		if tt.actor != "" {
+			req.Header.Set(ActorHeader, tt.actor)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tt.want {
+			t.Errorf("%s: got actor %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,16 +1,19 @@
 package handlers
 
 import (
+	"rest-api-go/internal/handlers/auth"
+	"rest-api-go/internal/handlers/router"
 	"rest-api-go/internal/handlers/user"
 	"rest-api-go/internal/service"
 	"rest-api-go/pkg/logging"
-
-	"github.com/julienschmidt/httprouter"
 )
 
-func RegisterHandlers(router *httprouter.Router, service *service.Service, logger *logging.Logger) {
+func RegisterHandlers(router *router.Router, service *service.Service, logger *logging.Logger) {
 	//register handlers here
 	handler := user.NewUserHandler(logger, service.UserService)
 	handler.Register(router)
 
+	if service.AuthService != nil {
+		auth.NewAuthHandler(logger, service.AuthService).Register(router)
+	}
 }
@@ -0,0 +1,76 @@
+// Package router extends httprouter with the routes its tree cannot hold.
+package router
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Router is an httprouter.Router that also matches routes registered with
+// Route before looking at its tree. httprouter rejects a static segment next
+// to a parameter, like /users/export beside /users/:uuid, and custom methods
+// like /users:batchCreate, because it reads ':' as the start of a parameter.
+type Router struct {
+	*httprouter.Router
+	routes []route
+}
+
+type route struct {
+	method string
+	// segments starting with ':' are parameters, all others match literally
+	segments []string
+	handler  http.Handler
+}
+
+// Route registers handler for method and path. Only whole segments starting
+// with ':' are parameters, so ':' anywhere else is matched literally. Routes
+// are tried in the order they were registered.
+func (r *Router) Route(method, path string, handler http.HandlerFunc) {
+	r.routes = append(r.routes, route{
+		method:   method,
+		segments: strings.Split(strings.Trim(path, "/"), "/"),
+		handler:  handler,
+	})
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for _, route := range r.routes {
+		if route.method != req.Method {
+			continue
+		}
+		if params, ok := route.match(segments); ok {
+			ctx := context.WithValue(req.Context(), httprouter.ParamsKey, params)
+			route.handler.ServeHTTP(w, req.WithContext(ctx))
+			return
+		}
+	}
+	r.Router.ServeHTTP(w, req)
+}
+
+func (rt route) match(segments []string) (httprouter.Params, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	var params httprouter.Params
+	for i, segment := range rt.segments {
+		if strings.HasPrefix(segment, ":") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params = append(params, httprouter.Param{Key: segment[1:], Value: segments[i]})
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func New() *Router {
+	return &Router{Router: httprouter.New()}
+}
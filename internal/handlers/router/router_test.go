@@ -0,0 +1,53 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func respond(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name + " " + httprouter.ParamsFromContext(r.Context()).ByName("uuid")))
+	}
+}
+
+func TestRouter(t *testing.T) {
+	router := New()
+	router.Route(http.MethodPost, "/api/users:batchCreate", respond("batch"))
+	router.Route(http.MethodGet, "/api/users/export", respond("export"))
+	router.Route(http.MethodPost, "/api/users/:uuid/restore", respond("restore by segment"))
+	router.HandlerFunc(http.MethodGet, "/api/users/:uuid", respond("get"))
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		want   string
+	}{
+		{http.MethodPost, "/api/users:batchCreate", http.StatusOK, "batch "},
+		{http.MethodGet, "/api/users/export", http.StatusOK, "export "},
+		{http.MethodGet, "/api/users/export/", http.StatusOK, "export "},
+		{http.MethodPost, "/api/users/1234/restore", http.StatusOK, "restore by segment 1234"},
+		// ':' inside a segment is literal
+		{http.MethodPost, "/api/users:batchcreate", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/users/1234", http.StatusOK, "get 1234"},
+		{http.MethodPost, "/api/users//restore", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/users:batchCreate", http.StatusNotFound, ""},
+		{http.MethodDelete, "/api/users/1234", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+			continue
+		}
+		if tt.want != "" && rec.Body.String() != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.path, rec.Body.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/requestctx"
+	"strings"
+)
+
+// tenantPattern accepts DNS labels so every tenant can also be a subdomain
+var tenantPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// TenantResolver finds the tenant of a request in its header or in the
+// subdomain of its host
+type TenantResolver struct {
+	// Header names the tenant, it is ignored when empty
+	Header string
+	// Domain is the parent domain of the tenant subdomains, it is ignored
+	// when empty
+	Domain string
+	// Default is the tenant of requests that name none, empty rejects them
+	Default string
+	// Global are the paths of resources that belong to no tenant, their
+	// requests are served without one
+	Global []string
+}
+
+// Resolve returns the tenant of r. A request may name its tenant in both
+// the header and the host, but they must agree.
+func (t TenantResolver) Resolve(r *http.Request) (string, error) {
+	var fromHeader, fromHost string
+	if t.Header != "" {
+		fromHeader = strings.ToLower(strings.TrimSpace(r.Header.Get(t.Header)))
+	}
+	if t.Domain != "" {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		suffix := "." + strings.ToLower(t.Domain)
+		if strings.HasSuffix(host, suffix) {
+			fromHost = strings.TrimSuffix(host, suffix)
+		}
+	}
+
+	tenant := fromHeader
+	switch {
+	case fromHeader != "" && fromHost != "" && fromHeader != fromHost:
+		return "", apperrors.BadRequestError(fmt.Sprintf("tenant %q of the %s header does not match the host", fromHeader, t.Header))
+	case tenant == "":
+		tenant = fromHost
+	}
+	if tenant == "" {
+		tenant = t.Default
+	}
+	if tenant == "" {
+		return "", apperrors.BadRequestError("missing tenant")
+	}
+	if !tenantPattern.MatchString(tenant) {
+		return "", apperrors.BadRequestError(fmt.Sprintf("malformed tenant %q", tenant))
+	}
+	return tenant, nil
+}
+
+// WithTenant scopes every request to the tenant resolver finds and rejects
+// the ones without a valid tenant, requests to global paths pass unscoped
+func WithTenant(next http.Handler, resolver TenantResolver) http.Handler {
+	return apperrors.Middleware(func(w http.ResponseWriter, r *http.Request) error {
+		for _, path := range resolver.Global {
+			if r.URL.Path == path {
+				next.ServeHTTP(w, r)
+				return nil
+			}
+		}
+		tenant, err := resolver.Resolve(r)
+		if err != nil {
+			return err
+		}
+		next.ServeHTTP(w, r.WithContext(requestctx.WithTenant(r.Context(), tenant)))
+		return nil
+	})
+}
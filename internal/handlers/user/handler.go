@@ -1,13 +1,21 @@
 package user
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"rest-api-go/internal/apperrors"
 	userEntity "rest-api-go/internal/entities/user"
+	"rest-api-go/internal/export"
 	"rest-api-go/internal/handlers/interfaces"
+	"rest-api-go/internal/handlers/router"
 	"rest-api-go/internal/service"
 
 	"rest-api-go/pkg/logging"
@@ -16,8 +24,14 @@ import (
 )
 
 const (
-	usersUrl = "/users"
-	userUrl  = "/users/:uuid"
+	usersUrl       = "/users"
+	userUrl        = "/users/:uuid"
+	restoreUrl     = "/users/:uuid/restore"
+	historyUrl     = "/users/:uuid/history"
+	dataExportUrl  = "/users/:uuid/data-export"
+	eraseUrl       = "/users/:uuid/erase"
+	batchCreateUrl = "/users:batchCreate"
+	exportUrl      = "/users/export"
 )
 
 type UserHandler struct {
@@ -32,21 +46,49 @@ func NewUserHandler(logger *logging.Logger, userService service.UserService) int
 	}
 }
 
-func (h *UserHandler) Register(router *httprouter.Router) {
+func (h *UserHandler) Register(router *router.Router) {
 	router.HandlerFunc(http.MethodGet, usersUrl, apperrors.Middleware(h.GetAll))
 	router.HandlerFunc(http.MethodGet, userUrl, apperrors.Middleware(h.GetUserByUUID))
 	router.HandlerFunc(http.MethodPost, usersUrl, apperrors.Middleware(h.CreateUser))
 	router.HandlerFunc(http.MethodPut, userUrl, apperrors.Middleware(h.UpdateUser))
 	router.HandlerFunc(http.MethodDelete, userUrl, apperrors.Middleware(h.DeleteUser))
+	router.HandlerFunc(http.MethodPost, restoreUrl, apperrors.Middleware(h.RestoreUser))
+	router.HandlerFunc(http.MethodGet, historyUrl, apperrors.Middleware(h.GetUserHistory))
+	router.HandlerFunc(http.MethodGet, dataExportUrl, apperrors.Middleware(h.GetUserDataExport))
+	router.HandlerFunc(http.MethodPost, eraseUrl, apperrors.Middleware(h.EraseUser))
+	router.Route(http.MethodPost, batchCreateUrl, apperrors.Middleware(h.BatchCreateUsers))
+	router.Route(http.MethodGet, exportUrl, apperrors.Middleware(h.ExportUsers))
 
 }
 func (h *UserHandler) GetAll(w http.ResponseWriter, r *http.Request) error {
-	users, err := h.userService.FindAll(r.Context())
+	values := r.URL.Query()
+	query := userEntity.ListQuery{
+		Cursor:         values.Get("cursor"),
+		Email:          values.Get("email"),
+		Username:       values.Get("username"),
+		UsernamePrefix: values.Get("username_prefix"),
+	}
+	var err error
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			return apperrors.BadRequestError("limit must be a number")
+		}
+	}
+	if createdAfter := values.Get("created_after"); createdAfter != "" {
+		if query.CreatedAfter, err = time.Parse(time.RFC3339, createdAfter); err != nil {
+			return apperrors.BadRequestError("created_after must be an RFC 3339 timestamp")
+		}
+	}
+	if query.Sort, err = userEntity.ParseSort(values.Get("sort")); err != nil {
+		return apperrors.BadRequestError(err.Error())
+	}
+
+	page, err := h.userService.FindAll(r.Context(), query)
 	if err != nil {
 		return err
 	}
 
-	usersJSON, err := json.Marshal(users)
+	usersJSON, err := json.Marshal(page)
 	if err != nil {
 		http.Error(w, "Failed to marshal users", http.StatusInternalServerError)
 		return err
@@ -56,6 +98,59 @@ func (h *UserHandler) GetAll(w http.ResponseWriter, r *http.Request) error {
 	w.Write(usersJSON)
 	return nil
 }
+
+// ExportUsers streams every user in the format named by the format query
+// parameter, ndjson by default
+func (h *UserHandler) ExportUsers(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("EXPORT USERS")
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "ndjson"
+	}
+	format, ok := export.Lookup(name)
+	if !ok {
+		return apperrors.BadRequestError(fmt.Sprintf("unknown format %q, use one of %s",
+			name, strings.Join(export.Names(), ", ")))
+	}
+
+	// exports of many users outlive the write timeout of the server
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.Warnf("failed to lift write deadline of export due to error %v", err)
+	}
+
+	// set before streaming, an export without users writes nothing at all
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, format.Extension))
+	out := &exportResponse{w: w}
+	writer := format.NewWriter(out)
+	err := h.userService.Export(r.Context(), writer.Write)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil && out.started {
+		// the status is gone already, break the connection so the client
+		// cannot mistake a truncated export for a complete one
+		h.logger.Errorf("failed to export users due to error %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	if err != nil {
+		// answered as usual, the error replaces the content type
+		w.Header().Del("Content-Disposition")
+	}
+	return err
+}
+
+// exportResponse tells whether the export has sent its first bytes, after
+// which an error can no longer be answered as usual
+type exportResponse struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (e *exportResponse) Write(p []byte) (int, error) {
+	e.started = true
+	return e.w.Write(p)
+}
 func (h *UserHandler) GetUserByUUID(w http.ResponseWriter, r *http.Request) error {
 	h.logger.Info("GET USER")
 	w.Header().Set("Content-Type", "application/json")
@@ -69,6 +164,15 @@ func (h *UserHandler) GetUserByUUID(w http.ResponseWriter, r *http.Request) erro
 		return err
 	}
 
+	w.Header().Set("ETag", etag(user.Version))
+	if !user.UpdatedAt.IsZero() {
+		w.Header().Set("Last-Modified", user.UpdatedAt.UTC().Format(http.TimeFormat))
+		if notModified(r, user.UpdatedAt) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
 	h.logger.Debug("marshal user")
 	userBytes, err := json.Marshal(user)
 	if err != nil {
@@ -100,6 +204,121 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) error {
 
 	return nil
 }
+
+// batchItem is the outcome of one user of a batch create
+type batchItem struct {
+	Index  int                 `json:"index"`
+	Status int                 `json:"status"`
+	ID     string              `json:"id,omitempty"`
+	Error  *apperrors.AppError `json:"error,omitempty"`
+}
+type batchReport struct {
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Items   []batchItem `json:"items"`
+}
+
+// BatchCreateUsers creates the users of a JSON array or NDJSON body. With
+// mode=best_effort every valid user is created, by default none is created
+// unless all of them can be. The status is 207 when any user failed.
+func (h *UserHandler) BatchCreateUsers(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("BATCH CREATE USERS")
+	w.Header().Set("Content-Type", "application/json")
+
+	var atomic bool
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "atomic":
+		atomic = true
+	case "best_effort":
+	default:
+		return apperrors.BadRequestError(fmt.Sprintf("unknown mode %q, use atomic or best_effort", mode))
+	}
+
+	h.logger.Debug("decode create user dtos")
+	defer r.Body.Close()
+	dtos, err := decodeBatch(r.Body)
+	if err != nil {
+		return err
+	}
+
+	items, err := h.userService.CreateMany(r.Context(), dtos, atomic)
+	if err != nil {
+		return err
+	}
+
+	report := batchReport{Items: make([]batchItem, len(items))}
+	for i, item := range items {
+		report.Items[i] = batchItem{Index: i, Status: http.StatusCreated, ID: item.ID}
+		if item.Error == nil {
+			report.Created++
+			continue
+		}
+		report.Failed++
+		var appErr *apperrors.AppError
+		if !errors.As(item.Error, &appErr) {
+			appErr = apperrors.SystemError(item.Error)
+		}
+		report.Items[i].Status = appErr.StatusCode()
+		report.Items[i].Error = appErr
+	}
+
+	h.logger.Debug("marshal batch report")
+	reportBytes, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshall batch report. error: %w", err)
+	}
+
+	status := http.StatusOK
+	if report.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	w.WriteHeader(status)
+	w.Write(reportBytes)
+	return nil
+}
+
+// decodeBatch reads the dtos of a JSON array, or of a stream of JSON objects
+// such as NDJSON, stopping after one more than userEntity.MaxBatchSize
+func decodeBatch(body io.Reader) (dtos []userEntity.CreateUserDTO, err error) {
+	reader := bufio.NewReader(body)
+	var isArray bool
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read batch. error: %w", err)
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			isArray = b == '['
+			_ = reader.UnreadByte()
+			break
+		}
+	}
+
+	decoder := json.NewDecoder(reader)
+	if isArray {
+		// consume the opening bracket so elements decode one by one
+		if _, err := decoder.Token(); err != nil {
+			return nil, apperrors.BadRequestError("invalid JSON scheme. check swagger API")
+		}
+	}
+	for len(dtos) <= userEntity.MaxBatchSize {
+		if isArray && !decoder.More() {
+			break
+		}
+		var dto userEntity.CreateUserDTO
+		if err := decoder.Decode(&dto); err != nil {
+			if err == io.EOF && !isArray {
+				break
+			}
+			return nil, apperrors.BadRequestError(fmt.Sprintf("invalid JSON at item %d. check swagger API", len(dtos)))
+		}
+		dtos = append(dtos, dto)
+	}
+	return dtos, nil
+}
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) error {
 	h.logger.Info("PARTIALLY UPDATE USER")
 	w.Header().Set("Content-Type", "application/json")
@@ -115,7 +334,13 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) error {
 	}
 	updUser.ID = userUUID
 
-	err := h.userService.Update(r.Context(), updUser)
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		return err
+	}
+	updUser.Version = version
+
+	err = h.userService.Update(r.Context(), updUser)
 	if err != nil {
 		return err
 	}
@@ -131,7 +356,12 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) error {
 	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
 	userUUID := params.ByName("uuid")
 
-	err := h.userService.Delete(r.Context(), userUUID)
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		return err
+	}
+
+	err = h.userService.Delete(r.Context(), userUUID, version)
 	if err != nil {
 		return err
 	}
@@ -140,3 +370,114 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) error {
 
 	return nil
 }
+func (h *UserHandler) RestoreUser(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("RESTORE USER")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.logger.Debug("get uuid from context")
+	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
+	userUUID := params.ByName("uuid")
+
+	err := h.userService.Restore(r.Context(), userUUID)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+func (h *UserHandler) GetUserHistory(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("GET USER HISTORY")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.logger.Debug("get uuid from context")
+	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
+	userUUID := params.ByName("uuid")
+
+	records, err := h.userService.History(r.Context(), userUUID)
+	if err != nil {
+		return err
+	}
+
+	h.logger.Debug("marshal history")
+	historyBytes, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to marshall history. error: %w", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(historyBytes)
+	return nil
+}
+
+func (h *UserHandler) GetUserDataExport(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("GET USER DATA EXPORT")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.logger.Debug("get uuid from context")
+	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
+	userUUID := params.ByName("uuid")
+
+	archive, err := h.userService.DataExport(r.Context(), userUUID)
+	if err != nil {
+		return err
+	}
+
+	h.logger.Debug("marshal data export")
+	archiveBytes, err := json.Marshal(archive)
+	if err != nil {
+		return fmt.Errorf("failed to marshall data export. error: %w", err)
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%s.json"`, userUUID))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(archiveBytes)
+	return nil
+}
+func (h *UserHandler) EraseUser(w http.ResponseWriter, r *http.Request) error {
+	h.logger.Info("ERASE USER")
+	w.Header().Set("Content-Type", "application/json")
+
+	h.logger.Debug("get uuid from context")
+	params := r.Context().Value(httprouter.ParamsKey).(httprouter.Params)
+	userUUID := params.ByName("uuid")
+
+	err := h.userService.Erase(r.Context(), userUUID)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// etag formats a user version as a strong entity tag
+func etag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// notModified reports whether the If-Modified-Since header is at or after
+// updatedAt. HTTP dates have second precision, so updatedAt is truncated.
+func notModified(r *http.Request, updatedAt time.Time) bool {
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !updatedAt.Truncate(time.Second).After(since)
+}
+
+// ifMatchVersion returns the version expected by the If-Match header.
+// A missing header or "*" returns 0, which skips the version check.
+func ifMatchVersion(r *http.Request) (int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+	// versions are strong validators, a weak tag can never match
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil || version <= 0 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return 0, apperrors.ErrPreconditionFailed
+	}
+	return version, nil
+}
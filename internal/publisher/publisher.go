@@ -0,0 +1,54 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"rest-api-go/internal/entities/outbox"
+	"sync"
+)
+
+// Publisher delivers outbox events to downstream systems. It may be called
+// more than once for the same event, consumers must deduplicate by ID.
+type Publisher interface {
+	Publish(ctx context.Context, event outbox.Event) error
+}
+
+// WriterPublisher writes every event as one JSON line, for local runs
+type WriterPublisher struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func (p *WriterPublisher) Publish(ctx context.Context, event outbox.Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event %s: %w", event.ID, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.writer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write event %s: %w", event.ID, err)
+	}
+	return nil
+}
+
+func NewWriterPublisher(writer io.Writer) *WriterPublisher {
+	return &WriterPublisher{writer: writer}
+}
+
+// NewFilePublisher appends events to the file at path
+func NewFilePublisher(path string) (*WriterPublisher, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterPublisher(file), nil
+}
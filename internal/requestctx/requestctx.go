@@ -0,0 +1,45 @@
+// Package requestctx carries request scoped values from the handlers down
+// to the service layer without the service depending on net/http.
+package requestctx
+
+import "context"
+
+type ctxKey int
+
+const (
+	actorKey ctxKey = iota
+	tenantKey
+)
+
+// AnonymousActor is recorded when a request doesn't identify its caller
+const AnonymousActor = "anonymous"
+
+// UnverifiedActor marks an actor the caller named without proving it
+func UnverifiedActor(actor string) string {
+	return "unverified:" + actor
+}
+
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// Actor returns who performs the request, or AnonymousActor
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey).(string); ok && actor != "" {
+		return actor
+	}
+	return AnonymousActor
+}
+
+// DefaultTenant owns the users stored before tenancy was introduced
+const DefaultTenant = "default"
+
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// Tenant returns the tenant the request is scoped to, or "" if it has none
+func Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey).(string)
+	return tenant
+}
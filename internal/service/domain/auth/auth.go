@@ -0,0 +1,335 @@
+// Package auth signs users in and issues the tokens they authenticate with.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	authEntity "rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/internal/service/domain/ids"
+	"rest-api-go/internal/service/domain/password"
+	"rest-api-go/internal/storage"
+	"rest-api-go/pkg/logging"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Claims are the claims of an access token. The subject is the user id.
+type Claims struct {
+	// Tenant is the tenant the user signed in to
+	Tenant string `json:"tid"`
+	jwt.RegisteredClaims
+}
+
+// Tokens describes the tokens an AuthService issues
+type Tokens struct {
+	Signer *Signer
+	// Issuer and Audience are the iss and aud claims of every token
+	Issuer   string
+	Audience string
+	// AccessTokenTTL is how long an access token is valid
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is how long a refresh token can be exchanged, every
+	// refresh issues a new one valid for as long
+	RefreshTokenTTL time.Duration
+}
+
+// AuthService signs users in with their password and keeps them signed in
+// with refresh tokens. Every refresh token is exchanged once for an access
+// token and its successor, the tokens rotated from one sign in form a
+// family. A token used a second time was stolen from the client or by it,
+// so its whole family is revoked.
+type AuthService struct {
+	logger                 *logging.Logger
+	UserRepository         storage.UserRepository
+	RefreshTokenRepository storage.RefreshTokenRepository
+	// Transactor makes the rotation of a refresh token one unit of work
+	Transactor storage.Transactor
+	// Hasher verifies passwords and rehashes outdated hashes
+	Hasher password.PasswordHasher
+	Tokens Tokens
+	// Clock returns the current time, tests can replace it
+	Clock func() time.Time
+
+	decoyOnce sync.Once
+	decoy     string
+}
+
+// Login signs a user of the tenant of ctx in by username, or by email when
+// the login holds an @, and returns an access token and the first refresh
+// token of a new family. A hash that Hasher finds outdated is replaced by a
+// new one of the same password.
+func (s *AuthService) Login(ctx context.Context, dto authEntity.LoginDTO) (authEntity.Token, error) {
+	s.logger.Debug("find user by login")
+	found, err := s.findByLogin(ctx, dto.Login)
+	if errors.Is(err, apperrors.ErrNotFound) {
+		// spend the time of a verification so unknown logins do not answer faster
+		s.Hasher.Verify(s.decoyHash(), dto.Password)
+		return authEntity.Token{}, apperrors.ErrInvalidCredentials
+	}
+	if err != nil {
+		return authEntity.Token{}, err
+	}
+
+	s.logger.Debug("verify password")
+	match, outdated, err := s.Hasher.Verify(found.PasswordHash, dto.Password)
+	if err != nil {
+		return authEntity.Token{}, fmt.Errorf("failed to verify password. error %w", err)
+	}
+	if !match {
+		return authEntity.Token{}, apperrors.ErrInvalidCredentials
+	}
+	if outdated {
+		s.rehash(ctx, found, dto.Password)
+	}
+	return s.issue(ctx, found.ID, uuid.NewString())
+}
+
+// errRevokeFamily rolls back a refresh whose token family must be revoked
+var errRevokeFamily = errors.New("refresh token family must be revoked")
+
+// Refresh exchanges a refresh token for an access token and a new refresh
+// token of the same family. Clients retrying a refresh whose response they
+// lost present a used token too and are signed out.
+func (s *AuthService) Refresh(ctx context.Context, dto authEntity.RefreshDTO) (token authEntity.Token, err error) {
+	hash := hashToken(dto.RefreshToken)
+	var stored authEntity.RefreshToken
+	var reason string
+	err = s.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		now := s.now()
+		stored, err = s.RefreshTokenRepository.FindByHash(ctx, hash)
+		if errors.Is(err, apperrors.ErrNotFound) {
+			return apperrors.ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find refresh token. error: %w", err)
+		}
+		if stored.RevokedAt != nil || !now.Before(stored.ExpiresAt) {
+			return apperrors.ErrInvalidRefreshToken
+		}
+		if stored.RotatedAt != nil {
+			reason = "was used again"
+			return errRevokeFamily
+		}
+
+		err = s.RefreshTokenRepository.Rotate(ctx, hash, now)
+		if errors.Is(err, apperrors.ErrPreconditionFailed) {
+			reason = "was used concurrently"
+			return errRevokeFamily
+		}
+		if err != nil {
+			return fmt.Errorf("failed to rotate refresh token. error: %w", err)
+		}
+		_, err = s.UserRepository.FindOne(ctx, stored.UserID)
+		if errors.Is(err, apperrors.ErrNotFound) {
+			reason = "belongs to a deleted user"
+			return errRevokeFamily
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find user of refresh token. error: %w", err)
+		}
+
+		token, err = s.issue(ctx, stored.UserID, stored.FamilyID)
+		return err
+	})
+	if errors.Is(err, errRevokeFamily) {
+		s.logger.Warnf("refresh token of family %s %s, revoking the family", stored.FamilyID, reason)
+		if err := s.RefreshTokenRepository.RevokeFamily(ctx, stored.FamilyID, s.now()); err != nil {
+			return authEntity.Token{}, fmt.Errorf("failed to revoke refresh token family. error: %w", err)
+		}
+		return authEntity.Token{}, apperrors.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return authEntity.Token{}, err
+	}
+	return token, nil
+}
+
+// Logout revokes the family of a refresh token, which signs out the client
+// holding it. Unknown tokens are ignored, there is nobody to sign out.
+func (s *AuthService) Logout(ctx context.Context, dto authEntity.RefreshDTO) error {
+	stored, err := s.RefreshTokenRepository.FindByHash(ctx, hashToken(dto.RefreshToken))
+	if errors.Is(err, apperrors.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find refresh token. error: %w", err)
+	}
+	if err := s.RefreshTokenRepository.RevokeFamily(ctx, stored.FamilyID, s.now()); err != nil {
+		return fmt.Errorf("failed to revoke refresh token family. error: %w", err)
+	}
+	return nil
+}
+
+// RevokeSessions revokes every refresh token of a user, which signs them out
+// everywhere once their access tokens expire
+func (s *AuthService) RevokeSessions(ctx context.Context, userID string) error {
+	if !ids.Valid(userID) {
+		return apperrors.BadRequestError(fmt.Sprintf("malformed id %q", userID))
+	}
+	if _, err := s.UserRepository.FindOne(ctx, userID); err != nil {
+		if errors.Is(err, apperrors.ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to find user by uuid. error: %w", err)
+	}
+	if err := s.RefreshTokenRepository.RevokeUser(ctx, userID, s.now()); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens. error: %w", err)
+	}
+	return nil
+}
+
+// JWKS returns the public keys access tokens can be verified with
+func (s *AuthService) JWKS() authEntity.JWKSet {
+	return s.Tokens.Signer.JWKS()
+}
+
+func (s *AuthService) findByLogin(ctx context.Context, login string) (user.User, error) {
+	query := user.ListQuery{Limit: 1}
+	if strings.Contains(login, "@") {
+		query.Email = login
+	} else {
+		query.Username = login
+	}
+	page, err := s.UserRepository.FindAll(ctx, query)
+	if err != nil {
+		return user.User{}, fmt.Errorf("failed to find user by login. error: %w", err)
+	}
+	if len(page.Users) == 0 {
+		return user.User{}, apperrors.ErrNotFound
+	}
+	return page.Users[0], nil
+}
+
+// rehash stores a new hash of the password of u. The password itself does
+// not change, so no history is recorded and no event is published. A
+// failure only costs another rehash at the next sign in.
+func (s *AuthService) rehash(ctx context.Context, u user.User, plain string) {
+	s.logger.Debug("rehash outdated password hash")
+	hash, err := s.Hasher.Hash(plain)
+	if err == nil {
+		err = s.UserRepository.Update(ctx, user.User{ID: u.ID, PasswordHash: hash, Version: u.Version})
+	}
+	if err != nil {
+		s.logger.Warnf("failed to rehash password of user %s due to error %v", u.ID, err)
+	}
+}
+
+// Authenticate verifies an access token issued by this service and returns
+// the user and the tenant it was issued to
+func (s *AuthService) Authenticate(token string) (userID, tenant string, err error) {
+	var claims Claims
+	err = s.Tokens.Signer.Verify(token, &claims,
+		jwt.WithIssuer(s.Tokens.Issuer),
+		jwt.WithAudience(s.Tokens.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(s.Clock))
+	if err != nil || claims.Subject == "" {
+		return "", "", apperrors.ErrInvalidAccessToken
+	}
+	return claims.Subject, claims.Tenant, nil
+}
+
+// now returns the current time with the millisecond precision every backend keeps
+func (s *AuthService) now() time.Time {
+	return s.Clock().UTC().Truncate(time.Millisecond)
+}
+
+// issue signs an access token for the user with id and stores a new refresh
+// token of family
+func (s *AuthService) issue(ctx context.Context, id, family string) (authEntity.Token, error) {
+	now := s.now()
+	claims := Claims{
+		Tenant: requestctx.Tenant(ctx),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    s.Tokens.Issuer,
+			Subject:   id,
+			Audience:  jwt.ClaimStrings{s.Tokens.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.Tokens.AccessTokenTTL)),
+		},
+	}
+	signed, err := s.Tokens.Signer.Sign(claims)
+	if err != nil {
+		return authEntity.Token{}, err
+	}
+
+	refresh, err := newRefreshToken()
+	if err != nil {
+		return authEntity.Token{}, err
+	}
+	err = s.RefreshTokenRepository.Create(ctx, authEntity.RefreshToken{
+		Hash:      hashToken(refresh),
+		UserID:    id,
+		FamilyID:  family,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(s.Tokens.RefreshTokenTTL),
+	})
+	if err != nil {
+		return authEntity.Token{}, fmt.Errorf("failed to store refresh token. error: %w", err)
+	}
+	return authEntity.Token{
+		AccessToken:  signed,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.Tokens.AccessTokenTTL / time.Second),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// refreshTokenSize is the number of random bytes of a refresh token
+const refreshTokenSize = 32
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, refreshTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate refresh token. error: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is the key a refresh token is stored under. The tokens are
+// random, so a fast hash is enough to keep a leak of the store useless.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// decoyHash is a hash of no password made with the current settings
+func (s *AuthService) decoyHash() string {
+	s.decoyOnce.Do(func() {
+		s.decoy, _ = s.Hasher.Hash(uuid.NewString())
+	})
+	return s.decoy
+}
+
+func NewAuthService(
+	logger *logging.Logger,
+	UserRepository storage.UserRepository,
+	RefreshTokenRepository storage.RefreshTokenRepository,
+	Transactor storage.Transactor,
+	Hasher password.PasswordHasher,
+	Tokens Tokens,
+) *AuthService {
+	return &AuthService{
+		logger:                 logger,
+		UserRepository:         UserRepository,
+		RefreshTokenRepository: RefreshTokenRepository,
+		Transactor:             Transactor,
+		Hasher:                 Hasher,
+		Tokens:                 Tokens,
+		Clock:                  time.Now,
+	}
+}
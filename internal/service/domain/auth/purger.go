@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"rest-api-go/internal/storage"
+	"rest-api-go/pkg/logging"
+	"time"
+)
+
+// Purger deletes refresh tokens that have expired, along with their
+// history of rotations and revocations.
+type Purger struct {
+	logger                 *logging.Logger
+	refreshTokenRepository storage.RefreshTokenRepository
+	interval               time.Duration
+}
+
+// Run purges once immediately and then on every interval until ctx is done.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Purger) purge(ctx context.Context) {
+	expiredBefore := time.Now()
+	purged, err := p.refreshTokenRepository.Purge(ctx, expiredBefore)
+	if err != nil {
+		p.logger.Errorf("failed to purge expired refresh tokens due to error %v", err)
+		return
+	}
+	if purged > 0 {
+		p.logger.Infof("purged %d refresh tokens expired before %s", purged, expiredBefore.Format(time.RFC3339))
+	}
+}
+
+func NewPurger(
+	logger *logging.Logger,
+	refreshTokenRepository storage.RefreshTokenRepository,
+	interval time.Duration,
+) *Purger {
+	return &Purger{
+		logger:                 logger,
+		refreshTokenRepository: refreshTokenRepository,
+		interval:               interval,
+	}
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	authEntity "rest-api-go/internal/entities/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signer signs tokens with a private key read from a PEM file, RSA keys
+// with RS256 and Ed25519 keys with EdDSA. The id of a key is its RFC 7638
+// thumbprint, so it never has to be configured.
+//
+// The public keys of earlier signing keys are still published by JWKS
+// until the tokens they signed have expired.
+type Signer struct {
+	method jwt.SigningMethod
+	key    crypto.Signer
+	kid    string
+	jwks   authEntity.JWKSet
+	// publicKeys are the keys of jwks by kid
+	publicKeys map[string]crypto.PublicKey
+}
+
+// LoadSigner signs with the private key of keyFile and publishes the keys
+// of previousKeyFiles too, which may hold public or private keys
+func LoadSigner(keyFile string, previousKeyFiles []string) (*Signer, error) {
+	key, err := readKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not a private key", keyFile)
+	}
+	jwk, err := publicJWK(private.Public())
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", keyFile, err)
+	}
+	signer := &Signer{key: private, kid: jwk.Kid, jwks: authEntity.JWKSet{Keys: []authEntity.JWK{jwk}},
+		publicKeys: map[string]crypto.PublicKey{jwk.Kid: private.Public()}}
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		signer.method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		signer.method = jwt.SigningMethodEdDSA
+	}
+
+	for _, file := range previousKeyFiles {
+		key, err := readKey(file)
+		if err != nil {
+			return nil, err
+		}
+		if private, ok := key.(crypto.Signer); ok {
+			key = private.Public()
+		}
+		jwk, err := publicJWK(key)
+		if err != nil {
+			return nil, fmt.Errorf("previous key %s: %w", file, err)
+		}
+		if _, ok := signer.publicKeys[jwk.Kid]; !ok {
+			signer.jwks.Keys = append(signer.jwks.Keys, jwk)
+			signer.publicKeys[jwk.Kid] = key
+		}
+	}
+	return signer, nil
+}
+
+// Sign returns the compact serialization of claims signed with the key
+func (s *Signer) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.method, claims)
+	token.Header["kid"] = s.kid
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token. error: %w", err)
+	}
+	return signed, nil
+}
+
+// Verify checks the signature of token against the keys of JWKS and
+// decodes its claims, which the options of parser validate
+func (s *Signer) Verify(token string, claims jwt.Claims, options ...jwt.ParserOption) error {
+	options = append(options, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.publicKeys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		return key, nil
+	}, options...)
+	return err
+}
+
+// JWKS returns the public keys tokens may be verified with
+func (s *Signer) JWKS() authEntity.JWKSet {
+	return s.jwks
+}
+
+// readKey parses the first PEM block of file: a PKCS #1 or PKCS #8 private
+// key or a PKIX public key
+func readKey(file string) (interface{}, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key. error: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s holds no PEM block", file)
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %s holds an unsupported %s block", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %s. error: %w", file, err)
+	}
+	return key, nil
+}
+
+// publicJWK describes a public key, its kid is the base64url SHA-256 of the
+// required members in lexicographic order as RFC 7638 defines it
+func publicJWK(key interface{}) (authEntity.JWK, error) {
+	var jwk authEntity.JWK
+	var thumbprint interface{}
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return jwk, fmt.Errorf("RSA keys must have at least 2048 bits")
+		}
+		jwk = authEntity.JWK{Kty: "RSA", Alg: jwt.SigningMethodRS256.Alg(),
+			N: encodeSegment(key.N.Bytes()), E: encodeSegment(big.NewInt(int64(key.E)).Bytes())}
+		thumbprint = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case ed25519.PublicKey:
+		jwk = authEntity.JWK{Kty: "OKP", Alg: jwt.SigningMethodEdDSA.Alg(), Crv: "Ed25519", X: encodeSegment(key)}
+		thumbprint = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return jwk, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", key)
+	}
+	canonical, err := json.Marshal(thumbprint)
+	if err != nil {
+		return jwk, err
+	}
+	sum := sha256.Sum256(canonical)
+	jwk.Use = "sig"
+	jwk.Kid = encodeSegment(sum[:])
+	return jwk, nil
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
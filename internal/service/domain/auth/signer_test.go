@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func writePKCS8(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "PRIVATE KEY", der)
+}
+
+func writePublic(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "PUBLIC KEY", der)
+}
+
+// verify checks token against the keys of the signer's JWKS only
+func verify(t *testing.T, signer *Signer, token string) *jwt.RegisteredClaims {
+	t.Helper()
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		for _, jwk := range signer.JWKS().Keys {
+			if jwk.Kid != token.Header["kid"] {
+				continue
+			}
+			switch jwk.Kty {
+			case "OKP":
+				x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+				return ed25519.PublicKey(x), err
+			case "RSA":
+				n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+				e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+				return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+			}
+		}
+		return nil, jwt.ErrTokenUnverifiable
+	})
+	if err != nil {
+		t.Fatalf("verifying token: %v", err)
+	}
+	return claims
+}
+
+func TestSignerSignsWithEveryKeyType(t *testing.T) {
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		file string
+		alg  string
+	}{
+		{"Ed25519 PKCS #8", writePKCS8(t, ed), "EdDSA"},
+		{"RSA PKCS #8", writePKCS8(t, rsaKey), "RS256"},
+		{"RSA PKCS #1", writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), "RS256"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := LoadSigner(tt.file, nil)
+			if err != nil {
+				t.Fatalf("LoadSigner: unexpected error: %v", err)
+			}
+			token, err := signer.Sign(jwt.RegisteredClaims{Subject: "alice"})
+			if err != nil {
+				t.Fatalf("Sign: unexpected error: %v", err)
+			}
+			if claims := verify(t, signer, token); claims.Subject != "alice" {
+				t.Fatalf("got subject %q, want alice", claims.Subject)
+			}
+			keys := signer.JWKS().Keys
+			if len(keys) != 1 || keys[0].Alg != tt.alg || keys[0].Use != "sig" {
+				t.Fatalf("JWKS: got %+v, want one %s signing key", keys, tt.alg)
+			}
+		})
+	}
+}
+
+func TestSignerPublishesPreviousKeys(t *testing.T) {
+	_, previous, _ := ed25519.GenerateKey(rand.Reader)
+	_, current, _ := ed25519.GenerateKey(rand.Reader)
+	currentFile := writePKCS8(t, current)
+
+	old, err := LoadSigner(writePKCS8(t, previous), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := old.Sign(jwt.RegisteredClaims{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := LoadSigner(currentFile, []string{writePublic(t, previous.Public()), currentFile})
+	if err != nil {
+		t.Fatalf("LoadSigner: unexpected error: %v", err)
+	}
+	if keys := signer.JWKS().Keys; len(keys) != 2 {
+		t.Fatalf("JWKS: got %d keys, want the current and the previous key once each", len(keys))
+	}
+	verify(t, signer, token)
+}
+
+func TestSignerRejectsUnusableKeys(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+	for name, file := range map[string]string{
+		"missing file":      filepath.Join(t.TempDir(), "missing.pem"),
+		"no PEM block":      writeFile(t, "not a key"),
+		"unsupported block": writePEM(t, "CERTIFICATE", []byte{1}),
+		"public key":        writePublic(t, ed.Public()),
+		"RSA under 2048":    writePKCS8(t, weak),
+	} {
+		if _, err := LoadSigner(file, nil); err == nil {
+			t.Errorf("LoadSigner of %s: got no error", name)
+		}
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// TestPublicJWKThumbprint checks the kid against the example of RFC 7638
+func TestPublicJWKThumbprint(t *testing.T) {
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := publicJWK(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537})
+	if err != nil {
+		t.Fatalf("publicJWK: unexpected error: %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; jwk.Kid != want {
+		t.Fatalf("got kid %q, want %q", jwk.Kid, want)
+	}
+	if jwk.E != "AQAB" {
+		t.Fatalf("got e %q, want AQAB", jwk.E)
+	}
+}
+
+func TestSignerVerify(t *testing.T) {
+	_, previous, _ := ed25519.GenerateKey(rand.Reader)
+	_, current, _ := ed25519.GenerateKey(rand.Reader)
+	_, stranger, _ := ed25519.GenerateKey(rand.Reader)
+	old, err := LoadSigner(writePKCS8(t, previous), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := LoadSigner(writePKCS8(t, stranger), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := LoadSigner(writePKCS8(t, current), []string{writePublic(t, previous.Public())})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(signer *Signer) string {
+		token, err := signer.Sign(jwt.RegisteredClaims{Subject: "alice", Issuer: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	for name, token := range map[string]string{"current key": sign(signer), "previous key": sign(old)} {
+		var claims jwt.RegisteredClaims
+		if err := signer.Verify(token, &claims, jwt.WithIssuer("test")); err != nil || claims.Subject != "alice" {
+			t.Errorf("Verify of a token of the %s: got %v, %v", name, claims.Subject, err)
+		}
+	}
+
+	token := sign(signer)
+	// the first character of the signature carries six bits of it, a change
+	// of the last one may only touch padding
+	dot := strings.LastIndex(token, ".") + 1
+	flipped := "A"
+	if token[dot] == 'A' {
+		flipped = "B"
+	}
+	tampered := token[:dot] + flipped + token[dot+1:]
+	for name, tt := range map[string]struct{ token, issuer string }{
+		"unknown key":  {sign(other), "test"},
+		"tampered":     {tampered, "test"},
+		"wrong issuer": {token, "other"},
+		"unsigned":     {"eyJhbGciOiJub25lIn0.eyJzdWIiOiJhbGljZSIsImlzcyI6InRlc3QifQ.", "test"},
+	} {
+		var claims jwt.RegisteredClaims
+		if err := signer.Verify(tt.token, &claims, jwt.WithIssuer(tt.issuer)); err == nil {
+			t.Errorf("Verify of a token with %s: got no error", name)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package ids generates the identifiers of stored entities. Strategies are
+// registered by name and chosen in the config; ids of every registered
+// strategy stay valid, so changing the strategy keeps old ids reachable.
+package ids
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Strategy generates ids of one format and recognizes them
+type Strategy struct {
+	New   func() string
+	Valid func(id string) bool
+}
+
+// DefaultStrategy is used when the config names none
+const DefaultStrategy = "uuidv7"
+
+var strategies = map[string]Strategy{
+	"uuidv4": {
+		New:   uuid.NewString,
+		Valid: validUUID,
+	},
+	"uuidv7": {
+		New: func() string {
+			return uuid.Must(uuid.NewV7()).String()
+		},
+		Valid: validUUID,
+	},
+	"ulid": {
+		New: func() string {
+			return ulid.Make().String()
+		},
+		Valid: func(id string) bool {
+			_, err := ulid.ParseStrict(id)
+			return err == nil
+		},
+	},
+	"objectid": {
+		New: func() string {
+			return primitive.NewObjectID().Hex()
+		},
+		Valid: func(id string) bool {
+			_, err := primitive.ObjectIDFromHex(id)
+			return err == nil
+		},
+	},
+}
+
+// validUUID only accepts the canonical lowercase form, the one generated
+func validUUID(id string) bool {
+	if len(id) != 36 || strings.ToLower(id) != id {
+		return false
+	}
+	_, err := uuid.Parse(id)
+	return err == nil
+}
+
+// Register adds a strategy under name, replacing any registered before.
+// Call it before New, typically from an init function.
+func Register(name string, strategy Strategy) {
+	strategies[name] = strategy
+}
+
+// New returns the strategy registered under name
+func New(name string) (Strategy, error) {
+	if name == "" {
+		name = DefaultStrategy
+	}
+	strategy, ok := strategies[name]
+	if !ok {
+		names := make([]string, 0, len(strategies))
+		for name := range strategies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Strategy{}, fmt.Errorf("unknown id strategy %q, use one of %s", name, strings.Join(names, ", "))
+	}
+	return strategy, nil
+}
+
+// Valid reports whether id has the format of any registered strategy
+func Valid(id string) bool {
+	for _, strategy := range strategies {
+		if strategy.Valid(id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package ids
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrategies(t *testing.T) {
+	for _, name := range []string{"uuidv4", "uuidv7", "ulid", "objectid"} {
+		strategy, err := New(name)
+		if err != nil {
+			t.Fatalf("New(%q): unexpected error: %v", name, err)
+		}
+		first, second := strategy.New(), strategy.New()
+		if first == second {
+			t.Errorf("%s generated %q twice", name, first)
+		}
+		if !strategy.Valid(first) || !Valid(first) {
+			t.Errorf("%s does not recognize its id %q", name, first)
+		}
+	}
+}
+
+func TestNewDefaultsToUUIDv7(t *testing.T) {
+	strategy, err := New("")
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+	if id := strategy.New(); id[14] != '7' {
+		t.Fatalf("default strategy generated %q, want a version 7 UUID", id)
+	}
+}
+
+func TestNewRejectsUnknownStrategy(t *testing.T) {
+	_, err := New("snowflake")
+	if err == nil || !strings.Contains(err.Error(), "uuidv7") {
+		t.Fatalf("New of an unknown strategy: got error %v, want one listing the strategies", err)
+	}
+}
+
+func TestValidRejectsForeignIDs(t *testing.T) {
+	for _, id := range []string{
+		"",
+		"not-a-generated-id!",
+		"018A14DD-09E1-7D5F-A44F-B0ACCC09AAAF",
+		"018a14dd09e17d5fa44fb0accc09aaaf",
+		"../../etc/passwd",
+	} {
+		if Valid(id) {
+			t.Errorf("Valid(%q): got true, want false", id)
+		}
+	}
+}
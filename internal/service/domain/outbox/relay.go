@@ -0,0 +1,76 @@
+package outbox
+
+import (
+	"context"
+	"rest-api-go/internal/publisher"
+	"rest-api-go/internal/storage"
+	"rest-api-go/pkg/logging"
+	"time"
+)
+
+// Relay polls the outbox and hands pending events to a publisher. An event
+// is marked published only after the publisher accepted it, so a crash in
+// between publishes it again: delivery is at least once.
+type Relay struct {
+	logger           *logging.Logger
+	outboxRepository storage.OutboxRepository
+	publisher        publisher.Publisher
+	interval         time.Duration
+	batchSize        int
+}
+
+// Run relays pending events on every interval until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		// keep draining while batches come back full
+		for {
+			relayed := r.relay(ctx)
+			if relayed == 0 || relayed < r.batchSize || ctx.Err() != nil {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes one batch in order and returns how many events it published
+func (r *Relay) relay(ctx context.Context) int {
+	events, err := r.outboxRepository.FetchPending(ctx, r.batchSize)
+	if err != nil {
+		r.logger.Errorf("failed to fetch outbox events due to error %v", err)
+		return 0
+	}
+	for i, event := range events {
+		if err := r.publisher.Publish(ctx, event); err != nil {
+			r.logger.Errorf("failed to publish event %s due to error %v", event.ID, err)
+			return i
+		}
+		if err := r.outboxRepository.MarkPublished(ctx, event.ID, time.Now().UTC()); err != nil {
+			r.logger.Errorf("failed to mark event %s as published due to error %v", event.ID, err)
+			return i
+		}
+	}
+	return len(events)
+}
+
+func NewRelay(
+	logger *logging.Logger,
+	outboxRepository storage.OutboxRepository,
+	publisher publisher.Publisher,
+	interval time.Duration,
+	batchSize int,
+) *Relay {
+	return &Relay{
+		logger:           logger,
+		outboxRepository: outboxRepository,
+		publisher:        publisher,
+		interval:         interval,
+		batchSize:        batchSize,
+	}
+}
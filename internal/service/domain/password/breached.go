@@ -0,0 +1,83 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// prefixLength is the number of hex digits of the SHA-1 of a password that
+// pick its range, as in the Pwned Passwords range API
+const prefixLength = 5
+
+// BreachedList holds the SHA-1 hashes of breached passwords grouped in
+// ranges by the first five hex digits of the hash. A lookup only ever reads
+// the range of the password, so a remote range API could serve it instead.
+type BreachedList struct {
+	ranges map[string][]string
+}
+
+// LoadBreachedList reads a file holding the uppercase or lowercase hex SHA-1
+// of one password per line, optionally followed by :count as in the Pwned
+// Passwords downloads. Blank lines and lines starting with # are skipped.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list. error: %w", err)
+	}
+	defer file.Close()
+
+	list := &BreachedList{ranges: map[string][]string{}}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("breached password list %s: line %d does not hold a SHA-1 hash", path, line)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("breached password list %s: line %d does not hold a SHA-1 hash", path, line)
+		}
+		prefix := hash[:prefixLength]
+		list.ranges[prefix] = append(list.ranges[prefix], hash[prefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list. error: %w", err)
+	}
+	for _, suffixes := range list.ranges {
+		sort.Strings(suffixes)
+	}
+	return list, nil
+}
+
+// Len returns the number of hashes in the list
+func (l *BreachedList) Len() int {
+	n := 0
+	for _, suffixes := range l.ranges {
+		n += len(suffixes)
+	}
+	return n
+}
+
+// Range returns the sorted hash suffixes of the range of prefix, the first
+// five uppercase hex digits of a SHA-1
+func (l *BreachedList) Range(prefix string) []string {
+	return l.ranges[prefix]
+}
+
+// Contains reports whether password is in the list
+func (l *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes := l.Range(hash[:prefixLength])
+	i := sort.SearchStrings(suffixes, hash[prefixLength:])
+	return i < len(suffixes) && suffixes[i] == hash[prefixLength:]
+}
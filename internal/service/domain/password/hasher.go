@@ -0,0 +1,140 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownFormat is returned by a hasher asked to verify a hash it did not
+// produce
+var ErrUnknownFormat = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords into a self-describing string that holds
+// the algorithm and parameters, so hashes made with older settings can still
+// be verified.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash, and whether hash should
+	// be replaced by a new Hash of password because it uses another
+	// algorithm or other parameters
+	Verify(hash, password string) (match, rehash bool, err error)
+}
+
+// Bcrypt hashes passwords with bcrypt in its usual $2a$<cost>$ format.
+// bcrypt only uses the first 72 bytes of a password.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password due to error %w", err)
+	}
+	return string(hash), nil
+}
+func (b Bcrypt) Verify(hash, password string) (match, rehash bool, err error) {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, false, ErrUnknownFormat
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to verify password due to error %w", err)
+	}
+	return true, cost != b.Cost, nil
+}
+
+// Argon2id hashes passwords with argon2id in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+type Argon2id struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt due to error %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+func (a Argon2id) Verify(hash, password string) (match, rehash bool, err error) {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return false, false, ErrUnknownFormat
+	}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, false, fmt.Errorf("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	var stored Argon2id
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &stored.Memory, &stored.Iterations, &stored.Parallelism); err != nil {
+		return false, false, fmt.Errorf("malformed argon2id parameters %q", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, fmt.Errorf("malformed argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, fmt.Errorf("malformed argon2id key")
+	}
+	// argon2.IDKey panics without iterations or threads
+	if stored.Iterations < 1 || stored.Parallelism < 1 || len(key) == 0 {
+		return false, false, fmt.Errorf("malformed argon2id parameters %q", parts[3])
+	}
+	stored.SaltLength, stored.KeyLength = uint32(len(salt)), uint32(len(key))
+
+	candidate := argon2.IDKey([]byte(password), salt, stored.Iterations, stored.Memory, stored.Parallelism, stored.KeyLength)
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return false, false, nil
+	}
+	return true, stored != a, nil
+}
+
+// Hashers hashes new passwords with its first hasher and verifies the
+// hashes of every one of them, asking for a rehash of those it did not
+// produce with the first
+type Hashers []PasswordHasher
+
+func (h Hashers) Hash(password string) (string, error) {
+	return h[0].Hash(password)
+}
+func (h Hashers) Verify(hash, password string) (match, rehash bool, err error) {
+	for i, hasher := range h {
+		match, rehash, err = hasher.Verify(hash, password)
+		if errors.Is(err, ErrUnknownFormat) {
+			continue
+		}
+		return match, match && (rehash || i > 0), err
+	}
+	return false, false, ErrUnknownFormat
+}
+
+// NewHasher hashes with primary and still verifies the hashes of others
+func NewHasher(primary PasswordHasher, others ...PasswordHasher) PasswordHasher {
+	return append(Hashers{primary}, others...)
+}
@@ -0,0 +1,92 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2id = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashers(t *testing.T) {
+	for name, hasher := range map[string]PasswordHasher{
+		"bcrypt":   Bcrypt{Cost: bcrypt.MinCost},
+		"argon2id": testArgon2id,
+	} {
+		hash, err := hasher.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("%s Hash: unexpected error: %v", name, err)
+		}
+		if again, _ := hasher.Hash("correct horse"); again == hash {
+			t.Errorf("%s Hash: got the same hash twice, want a new salt every time", name)
+		}
+		if match, rehash, err := hasher.Verify(hash, "correct horse"); err != nil || !match || rehash {
+			t.Errorf("%s Verify of the password: got %v, %v, %v, want a match without rehash", name, match, rehash, err)
+		}
+		if match, _, err := hasher.Verify(hash, "wrong horse"); err != nil || match {
+			t.Errorf("%s Verify of another password: got %v, %v, want no match", name, match, err)
+		}
+	}
+}
+
+func TestArgon2idFormat(t *testing.T) {
+	hash, err := testArgon2id.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") || strings.Count(hash, "$") != 5 {
+		t.Fatalf("Hash: got %q, want the PHC string format", hash)
+	}
+	for _, malformed := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$salt",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x$c2FsdA$a2V5",
+		// parameters argon2.IDKey panics on
+		"$argon2id$v=19$m=65536,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=1,p=0$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=1,p=1$c2FsdA$",
+	} {
+		if _, _, err := testArgon2id.Verify(malformed, "correct horse"); err == nil || errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("Verify(%q): got error %v, want a malformed hash error", malformed, err)
+		}
+	}
+}
+
+func TestVerifyAsksForRehashOfOtherParameters(t *testing.T) {
+	oldBcrypt, _ := Bcrypt{Cost: bcrypt.MinCost}.Hash("correct horse")
+	if _, rehash, _ := (Bcrypt{Cost: bcrypt.MinCost + 1}).Verify(oldBcrypt, "correct horse"); !rehash {
+		t.Error("bcrypt Verify of another cost: got no rehash")
+	}
+	oldArgon2id, _ := testArgon2id.Hash("correct horse")
+	stronger := testArgon2id
+	stronger.Iterations++
+	if _, rehash, _ := stronger.Verify(oldArgon2id, "correct horse"); !rehash {
+		t.Error("argon2id Verify of other parameters: got no rehash")
+	}
+}
+
+func TestHashersMigrateBetweenAlgorithms(t *testing.T) {
+	bcryptHasher := Bcrypt{Cost: bcrypt.MinCost}
+	hasher := NewHasher(testArgon2id, bcryptHasher)
+
+	hash, err := hasher.Hash("correct horse")
+	if err != nil || !strings.HasPrefix(hash, argon2idPrefix) {
+		t.Fatalf("Hash: got %q, %v, want an argon2id hash", hash, err)
+	}
+	if match, rehash, err := hasher.Verify(hash, "correct horse"); err != nil || !match || rehash {
+		t.Fatalf("Verify of a primary hash: got %v, %v, %v, want a match without rehash", match, rehash, err)
+	}
+
+	legacy, _ := bcryptHasher.Hash("correct horse")
+	if match, rehash, err := hasher.Verify(legacy, "correct horse"); err != nil || !match || !rehash {
+		t.Fatalf("Verify of a legacy hash: got %v, %v, %v, want a match with rehash", match, rehash, err)
+	}
+	if match, rehash, err := hasher.Verify(legacy, "wrong horse"); err != nil || match || rehash {
+		t.Fatalf("Verify of a legacy hash with another password: got %v, %v, %v, want no match", match, rehash, err)
+	}
+	if _, _, err := hasher.Verify("plaintext", "plaintext"); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("Verify of an unknown format: got error %v, want %v", err, ErrUnknownFormat)
+	}
+}
@@ -0,0 +1,107 @@
+// Package password decides which passwords users may choose and how they
+// are hashed.
+package password
+
+import (
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy is the set of rules a new password must follow. The zero Policy
+// accepts any password that is not empty.
+type Policy struct {
+	// MinLength and MaxLength bound the length in characters, 0 disables a bound
+	MinLength int
+	MaxLength int
+	// the character classes a password must hold at least one of
+	RequireLower  bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// ForbidPersonal rejects passwords holding the username or the email
+	ForbidPersonal bool
+	// Breached rejects the passwords it lists, nil disables the check
+	Breached *BreachedList
+}
+
+// Check returns an apperrors.ValidationError reporting under field every
+// rule password breaks, or nil. username and email belong to the user who
+// chooses password.
+func (p *Policy) Check(field, password, username, email string) error {
+	var violations []apperrors.FieldError
+	violate := func(rule, message string) {
+		violations = append(violations, apperrors.FieldError{Field: field, Rule: rule, Message: message})
+	}
+
+	if password == "" {
+		violate("required", "is required")
+		return apperrors.ValidationError(violations)
+	}
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		violate("min_length", fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violate("max_length", fmt.Sprintf("must be at most %d characters long", p.MaxLength))
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+	if p.RequireLower && !lower {
+		violate("lowercase", "must hold a lowercase letter")
+	}
+	if p.RequireUpper && !upper {
+		violate("uppercase", "must hold an uppercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violate("digit", "must hold a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violate("symbol", "must hold a symbol")
+	}
+
+	if p.ForbidPersonal && holdsPersonal(password, username, email) {
+		violate("personal", "must not hold the username or the email")
+	}
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violate("breached", "appears in a list of breached passwords, choose another one")
+	}
+
+	if len(violations) > 0 {
+		return apperrors.ValidationError(violations)
+	}
+	return nil
+}
+
+// minPersonalLength keeps very short usernames from banning most passwords
+const minPersonalLength = 3
+
+// holdsPersonal reports whether password contains, ignoring case, the
+// username, the email or the part of the email before the @
+func holdsPersonal(password, username, email string) bool {
+	password = strings.ToLower(password)
+	personal := []string{username, email}
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		personal = append(personal, email[:at])
+	}
+	for _, value := range personal {
+		if utf8.RuneCountInString(value) >= minPersonalLength && strings.Contains(password, strings.ToLower(value)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"rest-api-go/internal/apperrors"
+	"testing"
+)
+
+func rules(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var appErr *apperrors.AppError
+	if !errors.As(err, &appErr) || !errors.Is(err, apperrors.ErrValidation) {
+		t.Fatalf("Check: got error %v, want a validation error", err)
+	}
+	var names []string
+	for _, field := range appErr.Fields {
+		if field.Field != "password" {
+			t.Fatalf("Check: got violation of %q, want password", field.Field)
+		}
+		names = append(names, field.Rule)
+	}
+	return names
+}
+
+func TestPolicyCheck(t *testing.T) {
+	strict := &Policy{
+		MinLength:      12,
+		MaxLength:      16,
+		RequireLower:   true,
+		RequireUpper:   true,
+		RequireDigit:   true,
+		RequireSymbol:  true,
+		ForbidPersonal: true,
+	}
+	tests := []struct {
+		name     string
+		policy   *Policy
+		password string
+		want     []string
+	}{
+		{"zero policy accepts anything", &Policy{}, "a", nil},
+		{"zero policy still requires a password", &Policy{}, "", []string{"required"}},
+		{"strict accepts", strict, "Tr0ub4dor&3xyz", nil},
+		{"too short and missing classes", strict, "abc", []string{"min_length", "uppercase", "digit", "symbol"}},
+		{"too long", strict, "Tr0ub4dor&3xyzTr0ub4dor&3xyz", []string{"max_length"}},
+		{"length in characters", &Policy{MaxLength: 4}, "éééé", nil},
+		{"username", strict, "Alice-Tr0ub4dor", []string{"personal"}},
+		{"local part of the email", strict, "x1!Wonderland-X", []string{"personal"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check("password", tt.password, "alice", "wonderland@example.com")
+			if got := rules(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Check(%q): got violations %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyIgnoresShortPersonalValues(t *testing.T) {
+	policy := &Policy{ForbidPersonal: true}
+	if err := policy.Check("password", "bobsled racing", "bo", "b@example.com"); err != nil {
+		t.Fatalf("Check: unexpected error: %v", err)
+	}
+}
+
+func TestPolicyRejectsBreachedPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// the SHA-1 of "password" and of "123456"
+	list := "# pwned\n5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n\n7c4a8d09ca3762af61e59520943dc26494f8941b\n"
+	if err := os.WriteFile(path, []byte(list), 0600); err != nil {
+		t.Fatal(err)
+	}
+	breached, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatalf("LoadBreachedList: unexpected error: %v", err)
+	}
+	if breached.Len() != 2 {
+		t.Fatalf("Len: got %d, want 2", breached.Len())
+	}
+	if got := breached.Range("5BAA6"); !reflect.DeepEqual(got, []string{"1E4C9B93F3F0682250B6CF8331B7EE68FD8"}) {
+		t.Fatalf("Range: got %v", got)
+	}
+
+	policy := &Policy{Breached: breached}
+	for password, want := range map[string][]string{
+		"password":  {"breached"},
+		"123456":    {"breached"},
+		"Password":  nil,
+		"passwords": nil,
+	} {
+		if got := rules(t, policy.Check("password", password, "", "")); !reflect.DeepEqual(got, want) {
+			t.Errorf("Check(%q): got violations %v, want %v", password, got, want)
+		}
+	}
+}
+
+func TestLoadBreachedListRejectsMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\nnot a hash\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBreachedList(path); err == nil {
+		t.Fatal("LoadBreachedList: got no error for a malformed line")
+	}
+}
@@ -2,7 +2,11 @@ package domain
 
 import (
 	"rest-api-go/internal/service"
+	"rest-api-go/internal/service/domain/auth"
+	"rest-api-go/internal/service/domain/ids"
+	"rest-api-go/internal/service/domain/password"
 	"rest-api-go/internal/service/domain/user"
+	"rest-api-go/internal/service/domain/validated"
 	"rest-api-go/internal/storage"
 	"rest-api-go/pkg/logging"
 )
@@ -10,10 +14,21 @@ import (
 // all implementations of service in one
 func NewService(
 	repositories *storage.Repository,
+	idStrategy ids.Strategy,
+	passwords *password.Policy,
+	hasher password.PasswordHasher,
+	tokens auth.Tokens,
 	logger *logging.Logger,
 ) *service.Service {
-	return &service.Service{
-		UserService: user.NewUserService(logger, repositories.User),
+	services := &service.Service{
+		UserService: validated.NewUserService(user.NewUserService(logger, repositories.User,
+			repositories.History, repositories.Outbox, repositories.RefreshToken, repositories.Transactor,
+			idStrategy, passwords, hasher)),
 		//add other services here
 	}
+	if tokens.Signer != nil {
+		services.AuthService = validated.NewAuthService(auth.NewAuthService(logger, repositories.User,
+			repositories.RefreshToken, repositories.Transactor, hasher, tokens))
+	}
+	return services
 }
@@ -0,0 +1,89 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/entities/outbox"
+	"rest-api-go/internal/entities/privacy"
+	"rest-api-go/internal/requestctx"
+)
+
+// erasedFields are the personal fields of a user, named by the tombstone
+// of an erasure without their values
+var erasedFields = []history.Change{{Field: "username"}, {Field: "email"}, {Field: "password"}}
+
+// DataExport collects the user, its history, its events and its refresh
+// tokens in one archive.
+// Deleted users are exported too, they are kept until they are purged.
+func (s *UserService) DataExport(ctx context.Context, id string) (archive privacy.Archive, err error) {
+	if err := checkID(id); err != nil {
+		return archive, err
+	}
+	// one unit of work so the parts agree with each other
+	err = s.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		u, err := s.UserRepository.FindOneWithDeleted(ctx, id)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) {
+				return err
+			}
+			return fmt.Errorf("failed to find user by uuid. error: %w", err)
+		}
+		records, err := s.HistoryRepository.FindByUserID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to find user history. error: %w", err)
+		}
+		events, err := s.OutboxRepository.FindByAggregateID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to find user events. error: %w", err)
+		}
+		tokens, err := s.RefreshTokenRepository.FindByUserID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to find user refresh tokens. error: %w", err)
+		}
+		archive = privacy.Archive{
+			ExportedAt:    s.now(),
+			Tenant:        requestctx.Tenant(ctx),
+			User:          u,
+			History:       append([]history.Record{}, records...),
+			Events:        append([]outbox.Event{}, events...),
+			RefreshTokens: append([]auth.RefreshToken{}, tokens...),
+		}
+		return nil
+	})
+	return archive, err
+}
+
+// Erase removes a user, deleted or not, and its refresh tokens, which ends
+// its sessions. It clears the values of its history and the payloads of its
+// events, and leaves an erased record and event as the tombstone.
+func (s *UserService) Erase(ctx context.Context, id string) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	return s.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.UserRepository.Erase(ctx, id); err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) {
+				return err
+			}
+			return fmt.Errorf("failed to erase user. error: %w", err)
+		}
+		if err := s.RefreshTokenRepository.DeleteByUserID(ctx, id); err != nil {
+			return fmt.Errorf("failed to delete user refresh tokens. error: %w", err)
+		}
+		if err := s.HistoryRepository.Anonymize(ctx, id); err != nil {
+			return fmt.Errorf("failed to anonymize user history. error: %w", err)
+		}
+		if err := s.OutboxRepository.Anonymize(ctx, id); err != nil {
+			return fmt.Errorf("failed to anonymize user events. error: %w", err)
+		}
+		if err := s.recordHistory(ctx, id, history.ActionErased, erasedFields); err != nil {
+			return err
+		}
+		s.logger.Infof("erased user %s", id)
+		return s.addEvent(ctx, outbox.UserErased, id, map[string]string{"id": id})
+	})
+}
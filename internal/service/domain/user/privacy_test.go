@@ -0,0 +1,50 @@
+package user
+
+import (
+	"errors"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/entities/history"
+	"testing"
+	"time"
+)
+
+func TestDataExportAndEraseOfDeletedUser(t *testing.T) {
+	service, _ := newTestService(t)
+	id := mustCreateUser(t, service)
+	if err := service.RefreshTokenRepository.Create(testContext(), auth.RefreshToken{
+		Hash: "h1", UserID: id, FamilyID: "f1", IssuedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Delete(testContext(), id, 0); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+
+	archive, err := service.DataExport(testContext(), id)
+	if err != nil {
+		t.Fatalf("DataExport of a deleted user: unexpected error: %v", err)
+	}
+	if archive.User.ID != id || archive.User.Email != "alice@example.com" || archive.User.DeletedAt == nil {
+		t.Fatalf("DataExport of a deleted user: got user %+v", archive.User)
+	}
+	if len(archive.History) != 2 || archive.History[1].Action != history.ActionDeleted {
+		t.Fatalf("DataExport of a deleted user: got history %+v, want created and deleted", archive.History)
+	}
+	if len(archive.RefreshTokens) != 1 || archive.RefreshTokens[0].FamilyID != "f1" {
+		t.Fatalf("DataExport of a deleted user: got refresh tokens %+v, want its token", archive.RefreshTokens)
+	}
+
+	if err := service.Erase(testContext(), id); err != nil {
+		t.Fatalf("Erase of a deleted user: unexpected error: %v", err)
+	}
+	if _, err := service.RefreshTokenRepository.FindByHash(testContext(), "h1"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindByHash of a token of an erased user: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	if _, err := service.DataExport(testContext(), id); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("DataExport of an erased user: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	if err := service.Erase(testContext(), id); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Erase of an erased user: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+}
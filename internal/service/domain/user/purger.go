@@ -0,0 +1,60 @@
+package user
+
+import (
+	"context"
+	"rest-api-go/internal/storage"
+	"rest-api-go/pkg/logging"
+	"time"
+)
+
+// Purger hard-deletes users that have been soft deleted for longer than
+// the retention period.
+type Purger struct {
+	logger         *logging.Logger
+	userRepository storage.UserRepository
+	retention      time.Duration
+	interval       time.Duration
+	// Clock returns the current time, tests can replace it. It must agree
+	// with the clock of the UserService that stamps deleted_at
+	Clock func() time.Time
+}
+
+// Run purges once immediately and then on every interval until ctx is done.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Purger) purge(ctx context.Context) {
+	deletedBefore := p.Clock().UTC().Add(-p.retention)
+	purged, err := p.userRepository.Purge(ctx, deletedBefore)
+	if err != nil {
+		p.logger.Errorf("failed to purge deleted users due to error %v", err)
+		return
+	}
+	if purged > 0 {
+		p.logger.Infof("purged %d users deleted before %s", purged, deletedBefore.Format(time.RFC3339))
+	}
+}
+
+func NewPurger(
+	logger *logging.Logger,
+	userRepository storage.UserRepository,
+	retention, interval time.Duration,
+) *Purger {
+	return &Purger{
+		logger:         logger,
+		userRepository: userRepository,
+		retention:      retention,
+		interval:       interval,
+		Clock:          time.Now,
+	}
+}
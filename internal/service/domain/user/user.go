@@ -2,55 +2,222 @@ package user
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/entities/outbox"
 	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/internal/service/domain/ids"
+	"rest-api-go/internal/service/domain/password"
 	"rest-api-go/internal/storage"
 	"rest-api-go/pkg/logging"
+	"runtime"
+	"sync"
+	"time"
+)
 
-	"golang.org/x/crypto/bcrypt"
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
 )
 
 type UserService struct {
-	logger         *logging.Logger
-	UserRepository storage.UserRepository
+	logger            *logging.Logger
+	UserRepository    storage.UserRepository
+	HistoryRepository storage.HistoryRepository
+	OutboxRepository  storage.OutboxRepository
+	// RefreshTokenRepository holds the sessions of users, which are part of
+	// their data exports and go with their erasure
+	RefreshTokenRepository storage.RefreshTokenRepository
+	// Transactor makes every change, its history record and its event one unit of work
+	Transactor storage.Transactor
+	// IDs generates the id of every created user
+	IDs ids.Strategy
+	// Passwords is the policy every new password must follow
+	Passwords *password.Policy
+	// Hasher hashes new passwords and verifies stored ones
+	Hasher password.PasswordHasher
+	// Clock returns the current time, tests can replace it
+	Clock func() time.Time
+}
+
+// now returns the current time with the millisecond precision every backend keeps
+func (s *UserService) now() time.Time {
+	return s.Clock().UTC().Truncate(time.Millisecond)
 }
 
 func (s *UserService) Create(ctx context.Context, dto user.CreateUserDTO) (userUUID string, err error) {
-	s.logger.Debug("check password and repeat password")
-	if dto.Password == "" {
-		return userUUID, apperrors.BadRequestError("password is empty")
+	newUser, err := s.newUser(dto)
+	if err != nil {
+		return userUUID, err
+	}
+
+	err = s.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		userUUID, err = s.UserRepository.Create(ctx, newUser)
+
+		if err != nil {
+			if errors.Is(err, apperrors.ErrConflict) {
+				return err
+			}
+			return fmt.Errorf("failed to create user. error: %w", err)
+		}
+		newUser.ID = userUUID
+		newUser.Version = 1
+		return s.recordCreated(ctx, newUser)
+	})
+	if err != nil {
+		return "", err
+	}
+	return userUUID, nil
+}
+
+// CreateMany hashes the passwords of dtos concurrently and creates the
+// users together. An atomic batch creates all of them or
+// none, otherwise every user that can be created is. The items report the
+// outcome of every dto, in order. Atomic batches are refused when the
+// storage cannot roll back.
+func (s *UserService) CreateMany(ctx context.Context, dtos []user.CreateUserDTO, atomic bool) ([]user.BatchItem, error) {
+	if len(dtos) == 0 {
+		return nil, apperrors.BadRequestError("batch is empty")
+	}
+	if atomic && !s.Transactor.Atomic() {
+		return nil, apperrors.BadRequestError("atomic batches need a storage with transactions")
+	}
+	if len(dtos) > user.MaxBatchSize {
+		return nil, apperrors.BadRequestError(fmt.Sprintf("batch must not hold more than %d users", user.MaxBatchSize))
+	}
+
+	items := make([]user.BatchItem, len(dtos))
+	users := make([]user.User, len(dtos))
+	s.logger.Debugf("prepare %d users", len(dtos))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				users[i], items[i].Error = s.newUser(dtos[i])
+			}
+		}()
+	}
+	for i := range dtos {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	pending := make([]int, 0, len(dtos))
+	for i, item := range items {
+		if item.Error == nil {
+			pending = append(pending, i)
+		}
+	}
+	// a unit of work with a failing user rolls back, a best-effort batch is
+	// retried without the users that failed
+	for len(pending) > 0 {
+		failed, err := s.createBatch(ctx, users, items, pending)
+		if err != nil {
+			return nil, err
+		}
+		if !failed {
+			break
+		}
+		remaining := pending[:0]
+		for _, i := range pending {
+			switch {
+			case items[i].Error != nil:
+			case atomic:
+				items[i] = user.BatchItem{Error: apperrors.ErrBatchAborted}
+			default:
+				remaining = append(remaining, i)
+			}
+		}
+		pending = remaining
 	}
-	if dto.Email == "" {
-		return userUUID, apperrors.BadRequestError("email is empty")
+	return items, nil
+}
+
+// errBatchFailed rolls back a unit of work in which a user failed
+var errBatchFailed = errors.New("a user of the batch failed")
+
+// createBatch creates the users at pending in one unit of work and stores
+// the outcome in items. It reports whether a user failed, in which case
+// nothing was created. Without rollback the users that were created are
+// kept and recorded instead.
+func (s *UserService) createBatch(ctx context.Context, users []user.User, items []user.BatchItem, pending []int) (failed bool, err error) {
+	err = s.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		batch := make([]user.User, len(pending))
+		for j, i := range pending {
+			batch[j] = users[i]
+		}
+		created, err := s.UserRepository.CreateMany(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("failed to create users. error: %w", err)
+		}
+		for j, i := range pending {
+			items[i] = created[j]
+			failed = failed || created[j].Error != nil
+		}
+		if failed && s.Transactor.Atomic() {
+			return errBatchFailed
+		}
+		failed = false
+		for j, item := range created {
+			if item.Error != nil {
+				continue
+			}
+			batch[j].ID = item.ID
+			batch[j].Version = 1
+			if err := s.recordCreated(ctx, batch[j]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errBatchFailed) {
+		return true, nil
 	}
-	if dto.Username == "" {
-		return userUUID, apperrors.BadRequestError("username is empty")
+	return false, err
+}
+
+// newUser returns the user to store for dto, which the validated decorator
+// has already checked
+func (s *UserService) newUser(dto user.CreateUserDTO) (user.User, error) {
+	s.logger.Debug("check password policy")
+	if err := s.Passwords.Check("password", dto.Password, dto.Username, dto.Email); err != nil {
+		return user.User{}, err
 	}
 
 	newUser := user.NewUser(dto)
+	newUser.ID = s.IDs.New()
+	newUser.CreatedAt = s.now()
+	newUser.UpdatedAt = newUser.CreatedAt
 
 	s.logger.Debug("generate password hash")
-	hash, err := user.GeneratePasswordHash(dto.Password)
+	hash, err := s.Hasher.Hash(dto.Password)
 	if err != nil {
 		s.logger.Errorf("failed to create user due to error %v", err)
-		return
+		return user.User{}, err
 	}
 	newUser.PasswordHash = hash
+	return *newUser, nil
+}
 
-	userUUID, err = s.UserRepository.Create(ctx, *newUser)
-
-	if err != nil {
-		if errors.Is(err, apperrors.ErrNotFound) {
-			return userUUID, err
-		}
-		return userUUID, fmt.Errorf("failed to create user. error: %w", err)
+// recordCreated writes the history record and the event of a created user
+func (s *UserService) recordCreated(ctx context.Context, created user.User) error {
+	if err := s.recordHistory(ctx, created.ID, history.ActionCreated, history.Diff(user.User{}, created)); err != nil {
+		return err
 	}
-
-	return userUUID, nil
+	return s.addEvent(ctx, outbox.UserCreated, created.ID, created)
 }
 func (s *UserService) FindOne(ctx context.Context, uuid string) (user.User, error) {
+	if err := checkID(uuid); err != nil {
+		return user.User{}, err
+	}
 	user, err := s.UserRepository.FindOne(ctx, uuid)
 
 	if err != nil {
@@ -61,73 +228,244 @@ func (s *UserService) FindOne(ctx context.Context, uuid string) (user.User, erro
 	}
 	return user, nil
 }
-func (s *UserService) FindAll(ctx context.Context) ([]user.User, error) {
-	users, err := s.UserRepository.FindAll(ctx)
+func (s *UserService) FindAll(ctx context.Context, query user.ListQuery) (user.Page, error) {
+	switch {
+	case query.Limit < 0:
+		return user.Page{}, apperrors.BadRequestError("limit must not be negative")
+	case query.Limit == 0:
+		query.Limit = DefaultPageLimit
+	case query.Limit > MaxPageLimit:
+		query.Limit = MaxPageLimit
+	}
+
+	page, err := s.UserRepository.FindAll(ctx, query)
 
 	if err != nil {
-		if errors.Is(err, apperrors.ErrNotFound) {
-			return users, err
+		var appErr *apperrors.AppError
+		if errors.As(err, &appErr) {
+			return page, err
 		}
-		return users, fmt.Errorf("failed to find users. error: %w", err)
+		return page, fmt.Errorf("failed to find users. error: %w", err)
 	}
-	return users, nil
+	return page, nil
+}
+func (s *UserService) Export(ctx context.Context, fn func(user.User) error) error {
+	if err := s.UserRepository.Stream(ctx, fn); err != nil {
+		return fmt.Errorf("failed to export users. error: %w", err)
+	}
+	return nil
 }
+
+// Update reads the user within the unit of work that writes it, which
+// bypasses caches, so the password is verified against the stored hash and
+// the recorded changes start from the stored state. Only the version of
+// If-Match is checked.
 func (s *UserService) Update(ctx context.Context, dto user.UpdateUserDTO) error {
-	s.logger.Debug("compare old and new passwords")
-	if dto.OldPassword != dto.NewPassword || dto.NewPassword == "" {
+	return s.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
 		s.logger.Debug("get user by uuid")
-		findedUser, err := s.FindOne(ctx, dto.ID)
+		current, err := s.FindOne(ctx, dto.ID)
 		if err != nil {
 			return err
 		}
 
-		s.logger.Debug("compare hash current password and old password")
-		err = bcrypt.CompareHashAndPassword([]byte(findedUser.PasswordHash), []byte(dto.OldPassword))
+		// the current password authorizes every update, a new password included
+		s.logger.Debug("verify old password against current hash")
+		match, outdated, err := s.Hasher.Verify(current.PasswordHash, dto.OldPassword)
 		if err != nil {
+			return fmt.Errorf("failed to verify password. error %w", err)
+		}
+		if !match {
 			return apperrors.BadRequestError("old password does not match current password")
 		}
 
-		dto.Password = dto.NewPassword
+		updatedUser := user.UpdatedUser(dto)
+		changePassword := dto.NewPassword != "" && dto.NewPassword != dto.OldPassword
+		if changePassword {
+			s.logger.Debug("check password policy")
+			next := merged(current, *updatedUser)
+			if err := s.Passwords.Check("new_password", dto.NewPassword, next.Username, next.Email); err != nil {
+				return err
+			}
+			s.logger.Debug("generate password hash")
+			hash, err := s.Hasher.Hash(dto.NewPassword)
+			if err != nil {
+				return fmt.Errorf("failed to generate hash. error %w", err)
+			}
+			updatedUser.PasswordHash = hash
+		}
+		changes := history.Diff(current, merged(current, *updatedUser))
+		if !changePassword && outdated {
+			// the old password was just verified, upgrade its hash in the same
+			// write. The password is unchanged so the history does not see it.
+			s.logger.Debug("rehash outdated password hash")
+			hash, err := s.Hasher.Hash(dto.OldPassword)
+			if err != nil {
+				return fmt.Errorf("failed to generate hash. error %w", err)
+			}
+			updatedUser.PasswordHash = hash
+		}
+		updatedUser.UpdatedAt = s.now()
+
+		s.logger.Printf("update user with uuid: %s", updatedUser.ID)
+		err = s.UserRepository.Update(ctx, *updatedUser)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) || errors.Is(err, apperrors.ErrConflict) ||
+				errors.Is(err, apperrors.ErrPreconditionFailed) {
+				return err
+			}
+			return fmt.Errorf("failed to update user. error: %w", err)
+		}
+		err = s.recordHistory(ctx, current.ID, history.ActionUpdated, changes)
+		if err != nil {
+			return err
+		}
+		saved, err := s.FindOne(ctx, current.ID)
+		if err != nil {
+			return err
+		}
+		return s.addEvent(ctx, outbox.UserUpdated, current.ID, saved)
+	})
+}
+func (s *UserService) Delete(ctx context.Context, id string, version int64) (err error) {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	return s.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := s.UserRepository.Delete(ctx, id, version, s.now())
+
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) || errors.Is(err, apperrors.ErrPreconditionFailed) {
+				return err
+			}
+			return fmt.Errorf("failed to delete user. error: %w", err)
+		}
+		if err := s.recordHistory(ctx, id, history.ActionDeleted, nil); err != nil {
+			return err
+		}
+		return s.addEvent(ctx, outbox.UserDeleted, id, map[string]string{"id": id})
+	})
+}
+func (s *UserService) Restore(ctx context.Context, id string) (err error) {
+	if err := checkID(id); err != nil {
+		return err
 	}
+	return s.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := s.UserRepository.Restore(ctx, id, s.now())
 
-	updatedUser := user.UpdatedUser(dto)
-	s.logger.Debug("generate password hash")
-	hash, err := user.GeneratePasswordHash(dto.Password)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) || errors.Is(err, apperrors.ErrConflict) {
+				return err
+			}
+			return fmt.Errorf("failed to restore user. error: %w", err)
+		}
+		if err := s.recordHistory(ctx, id, history.ActionRestored, nil); err != nil {
+			return err
+		}
+		restored, err := s.FindOne(ctx, id)
+		if err != nil {
+			return err
+		}
+		return s.addEvent(ctx, outbox.UserRestored, id, restored)
+	})
+}
+func (s *UserService) History(ctx context.Context, id string) ([]history.Record, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+	records, err := s.HistoryRepository.FindByUserID(ctx, id)
 	if err != nil {
-		return fmt.Errorf("failed to generate hash. error %w", err)
+		return nil, fmt.Errorf("failed to find user history. error: %w", err)
+	}
+	if len(records) == 0 {
+		// tell apart users without history from unknown ones
+		if _, err := s.FindOne(ctx, id); err != nil {
+			return nil, err
+		}
+		records = []history.Record{}
 	}
-	updatedUser.PasswordHash = hash
+	return records, nil
+}
 
-	s.logger.Printf("update user with uuid: %+v", updatedUser)
+// checkID rejects ids no strategy could have generated before they reach a
+// repository
+func checkID(id string) error {
+	if !ids.Valid(id) {
+		return apperrors.BadRequestError(fmt.Sprintf("malformed id %q", id))
+	}
+	return nil
+}
 
-	err = s.UserRepository.Update(ctx, *updatedUser)
+// recordHistory stores who made a change to a user and what it was
+func (s *UserService) recordHistory(ctx context.Context, userID string, action history.Action, changes []history.Change) error {
+	record := history.Record{
+		UserID:    userID,
+		Actor:     requestctx.Actor(ctx),
+		Action:    action,
+		Timestamp: s.now(),
+		Changes:   changes,
+	}
+	if err := s.HistoryRepository.Create(ctx, record); err != nil {
+		return fmt.Errorf("failed to record user history. error: %w", err)
+	}
+	return nil
+}
 
+// addEvent puts an event about a user in the outbox, payload never holds the
+// password hash because user.User does not marshal it
+func (s *UserService) addEvent(ctx context.Context, eventType, userID string, payload interface{}) error {
+	data, err := json.Marshal(payload)
 	if err != nil {
-		if errors.Is(err, apperrors.ErrNotFound) {
-			return err
-		}
-		return fmt.Errorf("failed to update user. error: %w", err)
+		return fmt.Errorf("failed to marshal %s event. error: %w", eventType, err)
+	}
+	event := outbox.Event{
+		Type:        eventType,
+		TenantID:    requestctx.Tenant(ctx),
+		AggregateID: userID,
+		Payload:     data,
+		OccurredAt:  s.now(),
+	}
+	if err := s.OutboxRepository.Add(ctx, event); err != nil {
+		return fmt.Errorf("failed to add %s event. error: %w", eventType, err)
 	}
 	return nil
 }
-func (s *UserService) Delete(ctx context.Context, id string) (err error) {
-	err = s.UserRepository.Delete(ctx, id)
 
-	if err != nil {
-		if errors.Is(err, apperrors.ErrNotFound) {
-			return err
-		}
-		return fmt.Errorf("failed to delete user. error: %w", err)
+// merged returns current with the non-empty fields of a partial update applied,
+// the same way repositories apply it
+func merged(current, update user.User) user.User {
+	if update.Email != "" {
+		current.Email = update.Email
+	}
+	if update.Username != "" {
+		current.Username = update.Username
+	}
+	if update.PasswordHash != "" {
+		current.PasswordHash = update.PasswordHash
 	}
-	return err
+	return current
 }
 
 func NewUserService(
 	logger *logging.Logger,
 	UserRepository storage.UserRepository,
+	HistoryRepository storage.HistoryRepository,
+	OutboxRepository storage.OutboxRepository,
+	RefreshTokenRepository storage.RefreshTokenRepository,
+	Transactor storage.Transactor,
+	IDs ids.Strategy,
+	Passwords *password.Policy,
+	Hasher password.PasswordHasher,
 ) *UserService {
 	return &UserService{
-		logger:         logger,
-		UserRepository: UserRepository,
+		logger:                 logger,
+		UserRepository:         UserRepository,
+		HistoryRepository:      HistoryRepository,
+		OutboxRepository:       OutboxRepository,
+		RefreshTokenRepository: RefreshTokenRepository,
+		Transactor:             Transactor,
+		IDs:                    IDs,
+		Passwords:              Passwords,
+		Hasher:                 Hasher,
+		Clock:                  time.Now,
 	}
 }
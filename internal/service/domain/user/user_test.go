@@ -0,0 +1,257 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/internal/service/domain/ids"
+	"rest-api-go/internal/service/domain/password"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/cache"
+	"rest-api-go/internal/storage/memory"
+	"rest-api-go/pkg/logging"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const currentPassword = "correct horse battery"
+
+func newTestService(t *testing.T) (*UserService, *storage.Repository) {
+	t.Helper()
+	repositories := memory.NewRepository(logging.GetLogger())
+	idStrategy, err := ids.New("uuidv7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewUserService(logging.GetLogger(), repositories.User, repositories.History,
+		repositories.Outbox, repositories.RefreshToken, repositories.Transactor, idStrategy,
+		&password.Policy{MinLength: 12}, password.NewHasher(password.Bcrypt{Cost: bcrypt.MinCost}))
+	return service, repositories
+}
+
+func testContext() context.Context {
+	return requestctx.WithTenant(context.Background(), "acme")
+}
+
+func mustCreateUser(t *testing.T, service *UserService) string {
+	t.Helper()
+	id, err := service.Create(testContext(), user.CreateUserDTO{
+		Username: "alice",
+		Email:    "alice@example.com",
+		Password: currentPassword,
+	})
+	if err != nil {
+		t.Fatalf("Create: unexpected error: %v", err)
+	}
+	return id
+}
+
+func assertPassword(t *testing.T, service *UserService, id, want string) {
+	t.Helper()
+	stored, err := service.UserRepository.FindOne(testContext(), id)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	if match, _, err := service.Hasher.Verify(stored.PasswordHash, want); err != nil || !match {
+		t.Fatalf("stored hash does not match %q: match %v, error %v", want, match, err)
+	}
+}
+
+func TestUpdatePassword(t *testing.T) {
+	tests := []struct {
+		name    string
+		dto     user.UpdateUserDTO
+		wantErr error
+		want    string
+	}{
+		{
+			// old_password equal to new_password used to skip the verification
+			name:    "same wrong old and new password",
+			dto:     user.UpdateUserDTO{OldPassword: "hijacked password!!", NewPassword: "hijacked password!!"},
+			wantErr: apperrors.BadRequestError(""),
+			want:    currentPassword,
+		},
+		{
+			name:    "wrong old password",
+			dto:     user.UpdateUserDTO{OldPassword: "wrong password!!", NewPassword: "another password!!"},
+			wantErr: apperrors.BadRequestError(""),
+			want:    currentPassword,
+		},
+		{
+			name:    "missing old password",
+			dto:     user.UpdateUserDTO{NewPassword: "another password!!"},
+			wantErr: apperrors.BadRequestError(""),
+			want:    currentPassword,
+		},
+		{
+			name: "new password",
+			dto:  user.UpdateUserDTO{OldPassword: currentPassword, NewPassword: "another password!!"},
+			want: "another password!!",
+		},
+		{
+			name: "same password",
+			dto:  user.UpdateUserDTO{OldPassword: currentPassword, NewPassword: currentPassword},
+			want: currentPassword,
+		},
+		{
+			name:    "new password breaking the policy",
+			dto:     user.UpdateUserDTO{OldPassword: currentPassword, NewPassword: "short"},
+			wantErr: apperrors.ErrValidation,
+			want:    currentPassword,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestService(t)
+			id := mustCreateUser(t, service)
+
+			tt.dto.ID = id
+			err := service.Update(testContext(), tt.dto)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("Update: unexpected error: %v", err)
+			case tt.wantErr != nil && !sameStatus(err, tt.wantErr):
+				t.Fatalf("Update: got error %v, want %v", err, tt.wantErr)
+			}
+			assertPassword(t, service, id, tt.want)
+		})
+	}
+}
+
+func TestUpdateIgnoresPasswordField(t *testing.T) {
+	service, _ := newTestService(t)
+	id := mustCreateUser(t, service)
+
+	var dto user.UpdateUserDTO
+	body := `{"old_password":"x","new_password":"x","password":"hijacked password!!"}`
+	if err := json.Unmarshal([]byte(body), &dto); err != nil {
+		t.Fatal(err)
+	}
+	dto.ID = id
+	if err := service.Update(testContext(), dto); !sameStatus(err, apperrors.BadRequestError("")) {
+		t.Fatalf("Update: got error %v, want a bad request", err)
+	}
+	assertPassword(t, service, id, currentPassword)
+}
+
+// TestUpdateWithoutIfMatchOnStaleCache runs two instances with caches of
+// their own in front of the same storage
+func TestUpdateWithoutIfMatchOnStaleCache(t *testing.T) {
+	first, repositories := newTestService(t)
+	id := mustCreateUser(t, first)
+
+	second := *first
+	cached := cache.NewUserRepository(repositories.User, 10, time.Minute)
+	second.UserRepository = cached
+	second.Transactor = cache.NewTransactor(repositories.Transactor, cached)
+	if _, err := second.FindOne(testContext(), id); err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+
+	if err := first.Update(testContext(), user.UpdateUserDTO{ID: id, OldPassword: currentPassword, Username: "bob"}); err != nil {
+		t.Fatalf("Update of the first instance: unexpected error: %v", err)
+	}
+	if err := second.Update(testContext(), user.UpdateUserDTO{ID: id, OldPassword: currentPassword, Username: "carol"}); err != nil {
+		t.Fatalf("Update of the second instance: unexpected error: %v", err)
+	}
+	records, err := first.History(testContext(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := records[len(records)-1].Changes
+	if len(last) != 1 || last[0].Before != "bob" || last[0].After != "carol" {
+		t.Fatalf("got changes %+v, want username from bob to carol", last)
+	}
+
+	stale := user.UpdateUserDTO{ID: id, OldPassword: currentPassword, Username: "dave", Version: 2}
+	if err := second.Update(testContext(), stale); !errors.Is(err, apperrors.ErrPreconditionFailed) {
+		t.Fatalf("Update with a stale If-Match: got error %v, want %v", err, apperrors.ErrPreconditionFailed)
+	}
+}
+
+// sameStatus reports whether err is an AppError with the status of want
+func sameStatus(err error, want error) bool {
+	var got, wanted *apperrors.AppError
+	return errors.As(err, &got) && errors.As(want, &wanted) && got.StatusCode() == wanted.StatusCode()
+}
+
+// withoutTransactions runs units of work without rolling anything back, like
+// mongodb on a standalone server
+type withoutTransactions struct{}
+
+func (withoutTransactions) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+func (withoutTransactions) Atomic() bool {
+	return false
+}
+
+func TestCreateManyWithoutTransactions(t *testing.T) {
+	service, _ := newTestService(t)
+	service.Transactor = withoutTransactions{}
+	mustCreateUser(t, service)
+	dtos := []user.CreateUserDTO{
+		{Username: "bob", Email: "bob@example.com", Password: currentPassword},
+		{Username: "alice", Email: "alice2@example.com", Password: currentPassword},
+		{Username: "carol", Email: "carol@example.com", Password: currentPassword},
+	}
+
+	if _, err := service.CreateMany(testContext(), dtos, true); !sameStatus(err, apperrors.BadRequestError("")) {
+		t.Fatalf("atomic CreateMany: got error %v, want a bad request", err)
+	}
+	if page, _ := service.FindAll(testContext(), user.ListQuery{}); len(page.Users) != 1 {
+		t.Fatalf("atomic CreateMany: got %d users, want none created", len(page.Users)-1)
+	}
+
+	items, err := service.CreateMany(testContext(), dtos, false)
+	if err != nil {
+		t.Fatalf("CreateMany: unexpected error: %v", err)
+	}
+	if items[0].Error != nil || !errors.Is(items[1].Error, apperrors.ErrConflict) || items[2].Error != nil {
+		t.Fatalf("CreateMany: got %+v, want the second user to conflict", items)
+	}
+	for _, i := range []int{0, 2} {
+		records, err := service.History(testContext(), items[i].ID)
+		if err != nil || len(records) != 1 || records[0].Action != history.ActionCreated {
+			t.Fatalf("History of %s: got %+v, %v, want it created once", dtos[i].Username, records, err)
+		}
+	}
+}
+
+func TestDeleteAndRestoreUseTheServiceClock(t *testing.T) {
+	service, _ := newTestService(t)
+	id := mustCreateUser(t, service)
+	deletedAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	service.Clock = func() time.Time { return deletedAt }
+
+	if err := service.Delete(testContext(), id, 0); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+	stored, err := service.UserRepository.FindOneWithDeleted(testContext(), id)
+	if err != nil {
+		t.Fatalf("FindOneWithDeleted: unexpected error: %v", err)
+	}
+	if stored.DeletedAt == nil || !stored.DeletedAt.Equal(deletedAt) || !stored.UpdatedAt.Equal(deletedAt) {
+		t.Fatalf("got deleted at %v and updated at %v, want both at %v", stored.DeletedAt, stored.UpdatedAt, deletedAt)
+	}
+
+	restoredAt := deletedAt.Add(time.Hour)
+	service.Clock = func() time.Time { return restoredAt }
+	if err := service.Restore(testContext(), id); err != nil {
+		t.Fatalf("Restore: unexpected error: %v", err)
+	}
+	restored, err := service.FindOne(testContext(), id)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	if !restored.UpdatedAt.Equal(restoredAt) {
+		t.Fatalf("got updated at %v after Restore, want %v", restored.UpdatedAt, restoredAt)
+	}
+}
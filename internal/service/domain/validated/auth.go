@@ -0,0 +1,39 @@
+package validated
+
+import (
+	"context"
+	"rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/service"
+	"rest-api-go/internal/validation"
+)
+
+// AuthService rejects invalid dtos the same way UserService does
+type AuthService struct {
+	service.AuthService
+}
+
+func (s *AuthService) Login(ctx context.Context, dto auth.LoginDTO) (auth.Token, error) {
+	if err := validation.Struct(dto); err != nil {
+		return auth.Token{}, err
+	}
+	return s.AuthService.Login(ctx, dto)
+}
+
+func (s *AuthService) Refresh(ctx context.Context, dto auth.RefreshDTO) (auth.Token, error) {
+	if err := validation.Struct(dto); err != nil {
+		return auth.Token{}, err
+	}
+	return s.AuthService.Refresh(ctx, dto)
+}
+
+func (s *AuthService) Logout(ctx context.Context, dto auth.RefreshDTO) error {
+	if err := validation.Struct(dto); err != nil {
+		return err
+	}
+	return s.AuthService.Logout(ctx, dto)
+}
+
+// NewAuthService validates the dtos passed to authService
+func NewAuthService(authService service.AuthService) *AuthService {
+	return &AuthService{AuthService: authService}
+}
@@ -0,0 +1,81 @@
+// Package validated provides a decorator that validates the dtos of every
+// service call against their validate tags before the service sees them.
+package validated
+
+import (
+	"context"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/service"
+	"rest-api-go/internal/validation"
+)
+
+// UserService rejects dtos breaking their rules with an
+// apperrors.ValidationError listing every violation. Calls without a dto
+// are passed through.
+type UserService struct {
+	service.UserService
+}
+
+func (s *UserService) Create(ctx context.Context, dto user.CreateUserDTO) (string, error) {
+	if err := validation.Struct(dto); err != nil {
+		return "", err
+	}
+	return s.UserService.Create(ctx, dto)
+}
+
+// CreateMany reports the violations of every invalid dto in its item. An
+// atomic batch holding one is aborted as a whole, a best-effort batch
+// creates the valid users.
+func (s *UserService) CreateMany(ctx context.Context, dtos []user.CreateUserDTO, atomic bool) ([]user.BatchItem, error) {
+	if len(dtos) == 0 || len(dtos) > user.MaxBatchSize {
+		// the service rejects the batch as a whole
+		return s.UserService.CreateMany(ctx, dtos, atomic)
+	}
+
+	items := make([]user.BatchItem, len(dtos))
+	valid := make([]int, 0, len(dtos))
+	for i, dto := range dtos {
+		if err := validation.Struct(dto); err != nil {
+			items[i].Error = err
+			continue
+		}
+		valid = append(valid, i)
+	}
+	if len(valid) == len(dtos) {
+		return s.UserService.CreateMany(ctx, dtos, atomic)
+	}
+	if len(valid) == 0 {
+		return items, nil
+	}
+	if atomic {
+		for _, i := range valid {
+			items[i].Error = apperrors.ErrBatchAborted
+		}
+		return items, nil
+	}
+
+	batch := make([]user.CreateUserDTO, len(valid))
+	for j, i := range valid {
+		batch[j] = dtos[i]
+	}
+	created, err := s.UserService.CreateMany(ctx, batch, atomic)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range valid {
+		items[i] = created[j]
+	}
+	return items, nil
+}
+func (s *UserService) Update(ctx context.Context, dto user.UpdateUserDTO) error {
+	if err := validation.Struct(dto); err != nil {
+		return err
+	}
+	return s.UserService.Update(ctx, dto)
+}
+
+// NewUserService validates the dtos passed to userService
+func NewUserService(userService service.UserService) *UserService {
+	return &UserService{UserService: userService}
+}
@@ -2,6 +2,9 @@ package service
 
 import (
 	"context"
+	"rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/entities/privacy"
 	"rest-api-go/internal/entities/user"
 )
 
@@ -9,12 +12,43 @@ import (
 
 type UserService interface {
 	Create(ctx context.Context, dto user.CreateUserDTO) (userUUID string, err error)
+	// CreateMany creates a batch of users, all or nothing when atomic is set,
+	// and reports the outcome of every dto in order
+	CreateMany(ctx context.Context, dtos []user.CreateUserDTO, atomic bool) ([]user.BatchItem, error)
 	FindOne(ctx context.Context, id string) (user.User, error)
-	FindAll(ctx context.Context) ([]user.User, error)
+	FindAll(ctx context.Context, query user.ListQuery) (user.Page, error)
+	// Export calls fn for every user in ID order without loading them all
+	Export(ctx context.Context, fn func(user.User) error) error
 	Update(ctx context.Context, dto user.UpdateUserDTO) error
-	Delete(ctx context.Context, id string) error
+	// Delete checks version like Update does, 0 skips the check
+	Delete(ctx context.Context, id string, version int64) error
+	Restore(ctx context.Context, id string) error
+	History(ctx context.Context, id string) ([]history.Record, error)
+	// DataExport returns everything stored about a user
+	DataExport(ctx context.Context, id string) (privacy.Archive, error)
+	// Erase removes the personal data of a user everywhere it is stored and
+	// records that it did
+	Erase(ctx context.Context, id string) error
+}
+
+type AuthService interface {
+	// Login checks the credentials of a user and issues an access token
+	Login(ctx context.Context, dto auth.LoginDTO) (auth.Token, error)
+	// Refresh exchanges a refresh token for new tokens, using one twice
+	// revokes every token rotated from the same sign in
+	Refresh(ctx context.Context, dto auth.RefreshDTO) (auth.Token, error)
+	// Logout revokes every token rotated from the same sign in as a refresh token
+	Logout(ctx context.Context, dto auth.RefreshDTO) error
+	// RevokeSessions revokes every refresh token of a user
+	RevokeSessions(ctx context.Context, userID string) error
+	// JWKS returns the public keys access tokens can be verified with
+	JWKS() auth.JWKSet
+	// Authenticate returns the user and tenant an access token was issued to
+	Authenticate(token string) (userID, tenant string, err error)
 }
 
 type Service struct {
 	UserService UserService
+	// AuthService is nil when signing in is disabled
+	AuthService AuthService
 }
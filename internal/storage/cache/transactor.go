@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"context"
+	"rest-api-go/internal/storage"
+	"sync"
+)
+
+type txKey struct{}
+
+// transaction collects the users written in a unit of work
+type transaction struct {
+	mu   sync.Mutex
+	keys []key
+}
+
+func (t *transaction) add(k key) {
+	t.mu.Lock()
+	t.keys = append(t.keys, k)
+	t.mu.Unlock()
+}
+
+func inTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(*transaction)
+	return ok
+}
+
+// Transactor wraps the transactor of the cached repository and evicts the
+// users written in a unit of work once it has committed or rolled back.
+type Transactor struct {
+	storage.Transactor
+	cache *UserRepository
+}
+
+func (t *Transactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if inTransaction(ctx) {
+		return t.Transactor.WithinTransaction(ctx, fn)
+	}
+	tx := &transaction{}
+	err := t.Transactor.WithinTransaction(context.WithValue(ctx, txKey{}, tx), fn)
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	t.cache.evict(tx.keys...)
+	return err
+}
+
+func NewTransactor(transactor storage.Transactor, cache *UserRepository) *Transactor {
+	return &Transactor{Transactor: transactor, cache: cache}
+}
@@ -0,0 +1,205 @@
+// Package cache provides read-through caching decorators for repositories.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/internal/storage"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are the counters of a cache since it was created
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+// key identifies a cached user, ids are only looked up within a tenant
+type key struct {
+	tenant string
+	id     string
+}
+
+func keyOf(ctx context.Context, id string) key {
+	return key{tenant: requestctx.Tenant(ctx), id: id}
+}
+
+type entry struct {
+	key       key
+	user      user.User
+	expiresAt time.Time
+}
+
+// UserRepository keeps the results of FindOne in a bounded LRU. Writes going
+// through it evict the user they touch, every other method is passed through.
+// Writes made by other instances are seen once their entries expire, so
+// read a user that is about to be written within a unit of work, which
+// bypasses the cache.
+type UserRepository struct {
+	storage.UserRepository
+	mu      sync.Mutex
+	entries map[key]*list.Element
+	// loads counts the FindOne calls of a key reading the repository and
+	// generations the evictions of the key since the first of them, a load
+	// only puts its result if its key was not evicted in between
+	loads       map[key]int
+	generations map[key]uint64
+	// order holds the most recently used entry at the front
+	order  *list.List
+	size   int
+	ttl    time.Duration
+	hits   uint64
+	misses uint64
+	// Clock returns the current time, tests can replace it
+	Clock func() time.Time
+}
+
+func (c *UserRepository) FindOne(ctx context.Context, id string) (user.User, error) {
+	if inTransaction(ctx) {
+		// never serve or keep state of a unit of work that may roll back
+		return c.UserRepository.FindOne(ctx, id)
+	}
+	k := keyOf(ctx, id)
+	if u, ok := c.get(k); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return u, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	generation := c.beginLoad(k)
+	u, err := c.UserRepository.FindOne(ctx, id)
+	c.endLoad(k, generation, u, err == nil)
+	return u, err
+}
+func (c *UserRepository) Update(ctx context.Context, user user.User) error {
+	defer c.invalidate(ctx, user.ID)
+	return c.UserRepository.Update(ctx, user)
+}
+func (c *UserRepository) Delete(ctx context.Context, id string, version int64, deletedAt time.Time) error {
+	defer c.invalidate(ctx, id)
+	return c.UserRepository.Delete(ctx, id, version, deletedAt)
+}
+func (c *UserRepository) Restore(ctx context.Context, id string, restoredAt time.Time) error {
+	defer c.invalidate(ctx, id)
+	return c.UserRepository.Restore(ctx, id, restoredAt)
+}
+func (c *UserRepository) Erase(ctx context.Context, id string) error {
+	defer c.invalidate(ctx, id)
+	return c.UserRepository.Erase(ctx, id)
+}
+
+// Stats returns the hit and miss counters and the number of cached users
+func (c *UserRepository) Stats() Stats {
+	c.mu.Lock()
+	size := c.order.Len()
+	c.mu.Unlock()
+	return Stats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Size:   size,
+	}
+}
+
+func (c *UserRepository) get(k key) (user.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[k]
+	if !ok {
+		return user.User{}, false
+	}
+	e := element.Value.(*entry)
+	if c.ttl > 0 && !c.Clock().Before(e.expiresAt) {
+		c.remove(element)
+		return user.User{}, false
+	}
+	c.order.MoveToFront(element)
+	return e.user, true
+}
+
+// beginLoad registers a read of k from the repository and returns the
+// generation of k it started at
+func (c *UserRepository) beginLoad(k key) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loads[k]++
+	return c.generations[k]
+}
+
+// endLoad puts u if found is set and k was not evicted since generation,
+// otherwise u may be the state a write just replaced
+func (c *UserRepository) endLoad(k key, generation uint64, u user.User, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if found && c.generations[k] == generation {
+		c.put(k, u)
+	}
+	if c.loads[k]--; c.loads[k] == 0 {
+		delete(c.loads, k)
+		delete(c.generations, k)
+	}
+}
+
+// put must be called with mu held
+func (c *UserRepository) put(k key, u user.User) {
+	e := &entry{key: k, user: u, expiresAt: c.Clock().Add(c.ttl)}
+	if element, ok := c.entries[k]; ok {
+		element.Value = e
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[k] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// invalidate evicts id now and, inside a unit of work, once more when it
+// ends, so readers cannot cache the state it replaced in between
+func (c *UserRepository) invalidate(ctx context.Context, id string) {
+	k := keyOf(ctx, id)
+	if tx, ok := ctx.Value(txKey{}).(*transaction); ok {
+		tx.add(k)
+	}
+	c.evict(k)
+}
+func (c *UserRepository) evict(keys ...key) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		if element, ok := c.entries[k]; ok {
+			c.remove(element)
+		}
+		if c.loads[k] > 0 {
+			c.generations[k]++
+		}
+	}
+}
+
+// remove must be called with mu held
+func (c *UserRepository) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
+
+// NewUserRepository caches up to size users of repository for ttl each,
+// a ttl of 0 keeps them until they are evicted.
+func NewUserRepository(repository storage.UserRepository, size int, ttl time.Duration) *UserRepository {
+	if size < 1 {
+		size = 1
+	}
+	return &UserRepository{
+		UserRepository: repository,
+		entries:        make(map[key]*list.Element, size),
+		loads:          make(map[key]int),
+		generations:    make(map[key]uint64),
+		order:          list.New(),
+		size:           size,
+		ttl:            ttl,
+		Clock:          time.Now,
+	}
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/memory"
+	"rest-api-go/internal/storage/storagetest"
+	"rest-api-go/pkg/logging"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserRepository(t *testing.T) {
+	storagetest.RunUserRepositoryTests(t, func(t *testing.T) storage.UserRepository {
+		return NewUserRepository(memory.NewRepository(logging.GetLogger()).User, 2, time.Minute)
+	})
+}
+
+// blockingRepository holds its first FindOne after reading, closing
+// loading, until release is closed
+type blockingRepository struct {
+	storage.UserRepository
+	once    sync.Once
+	loading chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingRepository) FindOne(ctx context.Context, id string) (user.User, error) {
+	u, err := r.UserRepository.FindOne(ctx, id)
+	r.once.Do(func() {
+		close(r.loading)
+		<-r.release
+	})
+	return u, err
+}
+
+func TestFindOneDoesNotCacheStateReplacedWhileLoading(t *testing.T) {
+	ctx := requestctx.WithTenant(context.Background(), "acme")
+	stored := memory.NewRepository(logging.GetLogger()).User
+	if _, err := stored.Create(ctx, user.User{ID: "1", Username: "alice", Email: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	blocking := &blockingRepository{UserRepository: stored, loading: make(chan struct{}), release: make(chan struct{})}
+	cached := NewUserRepository(blocking, 10, time.Minute)
+
+	loaded := make(chan user.User)
+	go func() {
+		u, _ := cached.FindOne(ctx, "1")
+		loaded <- u
+	}()
+	<-blocking.loading
+	if err := cached.Update(ctx, user.User{ID: "1", Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	close(blocking.release)
+	if stale := <-loaded; stale.Username != "alice" {
+		t.Fatalf("load started before the update: got username %q, want alice", stale.Username)
+	}
+
+	found, err := cached.FindOne(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Username != "bob" {
+		t.Fatalf("FindOne after the update: got username %q, want bob", found.Username)
+	}
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	if len(cached.loads) != 0 || len(cached.generations) != 0 {
+		t.Fatalf("finished loads are still tracked: %v, %v", cached.loads, cached.generations)
+	}
+}
+
+func TestFindOneCachesUntilWrite(t *testing.T) {
+	ctx := requestctx.WithTenant(context.Background(), "acme")
+	cached := NewUserRepository(memory.NewRepository(logging.GetLogger()).User, 10, time.Minute)
+	if _, err := cached.Create(ctx, user.User{ID: "1", Username: "alice", Email: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := cached.FindOne(ctx, "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := cached.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Size != 1 {
+		t.Fatalf("got stats %+v, want 1 hit, 1 miss and 1 entry", stats)
+	}
+	if err := cached.Update(ctx, user.User{ID: "1", Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := cached.Stats(); stats.Size != 0 {
+		t.Fatalf("Update did not evict the user: got stats %+v", stats)
+	}
+}
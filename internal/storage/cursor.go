@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/user"
+	"strings"
+	"time"
+)
+
+// Cursor is the position right after the last user of a page: its ID and
+// its values of the sort fields, in sort order. Clients only ever see it
+// base64 encoded, so its shape can change freely.
+type Cursor struct {
+	ID     string   `json:"id"`
+	Values []string `json:"values,omitempty"`
+}
+
+func EncodeCursor(c Cursor) string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor returns the position encoded in s as a user that has only
+// the ID and the sort fields set, so repositories can compare against it.
+func DecodeCursor(s string, sort []user.SortField) (position user.User, err error) {
+	invalid := apperrors.BadRequestError("invalid cursor")
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return position, invalid
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" || len(c.Values) != len(sort) {
+		return position, invalid
+	}
+
+	position.ID = c.ID
+	for i, field := range sort {
+		switch field.Field {
+		case user.FieldUsername:
+			position.Username = c.Values[i]
+		case user.FieldEmail:
+			position.Email = c.Values[i]
+		case user.FieldCreatedAt:
+			if position.CreatedAt, err = time.Parse(time.RFC3339Nano, c.Values[i]); err != nil {
+				return position, invalid
+			}
+		}
+	}
+	return position, nil
+}
+
+// SortValue returns the value of a sortable field as stored in a cursor.
+func SortValue(u user.User, field string) string {
+	switch field {
+	case user.FieldUsername:
+		return u.Username
+	case user.FieldEmail:
+		return u.Email
+	case user.FieldCreatedAt:
+		return u.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return ""
+}
+
+// CompareUsers orders users by sort and then by ID, the same way every
+// repository must order FindAll results.
+func CompareUsers(a, b user.User, sort []user.SortField) int {
+	for _, field := range sort {
+		var c int
+		if field.Field == user.FieldCreatedAt {
+			switch {
+			case a.CreatedAt.Before(b.CreatedAt):
+				c = -1
+			case a.CreatedAt.After(b.CreatedAt):
+				c = 1
+			}
+		} else {
+			c = strings.Compare(SortValue(a, field.Field), SortValue(b, field.Field))
+		}
+		if field.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// NewPage builds a page from up to query.Limit+1 users. Repositories fetch
+// one extra user to learn whether another page follows without counting.
+func NewPage(users []user.User, query user.ListQuery) user.Page {
+	page := user.Page{Users: users}
+	if page.Users == nil {
+		page.Users = []user.User{}
+	}
+	if query.Limit > 0 && len(users) > query.Limit {
+		page.Users = users[:query.Limit]
+		last := page.Users[query.Limit-1]
+		cursor := Cursor{ID: last.ID}
+		for _, field := range query.Sort {
+			cursor.Values = append(cursor.Values, SortValue(last, field.Field))
+		}
+		page.NextCursor = EncodeCursor(cursor)
+	}
+	return page
+}
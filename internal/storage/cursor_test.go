@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"encoding/base64"
+	"rest-api-go/internal/entities/user"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	sort := []user.SortField{{Field: user.FieldUsername}, {Field: user.FieldCreatedAt, Desc: true}, {Field: user.FieldEmail}}
+	last := user.User{
+		ID:        "id-2",
+		Username:  "bob",
+		Email:     "bob@example.com",
+		CreatedAt: time.Date(2023, 8, 1, 12, 0, 0, 123456789, time.UTC),
+	}
+	page := NewPage([]user.User{{ID: "id-1"}, last, {ID: "id-3"}}, user.ListQuery{Limit: 2, Sort: sort})
+	if len(page.Users) != 2 || page.NextCursor == "" {
+		t.Fatalf("NewPage: got %d users and cursor %q, want 2 users and a cursor", len(page.Users), page.NextCursor)
+	}
+
+	position, err := DecodeCursor(page.NextCursor, sort)
+	if err != nil {
+		t.Fatalf("DecodeCursor: unexpected error: %v", err)
+	}
+	if position.ID != last.ID || position.Username != last.Username || position.Email != last.Email ||
+		!position.CreatedAt.Equal(last.CreatedAt) {
+		t.Fatalf("DecodeCursor: got %+v, want the position of %+v", position, last)
+	}
+}
+
+func TestNewPageWithoutNextPage(t *testing.T) {
+	page := NewPage(nil, user.ListQuery{Limit: 2})
+	if page.Users == nil || len(page.Users) != 0 || page.NextCursor != "" {
+		t.Fatalf("NewPage of no users: got %+v, want an empty page without cursor", page)
+	}
+	page = NewPage([]user.User{{ID: "id-1"}, {ID: "id-2"}}, user.ListQuery{Limit: 2})
+	if len(page.Users) != 2 || page.NextCursor != "" {
+		t.Fatalf("NewPage of a full last page: got %+v, want 2 users without cursor", page)
+	}
+}
+
+func TestDecodeCursorRejectsInvalidCursors(t *testing.T) {
+	sort := []user.SortField{{Field: user.FieldCreatedAt}}
+	raw := func(json string) string { return base64.RawURLEncoding.EncodeToString([]byte(json)) }
+	tests := map[string]string{
+		"not base64":         "!!!",
+		"not json":           raw("id"),
+		"no id":              raw(`{"values":["2023-08-01T12:00:00Z"]}`),
+		"missing value":      raw(`{"id":"id-1"}`),
+		"extra value":        raw(`{"id":"id-1","values":["2023-08-01T12:00:00Z","x"]}`),
+		"malformed sort key": raw(`{"id":"id-1","values":["yesterday"]}`),
+	}
+	for name, cursor := range tests {
+		if _, err := DecodeCursor(cursor, sort); err == nil {
+			t.Errorf("DecodeCursor of %s: got no error", name)
+		}
+	}
+}
+
+func TestCompareUsers(t *testing.T) {
+	early := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	a := user.User{ID: "a", Username: "alice", CreatedAt: early}
+	b := user.User{ID: "b", Username: "alice", CreatedAt: early.Add(time.Second)}
+	tests := []struct {
+		name string
+		sort []user.SortField
+		want int
+	}{
+		{"by id", nil, -1},
+		{"tie broken by id", []user.SortField{{Field: user.FieldUsername}}, -1},
+		{"descending tie still broken by ascending id", []user.SortField{{Field: user.FieldUsername, Desc: true}}, -1},
+		{"by created_at", []user.SortField{{Field: user.FieldCreatedAt}}, -1},
+		{"by created_at descending", []user.SortField{{Field: user.FieldCreatedAt, Desc: true}}, 1},
+	}
+	for _, tt := range tests {
+		if got := CompareUsers(a, b, tt.sort); got != tt.want {
+			t.Errorf("CompareUsers %s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package encrypted
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/storage"
+)
+
+// HistoryRepository seals the before and after values of the changes of
+// encrypted fields, bound to the user id. Records stored before encryption
+// are read as they are. Stream and ReplaceChanges pass the stored values
+// through sealed, for Reencrypt.
+type HistoryRepository struct {
+	storage.HistoryRepository
+	keyring *Keyring
+}
+
+func (r *HistoryRepository) Create(ctx context.Context, record history.Record) error {
+	if record.Changes != nil {
+		changes := make([]history.Change, len(record.Changes))
+		for i, change := range record.Changes {
+			if !encryptedField(change.Field) {
+				changes[i] = change
+				continue
+			}
+			var err error
+			if changes[i], err = r.sealChange(record.UserID, change); err != nil {
+				return err
+			}
+		}
+		record.Changes = changes
+	}
+	return r.HistoryRepository.Create(ctx, record)
+}
+func (r *HistoryRepository) FindByUserID(ctx context.Context, userID string) ([]history.Record, error) {
+	records, err := r.HistoryRepository.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	opened := make([]history.Record, len(records))
+	for i, record := range records {
+		changes := make([]history.Change, len(record.Changes))
+		for j, change := range record.Changes {
+			if !encryptedField(change.Field) {
+				changes[j] = change
+				continue
+			}
+			if changes[j], err = r.openChange(record.UserID, change); err != nil {
+				return nil, err
+			}
+		}
+		if record.Changes != nil {
+			record.Changes = changes
+		}
+		opened[i] = record
+	}
+	return opened, nil
+}
+
+// Reencrypt seals the changes of the records of the tenant of ctx that are
+// stored in plaintext or with a key other than the primary one again with
+// the primary key. Records anonymized in between are skipped and counted.
+func (r *HistoryRepository) Reencrypt(ctx context.Context) (reencrypted, skipped int, err error) {
+	err = r.HistoryRepository.Stream(ctx, func(record history.Record) error {
+		changes := make([]history.Change, len(record.Changes))
+		stale := false
+		for i, change := range record.Changes {
+			changes[i] = change
+			if !encryptedField(change.Field) || !r.stale(change.Before, change.After) {
+				continue
+			}
+			stale = true
+			opened, err := r.openChange(record.UserID, change)
+			if err != nil {
+				return err
+			}
+			if changes[i], err = r.sealChange(record.UserID, opened); err != nil {
+				return err
+			}
+		}
+		if !stale {
+			return nil
+		}
+		err := r.HistoryRepository.ReplaceChanges(ctx, record.ID, record.Changes, changes)
+		if errors.Is(err, apperrors.ErrPreconditionFailed) {
+			skipped++
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reencrypted++
+		return nil
+	})
+	return reencrypted, skipped, err
+}
+
+// stale reports whether one of values is in plaintext or sealed with a key
+// other than the primary one
+func (r *HistoryRepository) stale(values ...string) bool {
+	for _, value := range values {
+		if value != "" && KeyID(value) != r.keyring.Primary() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *HistoryRepository) sealChange(userID string, change history.Change) (history.Change, error) {
+	for _, value := range []*string{&change.Before, &change.After} {
+		if *value == "" {
+			continue
+		}
+		envelope, err := r.keyring.Seal([]byte(*value), []byte(userID))
+		if err != nil {
+			return change, fmt.Errorf("error encrypting history of user %s: %w", userID, err)
+		}
+		*value = envelope
+	}
+	return change, nil
+}
+func (r *HistoryRepository) openChange(userID string, change history.Change) (history.Change, error) {
+	for _, value := range []*string{&change.Before, &change.After} {
+		if KeyID(*value) == "" {
+			continue
+		}
+		plaintext, err := r.keyring.Open(*value, []byte(userID))
+		if err != nil {
+			return change, fmt.Errorf("error decrypting history of user %s: %w", userID, err)
+		}
+		*value = string(plaintext)
+	}
+	return change, nil
+}
+
+// NewHistoryRepository encrypts the history of repository with the keys of
+// keyring
+func NewHistoryRepository(repository storage.HistoryRepository, keyring *Keyring) *HistoryRepository {
+	return &HistoryRepository{HistoryRepository: repository, keyring: keyring}
+}
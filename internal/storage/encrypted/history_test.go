@@ -0,0 +1,106 @@
+package encrypted_test
+
+import (
+	"bytes"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/encrypted"
+	memoryHistory "rest-api-go/internal/storage/memory/history"
+	"rest-api-go/internal/storage/storagetest"
+	"rest-api-go/pkg/logging"
+	"strings"
+	"testing"
+)
+
+func TestHistoryRepository(t *testing.T) {
+	storagetest.RunHistoryRepositoryTests(t, func(t *testing.T) storage.HistoryRepository {
+		return encrypted.NewHistoryRepository(memoryHistory.NewHistoryRepository(logging.GetLogger()), newKeyring(t))
+	})
+}
+
+func TestHistoryRepositoryStoresSealedEmails(t *testing.T) {
+	ctx := tenantContext()
+	stored := memoryHistory.NewHistoryRepository(logging.GetLogger())
+	records := encrypted.NewHistoryRepository(stored, newKeyring(t))
+	changes := []history.Change{
+		{Field: "username", Before: "alice", After: "bob"},
+		{Field: "email", Before: "alice@example.com", After: "bob@example.com"},
+	}
+	if err := records.Create(ctx, history.Record{UserID: "1", Action: history.ActionUpdated, Changes: changes}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := stored.FindByUserID(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := raw[0].Changes; got[0] != changes[0] || strings.Contains(got[1].Before+got[1].After, "example.com") {
+		t.Fatalf("got stored changes %+v, want the emails sealed", got)
+	}
+	found, err := records.FindByUserID(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := found[0].Changes; len(got) != 2 || got[0] != changes[0] || got[1] != changes[1] {
+		t.Fatalf("got changes %+v, want %+v", got, changes)
+	}
+}
+
+// keyringOf returns a keyring of the keys k1 and k2 that are named, with
+// the blind index key of newKeyring
+func keyringOf(t *testing.T, primary string, ids ...string) *encrypted.Keyring {
+	t.Helper()
+	keys := map[string][]byte{}
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte{map[string]byte{"k1": 1, "k2": 3}[id]}, 32)
+	}
+	keyring, err := encrypted.NewKeyring(primary, keys, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestHistoryRepositoryReencrypt(t *testing.T) {
+	ctx := tenantContext()
+	stored := memoryHistory.NewHistoryRepository(logging.GetLogger())
+	sealed := []history.Change{{Field: "email", Before: "alice@example.com", After: "bob@example.com"}}
+	if err := encrypted.NewHistoryRepository(stored, keyringOf(t, "k1", "k1")).Create(ctx,
+		history.Record{UserID: "1", Action: history.ActionUpdated, Changes: sealed}); err != nil {
+		t.Fatal(err)
+	}
+	// stored before encryption
+	plaintext := []history.Change{{Field: "email", After: "carol@example.com"}, {Field: "password"}}
+	if err := stored.Create(ctx, history.Record{UserID: "2", Action: history.ActionCreated, Changes: plaintext}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stored.Create(ctx, history.Record{UserID: "2", Action: history.ActionDeleted}); err != nil {
+		t.Fatal(err)
+	}
+
+	reencrypted, skipped, err := encrypted.NewHistoryRepository(stored, keyringOf(t, "k2", "k1", "k2")).Reencrypt(ctx)
+	if err != nil || reencrypted != 2 || skipped != 0 {
+		t.Fatalf("Reencrypt: got %d reencrypted, %d skipped, %v, want 2, 0, nil", reencrypted, skipped, err)
+	}
+	// the old key can be retired
+	records := encrypted.NewHistoryRepository(stored, keyringOf(t, "k2", "k2"))
+	for userID, want := range map[string][]history.Change{"1": sealed, "2": plaintext} {
+		found, err := records.FindByUserID(ctx, userID)
+		if err != nil {
+			t.Fatalf("FindByUserID of %s with the old key retired: unexpected error: %v", userID, err)
+		}
+		if got := found[0].Changes; len(got) != len(want) || got[0] != want[0] {
+			t.Fatalf("FindByUserID of %s: got changes %+v, want %+v", userID, got, want)
+		}
+	}
+	raw, err := stored.FindByUserID(ctx, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(raw[0].Changes[0].After, "example.com") {
+		t.Fatalf("got stored changes %+v, want the email sealed", raw[0].Changes)
+	}
+	if reencrypted, _, err := records.Reencrypt(ctx); err != nil || reencrypted != 0 {
+		t.Fatalf("second Reencrypt: got %d reencrypted, %v, want none", reencrypted, err)
+	}
+}
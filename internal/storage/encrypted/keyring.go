@@ -0,0 +1,204 @@
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// keySize is the size of every key, they are all AES-256 or HMAC-SHA256 keys
+const keySize = 32
+
+const (
+	envelopeVersion   = "v1"
+	blindIndexVersion = "bidx1"
+)
+
+// Keyring holds the key encryption keys by id and the blind index key. New
+// envelopes are sealed with the primary key, the others only open old ones.
+//
+// The keyring file is JSON with base64 keys of 32 bytes:
+//
+//	{
+//		"primary": "2024-01",
+//		"keys": {"2023-07": "...", "2024-01": "..."},
+//		"blind_index_key": "..."
+//	}
+//
+// The blind index key cannot be rotated, lookups by email depend on it.
+type Keyring struct {
+	primary       string
+	keys          map[string]cipher.AEAD
+	blindIndexKey []byte
+}
+
+type keyringFile struct {
+	Primary       string            `json:"primary"`
+	Keys          map[string]string `json:"keys"`
+	BlindIndexKey string            `json:"blind_index_key"`
+}
+
+// LoadKeyring reads a keyring file
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keyring: %w", err)
+	}
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error decoding keyring %s: %w", path, err)
+	}
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		if keys[id], err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, fmt.Errorf("error decoding key %s of keyring %s: %w", id, path, err)
+		}
+	}
+	blindIndexKey, err := base64.StdEncoding.DecodeString(file.BlindIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding blind index key of keyring %s: %w", path, err)
+	}
+	return NewKeyring(file.Primary, keys, blindIndexKey)
+}
+
+// NewKeyring checks the keys and returns a keyring sealing with primary
+func NewKeyring(primary string, keys map[string][]byte, blindIndexKey []byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", primary)
+	}
+	if len(blindIndexKey) != keySize {
+		return nil, fmt.Errorf("blind index key must be %d bytes", keySize)
+	}
+	keyring := &Keyring{
+		primary:       primary,
+		keys:          make(map[string]cipher.AEAD, len(keys)),
+		blindIndexKey: blindIndexKey,
+	}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("key id %q must be non-empty and without colons", id)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("key %s must be %d bytes", id, keySize)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		keyring.keys[id] = aead
+	}
+	return keyring, nil
+}
+
+// Primary returns the id of the key new envelopes are sealed with
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// BlindIndex returns a deterministic token for value that can be looked up
+// and kept unique without revealing value
+func (k *Keyring) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, k.blindIndexKey)
+	mac.Write([]byte(value))
+	return blindIndexVersion + ":" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Seal encrypts plaintext with a new data key and wraps the data key with
+// the primary key. The envelope only opens with the same associated data.
+//
+// The envelope is v1:<key id>:<wrapped data key>:<ciphertext>, where both
+// binary parts are a nonce followed by the AES-GCM output, in base64.
+func (k *Keyring) Seal(plaintext, associatedData []byte) (string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("error generating data key: %w", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(data, plaintext, associatedData)
+	if err != nil {
+		return "", err
+	}
+	header := envelopeVersion + ":" + k.primary
+	wrappedKey, err := seal(k.keys[k.primary], dataKey, []byte(header))
+	if err != nil {
+		return "", err
+	}
+	return header + ":" + base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts an envelope made by Seal with any key of the keyring
+func (k *Keyring) Open(envelope string, associatedData []byte) ([]byte, error) {
+	parts := strings.Split(envelope, ":")
+	if len(parts) != 4 || parts[0] != envelopeVersion {
+		return nil, errors.New("malformed envelope")
+	}
+	kek, ok := k.keys[parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("envelope key %q is not in the keyring", parts[1])
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed envelope")
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, errors.New("malformed envelope")
+	}
+	dataKey, err := open(kek, wrappedKey, []byte(parts[0]+":"+parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key: %w", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(data, ciphertext, associatedData)
+}
+
+// KeyID returns the id of the key an envelope was sealed with
+func KeyID(envelope string) string {
+	parts := strings.SplitN(envelope, ":", 3)
+	if len(parts) < 3 || parts[0] != envelopeVersion {
+		return ""
+	}
+	return parts[1]
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+func seal(aead cipher.AEAD, plaintext, associatedData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+func open(aead cipher.AEAD, sealed, associatedData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed envelope")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, associatedData)
+	if err != nil {
+		return nil, errors.New("envelope does not open, it was changed or moved")
+	}
+	return plaintext, nil
+}
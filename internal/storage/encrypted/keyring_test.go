@@ -0,0 +1,140 @@
+package encrypted
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func newTestKeyring(t *testing.T, primary string) *Keyring {
+	t.Helper()
+	keyring, err := NewKeyring(primary, map[string][]byte{"old": testKey(1), "new": testKey(2)}, testKey(3))
+	if err != nil {
+		t.Fatalf("NewKeyring: unexpected error: %v", err)
+	}
+	return keyring
+}
+
+func TestKeyringSealOpen(t *testing.T) {
+	keyring := newTestKeyring(t, "new")
+	envelope, err := keyring.Seal([]byte("alice@example.com"), []byte("user-1"))
+	if err != nil {
+		t.Fatalf("Seal: unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(envelope, "v1:new:") || KeyID(envelope) != "new" {
+		t.Fatalf("Seal: got envelope %q, want a v1 envelope of key new", envelope)
+	}
+	if strings.Contains(envelope, "alice") {
+		t.Fatalf("Seal: envelope %q holds the plaintext", envelope)
+	}
+	if again, _ := keyring.Seal([]byte("alice@example.com"), []byte("user-1")); again == envelope {
+		t.Fatal("Seal: got the same envelope twice")
+	}
+
+	plaintext, err := keyring.Open(envelope, []byte("user-1"))
+	if err != nil || string(plaintext) != "alice@example.com" {
+		t.Fatalf("Open: got %q, %v", plaintext, err)
+	}
+	if _, err := keyring.Open(envelope, []byte("user-2")); err == nil {
+		t.Fatal("Open with other associated data: got no error")
+	}
+}
+
+func TestKeyringOpensEnvelopesOfOldKeys(t *testing.T) {
+	envelope, err := newTestKeyring(t, "old").Seal([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := newTestKeyring(t, "new")
+	if plaintext, err := rotated.Open(envelope, nil); err != nil || string(plaintext) != "secret" {
+		t.Fatalf("Open after rotation: got %q, %v", plaintext, err)
+	}
+
+	retired, err := NewKeyring("new", map[string][]byte{"new": testKey(2)}, testKey(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retired.Open(envelope, nil); err == nil {
+		t.Fatal("Open without the key: got no error")
+	}
+}
+
+func TestKeyringOpenRejectsMalformedEnvelopes(t *testing.T) {
+	keyring := newTestKeyring(t, "new")
+	envelope, err := keyring.Seal([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(envelope, ":")
+	for _, malformed := range []string{
+		"",
+		"alice@example.com",
+		"v2:" + strings.Join(parts[1:], ":"),
+		strings.Join(parts[:3], ":"),
+		strings.Join(parts[:3], ":") + ":!!",
+		strings.Join(parts[:3], ":") + ":" + parts[2],
+	} {
+		if _, err := keyring.Open(malformed, nil); err == nil {
+			t.Errorf("Open(%q): got no error", malformed)
+		}
+	}
+	if id := KeyID("alice@example.com"); id != "" {
+		t.Errorf("KeyID of plaintext: got %q, want none", id)
+	}
+}
+
+func TestKeyringBlindIndex(t *testing.T) {
+	keyring := newTestKeyring(t, "new")
+	index := keyring.BlindIndex("alice@example.com")
+	if !strings.HasPrefix(index, "bidx1:") || strings.Contains(index, "alice") {
+		t.Fatalf("BlindIndex: got %q", index)
+	}
+	if again := newTestKeyring(t, "old").BlindIndex("alice@example.com"); again != index {
+		t.Fatalf("BlindIndex: got %q and %q for the same value", index, again)
+	}
+	if other := keyring.BlindIndex("bob@example.com"); other == index {
+		t.Fatal("BlindIndex: got the same index for different values")
+	}
+}
+
+func TestNewKeyringChecksKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		primary string
+		keys    map[string][]byte
+		index   []byte
+	}{
+		{"missing primary", "new", map[string][]byte{"old": testKey(1)}, testKey(3)},
+		{"short blind index key", "new", map[string][]byte{"new": testKey(2)}, testKey(3)[:16]},
+		{"short key", "new", map[string][]byte{"new": testKey(2)[:16]}, testKey(3)},
+		{"key id with a colon", "a:b", map[string][]byte{"a:b": testKey(2)}, testKey(3)},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeyring(tt.primary, tt.keys, tt.index); err == nil {
+			t.Errorf("NewKeyring with %s: got no error", tt.name)
+		}
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	encode := base64.StdEncoding.EncodeToString
+	file := `{"primary":"new","keys":{"new":"` + encode(testKey(2)) + `"},"blind_index_key":"` + encode(testKey(3)) + `"}`
+	if err := os.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring: unexpected error: %v", err)
+	}
+	if keyring.Primary() != "new" || keyring.BlindIndex("x") != newTestKeyring(t, "new").BlindIndex("x") {
+		t.Fatal("LoadKeyring: got another keyring than the file describes")
+	}
+}
@@ -0,0 +1,104 @@
+package encrypted
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/outbox"
+	"rest-api-go/internal/storage"
+)
+
+// OutboxRepository seals the payload of every event it adds, bound to the
+// aggregate id, and stores the envelope as a JSON string. Events are opened
+// when they are read, so the relay publishes them as they were added.
+// Anonymized payloads and events stored before encryption are read as they
+// are. Stream and ReplacePayload pass the stored payloads through sealed,
+// for Reencrypt.
+type OutboxRepository struct {
+	storage.OutboxRepository
+	keyring *Keyring
+}
+
+func (r *OutboxRepository) Add(ctx context.Context, event outbox.Event) error {
+	envelope, err := r.keyring.Seal(event.Payload, []byte(event.AggregateID))
+	if err != nil {
+		return fmt.Errorf("error encrypting %s event: %w", event.Type, err)
+	}
+	if event.Payload, err = json.Marshal(envelope); err != nil {
+		return err
+	}
+	return r.OutboxRepository.Add(ctx, event)
+}
+func (r *OutboxRepository) FetchPending(ctx context.Context, limit int) ([]outbox.Event, error) {
+	events, err := r.OutboxRepository.FetchPending(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	return r.open(events)
+}
+func (r *OutboxRepository) FindByAggregateID(ctx context.Context, aggregateID string) ([]outbox.Event, error) {
+	events, err := r.OutboxRepository.FindByAggregateID(ctx, aggregateID)
+	if err != nil {
+		return nil, err
+	}
+	return r.open(events)
+}
+
+// Reencrypt seals the payloads of the events of the tenant of ctx that are
+// stored in plaintext or with a key other than the primary one again with
+// the primary key. Events anonymized in between are skipped and counted.
+func (r *OutboxRepository) Reencrypt(ctx context.Context) (reencrypted, skipped int, err error) {
+	err = r.OutboxRepository.Stream(ctx, func(event outbox.Event) error {
+		var envelope string
+		if json.Unmarshal(event.Payload, &envelope) == nil && KeyID(envelope) == r.keyring.Primary() {
+			return nil
+		}
+		opened, err := r.open([]outbox.Event{event})
+		if err != nil {
+			return err
+		}
+		if envelope, err = r.keyring.Seal(opened[0].Payload, []byte(event.AggregateID)); err != nil {
+			return fmt.Errorf("error encrypting event %s: %w", event.ID, err)
+		}
+		payload, err := json.Marshal(envelope)
+		if err != nil {
+			return err
+		}
+		err = r.OutboxRepository.ReplacePayload(ctx, event.ID, event.Payload, payload)
+		if errors.Is(err, apperrors.ErrPreconditionFailed) {
+			skipped++
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reencrypted++
+		return nil
+	})
+	return reencrypted, skipped, err
+}
+
+func (r *OutboxRepository) open(events []outbox.Event) ([]outbox.Event, error) {
+	opened := make([]outbox.Event, len(events))
+	for i, event := range events {
+		opened[i] = event
+		var envelope string
+		if json.Unmarshal(event.Payload, &envelope) != nil || KeyID(envelope) == "" {
+			continue
+		}
+		payload, err := r.keyring.Open(envelope, []byte(event.AggregateID))
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting event %s: %w", event.ID, err)
+		}
+		opened[i].Payload = payload
+	}
+	return opened, nil
+}
+
+// NewOutboxRepository encrypts the events of repository with the keys of
+// keyring
+func NewOutboxRepository(repository storage.OutboxRepository, keyring *Keyring) *OutboxRepository {
+	return &OutboxRepository{OutboxRepository: repository, keyring: keyring}
+}
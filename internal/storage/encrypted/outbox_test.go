@@ -0,0 +1,91 @@
+package encrypted_test
+
+import (
+	"encoding/json"
+	"rest-api-go/internal/entities/outbox"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/encrypted"
+	memoryOutbox "rest-api-go/internal/storage/memory/outbox"
+	"rest-api-go/internal/storage/storagetest"
+	"rest-api-go/pkg/logging"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutboxRepository(t *testing.T) {
+	storagetest.RunOutboxRepositoryTests(t, func(t *testing.T) storage.OutboxRepository {
+		return encrypted.NewOutboxRepository(memoryOutbox.NewOutboxRepository(logging.GetLogger()), newKeyring(t))
+	})
+}
+
+func TestOutboxRepositoryStoresSealedPayloads(t *testing.T) {
+	ctx := tenantContext()
+	stored := memoryOutbox.NewOutboxRepository(logging.GetLogger())
+	events := encrypted.NewOutboxRepository(stored, newKeyring(t))
+	payload := json.RawMessage(`{"id":"1","email":"alice@example.com"}`)
+	event := outbox.Event{Type: outbox.UserCreated, TenantID: "acme", AggregateID: "1", Payload: payload, OccurredAt: time.Now()}
+	if err := events.Add(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := stored.FindByAggregateID(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope string
+	if err := json.Unmarshal(raw[0].Payload, &envelope); err != nil || strings.Contains(envelope, "alice") {
+		t.Fatalf("got stored payload %s, want an envelope", raw[0].Payload)
+	}
+	pending, err := events.FetchPending(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || string(pending[0].Payload) != string(payload) {
+		t.Fatalf("FetchPending: got %+v, want the payload as it was added", pending)
+	}
+
+	if err := events.Anonymize(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	found, err := events.FindByAggregateID(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(found[0].Payload) != string(outbox.AnonymousPayload("1")) {
+		t.Fatalf("FindByAggregateID after Anonymize: got %s", found[0].Payload)
+	}
+}
+
+func TestOutboxRepositoryReencrypt(t *testing.T) {
+	ctx := tenantContext()
+	stored := memoryOutbox.NewOutboxRepository(logging.GetLogger())
+	sealed := json.RawMessage(`{"id":"1","email":"alice@example.com"}`)
+	event := outbox.Event{Type: outbox.UserCreated, TenantID: "acme", AggregateID: "1", Payload: sealed, OccurredAt: time.Now()}
+	if err := encrypted.NewOutboxRepository(stored, keyringOf(t, "k1", "k1")).Add(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	// stored before encryption
+	plaintext := json.RawMessage(`{"id":"2","email":"bob@example.com"}`)
+	event = outbox.Event{Type: outbox.UserCreated, TenantID: "acme", AggregateID: "2", Payload: plaintext, OccurredAt: time.Now()}
+	if err := stored.Add(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+
+	reencrypted, skipped, err := encrypted.NewOutboxRepository(stored, keyringOf(t, "k2", "k1", "k2")).Reencrypt(ctx)
+	if err != nil || reencrypted != 2 || skipped != 0 {
+		t.Fatalf("Reencrypt: got %d reencrypted, %d skipped, %v, want 2, 0, nil", reencrypted, skipped, err)
+	}
+	// the old key can be retired
+	events := encrypted.NewOutboxRepository(stored, keyringOf(t, "k2", "k2"))
+	pending, err := events.FetchPending(ctx, 10)
+	if err != nil {
+		t.Fatalf("FetchPending with the old key retired: unexpected error: %v", err)
+	}
+	if len(pending) != 2 || string(pending[0].Payload) != string(sealed) || string(pending[1].Payload) != string(plaintext) {
+		t.Fatalf("FetchPending: got %+v, want the payloads as they were added", pending)
+	}
+	if reencrypted, _, err := events.Reencrypt(ctx); err != nil || reencrypted != 0 {
+		t.Fatalf("second Reencrypt: got %d reencrypted, %v, want none", reencrypted, err)
+	}
+}
@@ -0,0 +1,268 @@
+// Package encrypted provides a decorator that encrypts the personal fields
+// of users before they reach a repository.
+package encrypted
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/storage"
+	"time"
+)
+
+// fields are the encrypted fields of a user, sealed together in one envelope
+type fields struct {
+	Email string `json:"email"`
+}
+
+// encryptedField reports whether name, as history.Diff names fields, is one
+// of fields
+func encryptedField(name string) bool {
+	return name == "email"
+}
+
+// UserRepository seals the email of every user it writes into
+// user.EncryptedFields and stores the blind index of the email in its place,
+// so repositories keep looking up and enforcing unique emails unchanged.
+// The envelope is bound to the user id and cannot be moved to another user.
+//
+// Users stored before encryption are read as they are until Reencrypt seals
+// them. Until then lookups by email fall back to the plaintext email, and
+// writes check it so an email stays unique across both. Sorting by email is
+// refused with a bad request before anything is read, the order would be
+// meaningless. HistoryRepository and OutboxRepository encrypt the copies
+// kept by the history and the outbox.
+type UserRepository struct {
+	storage.UserRepository
+	keyring *Keyring
+}
+
+func (r *UserRepository) Create(ctx context.Context, u user.User) (string, error) {
+	if err := r.checkPlaintextEmail(ctx, u.ID, u.Email); err != nil {
+		return "", err
+	}
+	sealed, err := r.seal(u)
+	if err != nil {
+		return "", err
+	}
+	return r.UserRepository.Create(ctx, sealed)
+}
+func (r *UserRepository) CreateMany(ctx context.Context, users []user.User) ([]user.BatchItem, error) {
+	items := make([]user.BatchItem, len(users))
+	var sealed []user.User
+	var pending []int
+	for i, u := range users {
+		err := r.checkPlaintextEmail(ctx, u.ID, u.Email)
+		if errors.Is(err, apperrors.ErrConflict) {
+			items[i].Error = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s, err := r.seal(u)
+		if err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, s)
+		pending = append(pending, i)
+	}
+	if len(sealed) == 0 {
+		return items, nil
+	}
+	created, err := r.UserRepository.CreateMany(ctx, sealed)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range pending {
+		items[i] = created[j]
+	}
+	return items, nil
+}
+func (r *UserRepository) FindOne(ctx context.Context, id string) (user.User, error) {
+	u, err := r.UserRepository.FindOne(ctx, id)
+	if err != nil {
+		return u, err
+	}
+	return r.open(u)
+}
+func (r *UserRepository) FindOneWithDeleted(ctx context.Context, id string) (user.User, error) {
+	u, err := r.UserRepository.FindOneWithDeleted(ctx, id)
+	if err != nil {
+		return u, err
+	}
+	return r.open(u)
+}
+func (r *UserRepository) FindAll(ctx context.Context, query user.ListQuery) (user.Page, error) {
+	for _, field := range query.Sort {
+		if field.Field == user.FieldEmail {
+			return user.Page{}, apperrors.BadRequestError("emails are encrypted and cannot be sorted")
+		}
+	}
+	email := query.Email
+	if email != "" {
+		query.Email = r.keyring.BlindIndex(email)
+	}
+	page, err := r.UserRepository.FindAll(ctx, query)
+	if err != nil {
+		return page, err
+	}
+	if email != "" && len(page.Users) == 0 && query.Cursor == "" {
+		// emails are unique, so at most one user stored before encryption matches
+		query.Email = email
+		if page, err = r.UserRepository.FindAll(ctx, query); err != nil {
+			return page, err
+		}
+	}
+	for i := range page.Users {
+		if page.Users[i], err = r.open(page.Users[i]); err != nil {
+			return user.Page{}, err
+		}
+	}
+	return page, nil
+}
+func (r *UserRepository) Stream(ctx context.Context, fn func(user.User) error) error {
+	return r.UserRepository.Stream(ctx, func(u user.User) error {
+		opened, err := r.open(u)
+		if err != nil {
+			return err
+		}
+		return fn(opened)
+	})
+}
+func (r *UserRepository) Update(ctx context.Context, u user.User) error {
+	// the envelope only holds the email, so it is replaced with it
+	u.EncryptedFields = ""
+	if u.Email != "" {
+		if err := r.checkPlaintextEmail(ctx, u.ID, u.Email); err != nil {
+			return err
+		}
+		var err error
+		if u, err = r.seal(u); err != nil {
+			return err
+		}
+	}
+	return r.UserRepository.Update(ctx, u)
+}
+
+// Restore seals a user stored before encryption, so a restored user cannot
+// share its email with a user written since. Run it within a unit of work
+// to undo the restore if the email is taken.
+func (r *UserRepository) Restore(ctx context.Context, id string, restoredAt time.Time) error {
+	if err := r.UserRepository.Restore(ctx, id, restoredAt); err != nil {
+		return err
+	}
+	stored, err := r.UserRepository.FindOne(ctx, id)
+	if err != nil {
+		return err
+	}
+	if stored.EncryptedFields != "" {
+		opened, err := r.open(stored)
+		if err != nil {
+			return err
+		}
+		return r.checkPlaintextEmail(ctx, id, opened.Email)
+	}
+	return r.Update(ctx, user.User{ID: id, Email: stored.Email, Version: stored.Version})
+}
+
+// Reencrypt seals the users of the tenant of ctx that are stored in
+// plaintext or with a key other than the primary one again with the primary
+// key. Users written in between are skipped and counted, run it again for
+// them. Deleted users are left as they are, so retire a key only once the
+// users deleted before the rotation are purged and the history and events
+// are reencrypted as well.
+func (r *UserRepository) Reencrypt(ctx context.Context) (reencrypted, skipped int, err error) {
+	err = r.UserRepository.Stream(ctx, func(stored user.User) error {
+		if stored.EncryptedFields != "" && KeyID(stored.EncryptedFields) == r.keyring.Primary() {
+			return nil
+		}
+		opened, err := r.open(stored)
+		if err != nil {
+			return err
+		}
+		sealed, err := r.seal(opened)
+		if err != nil {
+			return err
+		}
+		err = r.UserRepository.Update(ctx, user.User{
+			ID:              stored.ID,
+			Email:           sealed.Email,
+			EncryptedFields: sealed.EncryptedFields,
+			Version:         stored.Version,
+		})
+		if errors.Is(err, apperrors.ErrPreconditionFailed) || errors.Is(err, apperrors.ErrNotFound) {
+			skipped++
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reencrypted++
+		return nil
+	})
+	return reencrypted, skipped, err
+}
+
+// checkPlaintextEmail returns a conflict if a user other than id was stored
+// with email before encryption, the blind index cannot collide with it
+func (r *UserRepository) checkPlaintextEmail(ctx context.Context, id, email string) error {
+	if email == "" {
+		return nil
+	}
+	page, err := r.UserRepository.FindAll(ctx, user.ListQuery{Email: email, Limit: 1})
+	if err != nil {
+		return err
+	}
+	if len(page.Users) > 0 && page.Users[0].ID != id {
+		return apperrors.ConflictError("email")
+	}
+	return nil
+}
+
+// seal replaces the encrypted fields of u with their blind index and envelope
+func (r *UserRepository) seal(u user.User) (user.User, error) {
+	if u.ID == "" {
+		// the envelope is bound to the id, let the repository reject it
+		u.EncryptedFields = ""
+		return u, nil
+	}
+	plaintext, err := json.Marshal(fields{Email: u.Email})
+	if err != nil {
+		return u, fmt.Errorf("error encoding encrypted fields: %w", err)
+	}
+	envelope, err := r.keyring.Seal(plaintext, []byte(u.ID))
+	if err != nil {
+		return u, fmt.Errorf("error encrypting user %s: %w", u.ID, err)
+	}
+	u.Email = r.keyring.BlindIndex(u.Email)
+	u.EncryptedFields = envelope
+	return u, nil
+}
+
+// open restores the encrypted fields of u, users without an envelope were
+// stored in plaintext
+func (r *UserRepository) open(u user.User) (user.User, error) {
+	if u.EncryptedFields == "" {
+		return u, nil
+	}
+	plaintext, err := r.keyring.Open(u.EncryptedFields, []byte(u.ID))
+	if err != nil {
+		return u, fmt.Errorf("error decrypting user %s: %w", u.ID, err)
+	}
+	var f fields
+	if err := json.Unmarshal(plaintext, &f); err != nil {
+		return u, fmt.Errorf("error decoding encrypted fields of user %s: %w", u.ID, err)
+	}
+	u.Email = f.Email
+	u.EncryptedFields = ""
+	return u, nil
+}
+
+// NewUserRepository encrypts the users of repository with the keys of keyring
+func NewUserRepository(repository storage.UserRepository, keyring *Keyring) *UserRepository {
+	return &UserRepository{UserRepository: repository, keyring: keyring}
+}
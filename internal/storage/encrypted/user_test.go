@@ -0,0 +1,116 @@
+package encrypted_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/encrypted"
+	memoryUser "rest-api-go/internal/storage/memory/user"
+	"rest-api-go/internal/storage/storagetest"
+	"rest-api-go/pkg/logging"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newKeyring(t *testing.T) *encrypted.Keyring {
+	t.Helper()
+	keyring, err := encrypted.NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestUserRepository(t *testing.T) {
+	storagetest.RunUserRepositoryTests(t, func(t *testing.T) storage.UserRepository {
+		return encrypted.NewUserRepository(memoryUser.NewUserRepository(logging.GetLogger()), newKeyring(t))
+	}, storagetest.Unsortable(user.FieldEmail), storagetest.EncryptsFields())
+}
+
+func tenantContext() context.Context {
+	return requestctx.WithTenant(context.Background(), "acme")
+}
+
+func newUser(id, username, email string) user.User {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return user.User{ID: id, Username: username, Email: email, PasswordHash: "hash", CreatedAt: now, UpdatedAt: now}
+}
+
+func TestUserRepositoryStoresSealedEmails(t *testing.T) {
+	ctx := tenantContext()
+	stored := memoryUser.NewUserRepository(logging.GetLogger())
+	users := encrypted.NewUserRepository(stored, newKeyring(t))
+	if _, err := users.Create(ctx, newUser("1", "alice", "alice@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := stored.FindOne(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw.Email, "bidx1:") || encrypted.KeyID(raw.EncryptedFields) != "k1" {
+		t.Fatalf("got stored email %q and encrypted fields %q, want a blind index and an envelope", raw.Email, raw.EncryptedFields)
+	}
+}
+
+// TestUserRepositoryWithPlaintextUsers covers users stored before encryption
+// was enabled and not reencrypted yet
+func TestUserRepositoryWithPlaintextUsers(t *testing.T) {
+	ctx := tenantContext()
+	stored := memoryUser.NewUserRepository(logging.GetLogger())
+	if _, err := stored.Create(ctx, newUser("1", "alice", "alice@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	users := encrypted.NewUserRepository(stored, newKeyring(t))
+
+	page, err := users.FindAll(ctx, user.ListQuery{Email: "alice@example.com", Limit: 10})
+	if err != nil {
+		t.Fatalf("FindAll: unexpected error: %v", err)
+	}
+	if len(page.Users) != 1 || page.Users[0].ID != "1" {
+		t.Fatalf("FindAll by email: got %+v, want the plaintext user", page.Users)
+	}
+
+	if _, err := users.Create(ctx, newUser("2", "bob", "alice@example.com")); !errors.Is(err, apperrors.ErrConflict) {
+		t.Fatalf("Create with the email of a plaintext user: got error %v, want %v", err, apperrors.ErrConflict)
+	}
+	items, err := users.CreateMany(ctx, []user.User{newUser("2", "bob", "alice@example.com"), newUser("3", "carol", "carol@example.com")})
+	if err != nil {
+		t.Fatalf("CreateMany: unexpected error: %v", err)
+	}
+	if !errors.Is(items[0].Error, apperrors.ErrConflict) || items[1].Error != nil || items[1].ID != "3" {
+		t.Fatalf("CreateMany: got %+v, want a conflict and a created user", items)
+	}
+	if err := users.Update(ctx, user.User{ID: "3", Email: "alice@example.com"}); !errors.Is(err, apperrors.ErrConflict) {
+		t.Fatalf("Update to the email of a plaintext user: got error %v, want %v", err, apperrors.ErrConflict)
+	}
+	// the plaintext user keeps its own email when it is sealed
+	if err := users.Update(ctx, user.User{ID: "1", Email: "alice@example.com"}); err != nil {
+		t.Fatalf("Update of the plaintext user: unexpected error: %v", err)
+	}
+	if raw, _ := stored.FindOne(ctx, "1"); raw.EncryptedFields == "" {
+		t.Fatal("Update of the plaintext user: got it stored in plaintext")
+	}
+}
+
+func TestUserRepositoryRestoreSealsPlaintextUsers(t *testing.T) {
+	ctx := tenantContext()
+	stored := memoryUser.NewUserRepository(logging.GetLogger())
+	if _, err := stored.Create(ctx, newUser("1", "alice", "alice@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stored.Delete(ctx, "1", 0, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	users := encrypted.NewUserRepository(stored, newKeyring(t))
+	if _, err := users.Create(ctx, newUser("2", "bob", "alice@example.com")); err != nil {
+		t.Fatalf("Create: unexpected error: %v", err)
+	}
+	if err := users.Restore(ctx, "1", time.Now()); !errors.Is(err, apperrors.ErrConflict) {
+		t.Fatalf("Restore of a plaintext user with a taken email: got error %v, want %v", err, apperrors.ErrConflict)
+	}
+}
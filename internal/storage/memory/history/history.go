@@ -0,0 +1,165 @@
+package history
+
+import (
+	"context"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/memory/transaction"
+	"rest-api-go/pkg/logging"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type HistoryRepository struct {
+	mu      sync.RWMutex
+	records map[string][]history.Record
+	lastID  int64
+	logger  *logging.Logger
+	// Units makes writes outside a unit of work wait for the running one,
+	// nil when the repository is used without a Transactor
+	Units *transaction.Lock
+}
+
+func (d *HistoryRepository) Create(ctx context.Context, record history.Record) error {
+	d.logger.Debug("create history record")
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	record.TenantID = tenant
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastID++
+	record.ID = strconv.FormatInt(d.lastID, 10)
+	// copy the changes so callers can't modify a stored record
+	record.Changes = append([]history.Change(nil), record.Changes...)
+	d.records[record.UserID] = append(d.records[record.UserID], record)
+	return nil
+}
+func (d *HistoryRepository) FindByUserID(ctx context.Context, userID string) ([]history.Record, error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	records := make([]history.Record, 0, len(d.records[userID]))
+	for _, record := range d.records[userID] {
+		if record.TenantID == tenant {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+func (d *HistoryRepository) Anonymize(ctx context.Context, userID string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, record := range d.records[userID] {
+		if record.TenantID != tenant {
+			continue
+		}
+		// a new slice, snapshots share the old one
+		changes := make([]history.Change, len(record.Changes))
+		for j, change := range record.Changes {
+			changes[j] = history.Change{Field: change.Field}
+		}
+		d.records[userID][i].Changes = changes
+	}
+	return nil
+}
+func (d *HistoryRepository) Stream(ctx context.Context, fn func(history.Record) error) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	// copy first so fn never runs with the lock held
+	d.mu.RLock()
+	var records []history.Record
+	for _, r := range d.records {
+		for _, record := range r {
+			if record.TenantID == tenant {
+				records = append(records, record)
+			}
+		}
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return sequence(records[i].ID) < sequence(records[j].ID) })
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (d *HistoryRepository) ReplaceChanges(ctx context.Context, id string, old, changes []history.Change) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for userID, r := range d.records {
+		for i, record := range r {
+			if record.ID != id || record.TenantID != tenant {
+				continue
+			}
+			if !equalChanges(record.Changes, old) {
+				return apperrors.ErrPreconditionFailed
+			}
+			// a new slice, snapshots share the old one
+			d.records[userID][i].Changes = append([]history.Change(nil), changes...)
+			return nil
+		}
+	}
+	return apperrors.ErrPreconditionFailed
+}
+
+// sequence orders the ids, which count the records stored
+func sequence(id string) int64 {
+	n, _ := strconv.ParseInt(id, 10, 64)
+	return n
+}
+
+func equalChanges(a, b []history.Change) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+func (d *HistoryRepository) Snapshot() func() {
+	d.mu.RLock()
+	records := make(map[string][]history.Record, len(d.records))
+	for userID, r := range d.records {
+		records[userID] = append([]history.Record(nil), r...)
+	}
+	d.mu.RUnlock()
+	return func() {
+		d.mu.Lock()
+		d.records = records
+		d.mu.Unlock()
+	}
+}
+func NewHistoryRepository(logger *logging.Logger) *HistoryRepository {
+	return &HistoryRepository{
+		records: make(map[string][]history.Record),
+		logger:  logger,
+	}
+}
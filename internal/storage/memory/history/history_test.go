@@ -0,0 +1,15 @@
+package history_test
+
+import (
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/memory/history"
+	"rest-api-go/internal/storage/storagetest"
+	"rest-api-go/pkg/logging"
+	"testing"
+)
+
+func TestHistoryRepository(t *testing.T) {
+	storagetest.RunHistoryRepositoryTests(t, func(t *testing.T) storage.HistoryRepository {
+		return history.NewHistoryRepository(logging.GetLogger())
+	})
+}
@@ -0,0 +1,152 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/outbox"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/memory/transaction"
+	"rest-api-go/pkg/logging"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type OutboxRepository struct {
+	mu     sync.RWMutex
+	events []outbox.Event
+	lastID int64
+	logger *logging.Logger
+	// Units makes writes outside a unit of work wait for the running one,
+	// nil when the repository is used without a Transactor
+	Units *transaction.Lock
+}
+
+func (d *OutboxRepository) Add(ctx context.Context, event outbox.Event) error {
+	d.logger.Debug("add outbox event")
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastID++
+	event.ID = strconv.FormatInt(d.lastID, 10)
+	event.PublishedAt = nil
+	d.events = append(d.events, event)
+	return nil
+}
+func (d *OutboxRepository) FetchPending(ctx context.Context, limit int) ([]outbox.Event, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var pending []outbox.Event
+	// events are appended in the order they occurred
+	for _, event := range d.events {
+		if len(pending) == limit {
+			break
+		}
+		if event.PublishedAt == nil {
+			pending = append(pending, event)
+		}
+	}
+	return pending, nil
+}
+func (d *OutboxRepository) MarkPublished(ctx context.Context, id string, publishedAt time.Time) error {
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.events {
+		if d.events[i].ID == id {
+			d.events[i].PublishedAt = &publishedAt
+			return nil
+		}
+	}
+	return apperrors.ErrNotFound
+}
+func (d *OutboxRepository) FindByAggregateID(ctx context.Context, aggregateID string) ([]outbox.Event, error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	events := []outbox.Event{}
+	for _, event := range d.events {
+		if event.TenantID == tenant && event.AggregateID == aggregateID {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+func (d *OutboxRepository) Anonymize(ctx context.Context, aggregateID string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, event := range d.events {
+		if event.TenantID == tenant && event.AggregateID == aggregateID {
+			d.events[i].Payload = outbox.AnonymousPayload(aggregateID)
+		}
+	}
+	return nil
+}
+func (d *OutboxRepository) Stream(ctx context.Context, fn func(outbox.Event) error) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	// copy first so fn never runs with the lock held
+	d.mu.RLock()
+	var events []outbox.Event
+	for _, event := range d.events {
+		if event.TenantID == tenant {
+			events = append(events, event)
+		}
+	}
+	d.mu.RUnlock()
+
+	for _, event := range events {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (d *OutboxRepository) ReplacePayload(ctx context.Context, id string, old, payload json.RawMessage) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, event := range d.events {
+		if event.ID == id && event.TenantID == tenant {
+			if !bytes.Equal(event.Payload, old) {
+				return apperrors.ErrPreconditionFailed
+			}
+			d.events[i].Payload = append(json.RawMessage(nil), payload...)
+			return nil
+		}
+	}
+	return apperrors.ErrPreconditionFailed
+}
+func (d *OutboxRepository) Snapshot() func() {
+	d.mu.RLock()
+	events := append([]outbox.Event(nil), d.events...)
+	d.mu.RUnlock()
+	return func() {
+		d.mu.Lock()
+		d.events = events
+		d.mu.Unlock()
+	}
+}
+func NewOutboxRepository(logger *logging.Logger) *OutboxRepository {
+	return &OutboxRepository{logger: logger}
+}
@@ -0,0 +1,15 @@
+package outbox_test
+
+import (
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/memory/outbox"
+	"rest-api-go/internal/storage/storagetest"
+	"rest-api-go/pkg/logging"
+	"testing"
+)
+
+func TestOutboxRepository(t *testing.T) {
+	storagetest.RunOutboxRepositoryTests(t, func(t *testing.T) storage.OutboxRepository {
+		return outbox.NewOutboxRepository(logging.GetLogger())
+	})
+}
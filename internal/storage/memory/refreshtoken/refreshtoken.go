@@ -0,0 +1,172 @@
+package refreshtoken
+
+import (
+	"context"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/memory/transaction"
+	"rest-api-go/pkg/logging"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RefreshTokenRepository keeps refresh tokens in process memory, keyed by
+// hash across tenants.
+type RefreshTokenRepository struct {
+	mu     sync.RWMutex
+	tokens map[string]auth.RefreshToken
+	logger *logging.Logger
+	// Units makes writes outside a unit of work wait for the running one,
+	// nil when the repository is used without a Transactor
+	Units *transaction.Lock
+}
+
+func (d *RefreshTokenRepository) Create(ctx context.Context, token auth.RefreshToken) error {
+	d.logger.Debug("create refresh token")
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	token.TenantID = tenant
+	token.RotatedAt, token.RevokedAt = nil, nil
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.tokens[token.Hash]; ok {
+		return apperrors.ConflictError("hash")
+	}
+	d.tokens[token.Hash] = token
+	return nil
+}
+func (d *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (auth.RefreshToken, error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return auth.RefreshToken{}, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	token, ok := d.tokens[hash]
+	if !ok || token.TenantID != tenant {
+		return auth.RefreshToken{}, apperrors.ErrNotFound
+	}
+	return token, nil
+}
+func (d *RefreshTokenRepository) Rotate(ctx context.Context, hash string, rotatedAt time.Time) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	token, ok := d.tokens[hash]
+	if !ok || token.TenantID != tenant {
+		return apperrors.ErrNotFound
+	}
+	if token.RotatedAt != nil || token.RevokedAt != nil {
+		return apperrors.ErrPreconditionFailed
+	}
+	token.RotatedAt = &rotatedAt
+	d.tokens[hash] = token
+	return nil
+}
+func (d *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	return d.revoke(ctx, revokedAt, func(token auth.RefreshToken) bool { return token.FamilyID == familyID })
+}
+func (d *RefreshTokenRepository) RevokeUser(ctx context.Context, userID string, revokedAt time.Time) error {
+	return d.revoke(ctx, revokedAt, func(token auth.RefreshToken) bool { return token.UserID == userID })
+}
+
+// revoke revokes the tokens of the tenant of ctx that match and are not
+// revoked yet
+func (d *RefreshTokenRepository) revoke(ctx context.Context, revokedAt time.Time, match func(auth.RefreshToken) bool) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for hash, token := range d.tokens {
+		if token.TenantID == tenant && token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &revokedAt
+			d.tokens[hash] = token
+		}
+	}
+	return nil
+}
+func (d *RefreshTokenRepository) FindByUserID(ctx context.Context, userID string) ([]auth.RefreshToken, error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var tokens []auth.RefreshToken
+	for _, token := range d.tokens {
+		if token.TenantID == tenant && token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].IssuedAt.Equal(tokens[j].IssuedAt) {
+			return tokens[i].IssuedAt.Before(tokens[j].IssuedAt)
+		}
+		return tokens[i].Hash < tokens[j].Hash
+	})
+	return tokens, nil
+}
+func (d *RefreshTokenRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for hash, token := range d.tokens {
+		if token.TenantID == tenant && token.UserID == userID {
+			delete(d.tokens, hash)
+		}
+	}
+	return nil
+}
+func (d *RefreshTokenRepository) Purge(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var purged int64
+	for hash, token := range d.tokens {
+		if token.ExpiresAt.Before(expiredBefore) {
+			delete(d.tokens, hash)
+			purged++
+		}
+	}
+	return purged, nil
+}
+func (d *RefreshTokenRepository) Snapshot() func() {
+	d.mu.RLock()
+	tokens := make(map[string]auth.RefreshToken, len(d.tokens))
+	for hash, token := range d.tokens {
+		tokens[hash] = token
+	}
+	d.mu.RUnlock()
+	return func() {
+		d.mu.Lock()
+		d.tokens = tokens
+		d.mu.Unlock()
+	}
+}
+func NewRefreshTokenRepository(logger *logging.Logger) *RefreshTokenRepository {
+	return &RefreshTokenRepository{
+		tokens: make(map[string]auth.RefreshToken),
+		logger: logger,
+	}
+}
@@ -0,0 +1,15 @@
+package refreshtoken_test
+
+import (
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/memory/refreshtoken"
+	"rest-api-go/internal/storage/storagetest"
+	"rest-api-go/pkg/logging"
+	"testing"
+)
+
+func TestRefreshTokenRepository(t *testing.T) {
+	storagetest.RunRefreshTokenRepositoryTests(t, func(t *testing.T) storage.RefreshTokenRepository {
+		return refreshtoken.NewRefreshTokenRepository(logging.GetLogger())
+	})
+}
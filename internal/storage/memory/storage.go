@@ -0,0 +1,32 @@
+package memory
+
+import (
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/memory/history"
+	"rest-api-go/internal/storage/memory/outbox"
+	"rest-api-go/internal/storage/memory/refreshtoken"
+	"rest-api-go/internal/storage/memory/transaction"
+	"rest-api-go/internal/storage/memory/user"
+	"rest-api-go/pkg/logging"
+)
+
+// NewRepository implementation for in-memory storage of all repositories.
+func NewRepository(logger *logging.Logger) *storage.Repository {
+	units := &transaction.Lock{}
+	users := user.NewUserRepository(logger)
+	users.Units = units
+	records := history.NewHistoryRepository(logger)
+	records.Units = units
+	events := outbox.NewOutboxRepository(logger)
+	events.Units = units
+	refreshTokens := refreshtoken.NewRefreshTokenRepository(logger)
+	refreshTokens.Units = units
+	return &storage.Repository{
+		User:         users,
+		History:      records,
+		Outbox:       events,
+		RefreshToken: refreshTokens,
+		Transactor:   NewTransactor(units, users, records, events, refreshTokens),
+		//add other repositories here
+	}
+}
@@ -0,0 +1,41 @@
+package transaction
+
+import (
+	"context"
+	"sync"
+)
+
+type unitKey struct{}
+
+// Lock is held by the running unit of work. Repositories sharing it make
+// their writes outside a unit wait for the running one, so its rollback
+// cannot undo them.
+type Lock struct {
+	mu sync.Mutex
+}
+
+// Within reports whether ctx belongs to a unit of work
+func Within(ctx context.Context) bool {
+	return ctx.Value(unitKey{}) != nil
+}
+
+// Run runs fn as a unit of work while holding the lock, nested calls run fn
+// within the unit of ctx
+func (l *Lock) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	if Within(ctx) {
+		return fn(ctx)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return fn(context.WithValue(ctx, unitKey{}, true))
+}
+
+// Hold waits for the running unit of work unless ctx belongs to it and
+// returns the function that lets the next one start. A nil Lock never waits.
+func (l *Lock) Hold(ctx context.Context) (release func()) {
+	if l == nil || Within(ctx) {
+		return func() {}
+	}
+	l.mu.Lock()
+	return l.mu.Unlock
+}
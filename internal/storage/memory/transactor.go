@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"context"
+	"rest-api-go/internal/storage/memory/transaction"
+)
+
+// Snapshotter is a repository whose state can be saved and put back
+type Snapshotter interface {
+	// Snapshot copies the current state and returns a function restoring it
+	Snapshot() (restore func())
+}
+
+// Transactor runs units of work one at a time and rolls every participating
+// repository back to its snapshot when a unit fails. The participants hold
+// the same lock for their writes outside a unit of work, so those wait for
+// the running unit instead of being lost when it rolls back.
+type Transactor struct {
+	units        *transaction.Lock
+	participants []Snapshotter
+}
+
+func (t *Transactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if transaction.Within(ctx) {
+		return fn(ctx)
+	}
+
+	return t.units.Run(ctx, func(ctx context.Context) error {
+		restores := make([]func(), 0, len(t.participants))
+		for _, participant := range t.participants {
+			restores = append(restores, participant.Snapshot())
+		}
+		if err := fn(ctx); err != nil {
+			for _, restore := range restores {
+				restore()
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (t *Transactor) Atomic() bool {
+	return true
+}
+
+// NewTransactor returns a transactor for participants that hold units for
+// their writes
+func NewTransactor(units *transaction.Lock, participants ...Snapshotter) *Transactor {
+	return &Transactor{units: units, participants: participants}
+}
@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"rest-api-go/internal/entities/outbox"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/pkg/logging"
+	"testing"
+	"time"
+)
+
+// TestRollbackKeepsWritesOutsideTheUnit marks an event published, as the
+// relay does, while a unit of work that rolls back is running
+func TestRollbackKeepsWritesOutsideTheUnit(t *testing.T) {
+	repositories := NewRepository(logging.GetLogger())
+	ctx := requestctx.WithTenant(context.Background(), "acme")
+	if err := repositories.Outbox.Add(ctx, outbox.Event{TenantID: "acme", AggregateID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := repositories.Outbox.FetchPending(ctx, 1)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("FetchPending: got %+v, %v, want one event", pending, err)
+	}
+
+	marked := make(chan error, 1)
+	rollback := errors.New("rollback")
+	err = repositories.Transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		go func() {
+			marked <- repositories.Outbox.MarkPublished(context.Background(), pending[0].ID, time.Now())
+		}()
+		select {
+		case err := <-marked:
+			t.Errorf("MarkPublished did not wait for the unit of work, returned %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("WithinTransaction: got error %v, want %v", err, rollback)
+	}
+	if err := <-marked; err != nil {
+		t.Fatalf("MarkPublished: unexpected error: %v", err)
+	}
+	if pending, err := repositories.Outbox.FetchPending(ctx, 1); err != nil || len(pending) != 0 {
+		t.Fatalf("FetchPending after the rollback: got %+v, %v, want the event published", pending, err)
+	}
+}
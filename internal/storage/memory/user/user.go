@@ -0,0 +1,298 @@
+package user
+
+import (
+	"context"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/memory/transaction"
+	"rest-api-go/pkg/logging"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UserRepository keeps users in process memory, keyed by id across tenants.
+type UserRepository struct {
+	mu     sync.RWMutex
+	users  map[string]user.User
+	logger *logging.Logger
+	// Units makes writes outside a unit of work wait for the running one,
+	// nil when the repository is used without a Transactor
+	Units *transaction.Lock
+}
+
+func (d *UserRepository) Create(ctx context.Context, user user.User) (string, error) {
+	d.logger.Debug("create user")
+	if user.ID == "" {
+		return "", storage.ErrMissingID
+	}
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	user.TenantID = tenant
+	user.Version = 1
+
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.users[user.ID]; ok {
+		return "", apperrors.ConflictError("id")
+	}
+	if err := d.checkUnique(user); err != nil {
+		return "", err
+	}
+	d.users[user.ID] = user
+	return user.ID, nil
+}
+func (d *UserRepository) CreateMany(ctx context.Context, users []user.User) ([]user.BatchItem, error) {
+	d.logger.Debugf("create %d users", len(users))
+	if _, err := storage.Tenant(ctx); err != nil {
+		return nil, err
+	}
+	items := make([]user.BatchItem, len(users))
+	for i, u := range users {
+		items[i].ID, items[i].Error = d.Create(ctx, u)
+	}
+	return items, nil
+}
+func (d *UserRepository) FindOne(ctx context.Context, id string) (u user.User, err error) {
+	return d.find(ctx, id, false)
+}
+func (d *UserRepository) FindOneWithDeleted(ctx context.Context, id string) (u user.User, err error) {
+	return d.find(ctx, id, true)
+}
+func (d *UserRepository) find(ctx context.Context, id string, withDeleted bool) (u user.User, err error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return u, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	u, ok := d.users[id]
+	if !ok || u.TenantID != tenant || (u.DeletedAt != nil && !withDeleted) {
+		return user.User{}, apperrors.ErrNotFound
+	}
+	return u, nil
+}
+func (d *UserRepository) FindAll(ctx context.Context, query user.ListQuery) (page user.Page, err error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return page, err
+	}
+	var after *user.User
+	if query.Cursor != "" {
+		position, err := storage.DecodeCursor(query.Cursor, query.Sort)
+		if err != nil {
+			return page, err
+		}
+		after = &position
+	}
+
+	d.mu.RLock()
+	u := make([]user.User, 0, len(d.users))
+	for _, usr := range d.users {
+		if usr.TenantID == tenant && usr.DeletedAt == nil && matches(usr, query) && (after == nil || storage.CompareUsers(usr, *after, query.Sort) > 0) {
+			u = append(u, usr)
+		}
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(u, func(i, j int) bool { return storage.CompareUsers(u[i], u[j], query.Sort) < 0 })
+	if len(u) > query.Limit+1 {
+		u = u[:query.Limit+1]
+	}
+	return storage.NewPage(u, query), nil
+}
+
+func (d *UserRepository) Stream(ctx context.Context, fn func(user.User) error) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	// copy first so fn never runs with the lock held
+	d.mu.RLock()
+	u := make([]user.User, 0, len(d.users))
+	for _, usr := range d.users {
+		if usr.TenantID == tenant && usr.DeletedAt == nil {
+			u = append(u, usr)
+		}
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(u, func(i, j int) bool { return u[i].ID < u[j].ID })
+	for _, usr := range u {
+		if err := fn(usr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches applies the filters of the query
+func matches(u user.User, query user.ListQuery) bool {
+	if query.Email != "" && u.Email != query.Email {
+		return false
+	}
+	if query.Username != "" && u.Username != query.Username {
+		return false
+	}
+	if query.UsernamePrefix != "" && !strings.HasPrefix(u.Username, query.UsernamePrefix) {
+		return false
+	}
+	if !query.CreatedAfter.IsZero() && !u.CreatedAt.After(query.CreatedAfter) {
+		return false
+	}
+	return true
+}
+func (d *UserRepository) Update(ctx context.Context, user user.User) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stored, ok := d.users[user.ID]
+	if !ok || stored.TenantID != tenant || stored.DeletedAt != nil {
+		return apperrors.ErrNotFound
+	}
+	if user.Version != 0 && user.Version != stored.Version {
+		return apperrors.ErrPreconditionFailed
+	}
+
+	// Only overwrite non-empty fields, the same as $set in mongodb
+	if user.Email != "" {
+		stored.Email = user.Email
+	}
+	if user.Username != "" {
+		stored.Username = user.Username
+	}
+	if user.PasswordHash != "" {
+		stored.PasswordHash = user.PasswordHash
+	}
+	if !user.UpdatedAt.IsZero() {
+		stored.UpdatedAt = user.UpdatedAt
+	}
+	if user.EncryptedFields != "" {
+		stored.EncryptedFields = user.EncryptedFields
+	}
+	if err := d.checkUnique(stored); err != nil {
+		return err
+	}
+	stored.Version++
+	d.users[user.ID] = stored
+	return nil
+}
+func (d *UserRepository) Delete(ctx context.Context, id string, version int64, deletedAt time.Time) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stored, ok := d.users[id]
+	if !ok || stored.TenantID != tenant || stored.DeletedAt != nil {
+		return apperrors.ErrNotFound
+	}
+	if version != 0 && version != stored.Version {
+		return apperrors.ErrPreconditionFailed
+	}
+	stored.DeletedAt = &deletedAt
+	stored.UpdatedAt = deletedAt
+	stored.Version++
+	d.users[id] = stored
+	return nil
+}
+func (d *UserRepository) Restore(ctx context.Context, id string, restoredAt time.Time) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stored, ok := d.users[id]
+	if !ok || stored.TenantID != tenant || stored.DeletedAt == nil {
+		return apperrors.ErrNotFound
+	}
+	stored.DeletedAt = nil
+	stored.UpdatedAt = restoredAt
+	stored.Version++
+	d.users[id] = stored
+	return nil
+}
+func (d *UserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var purged int64
+	for id, stored := range d.users {
+		if stored.DeletedAt != nil && stored.DeletedAt.Before(deletedBefore) {
+			delete(d.users, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+func (d *UserRepository) Erase(ctx context.Context, id string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	release := d.Units.Hold(ctx)
+	defer release()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stored, ok := d.users[id]
+	if !ok || stored.TenantID != tenant {
+		return apperrors.ErrNotFound
+	}
+	delete(d.users, id)
+	return nil
+}
+func (d *UserRepository) Snapshot() func() {
+	d.mu.RLock()
+	users := make(map[string]user.User, len(d.users))
+	for id, u := range d.users {
+		users[id] = u
+	}
+	d.mu.RUnlock()
+	return func() {
+		d.mu.Lock()
+		d.users = users
+		d.mu.Unlock()
+	}
+}
+
+// checkUnique emulates the unique email and username indexes of a tenant.
+// Deleted users keep their values reserved so they can always be restored.
+// The caller must hold the write lock.
+func (d *UserRepository) checkUnique(u user.User) error {
+	for id, other := range d.users {
+		if id == u.ID || other.TenantID != u.TenantID {
+			continue
+		}
+		if other.Email == u.Email {
+			return apperrors.ConflictError("email")
+		}
+		if other.Username == u.Username {
+			return apperrors.ConflictError("username")
+		}
+	}
+	return nil
+}
+func NewUserRepository(logger *logging.Logger) *UserRepository {
+	return &UserRepository{
+		users:  make(map[string]user.User),
+		logger: logger,
+	}
+}
@@ -0,0 +1,15 @@
+package user_test
+
+import (
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/memory/user"
+	"rest-api-go/internal/storage/storagetest"
+	"rest-api-go/pkg/logging"
+	"testing"
+)
+
+func TestUserRepository(t *testing.T) {
+	storagetest.RunUserRepositoryTests(t, func(t *testing.T) storage.UserRepository {
+		return user.NewUserRepository(logging.GetLogger())
+	})
+}
@@ -0,0 +1,114 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/storage"
+	"rest-api-go/pkg/logging"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type HistoryRepository struct {
+	collection *mongo.Collection
+	logger     *logging.Logger
+}
+
+func (d *HistoryRepository) Create(ctx context.Context, record history.Record) error {
+	d.logger.Debug("create history record")
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	record.ID = ""
+	record.TenantID = tenant
+	if _, err := d.collection.InsertOne(ctx, record); err != nil {
+		return fmt.Errorf("error creating history record: %w", err)
+	}
+	return nil
+}
+func (d *HistoryRepository) FindByUserID(ctx context.Context, userID string) (r []history.Record, err error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return r, err
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}})
+	result, err := d.collection.Find(ctx, bson.M{"tenant_id": tenant, "user_id": userID}, findOptions)
+	if err != nil {
+		return r, fmt.Errorf("error finding history of user %s, due to error:%v", userID, err)
+	}
+	if err := result.All(ctx, &r); err != nil {
+		return r, fmt.Errorf("error decoding history of user %s, due to error:%v", userID, err)
+	}
+	return r, nil
+}
+func (d *HistoryRepository) Anonymize(ctx context.Context, userID string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	// $[] needs the array, records without changes have none
+	filter := bson.M{"tenant_id": tenant, "user_id": userID, "changes.0": bson.M{"$exists": true}}
+	update := bson.M{"$unset": bson.M{"changes.$[].before": "", "changes.$[].after": ""}}
+	if _, err := d.collection.UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("error anonymizing history of user %s: %w", userID, err)
+	}
+	return nil
+}
+func (d *HistoryRepository) Stream(ctx context.Context, fn func(history.Record) error) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	result, err := d.collection.Find(ctx, bson.M{"tenant_id": tenant}, findOptions)
+	if err != nil {
+		return fmt.Errorf("error streaming history, due to error:%v", err)
+	}
+	defer result.Close(ctx)
+
+	for result.Next(ctx) {
+		var record history.Record
+		if err := result.Decode(&record); err != nil {
+			return fmt.Errorf("error decoding history, due to error:%v", err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("error streaming history, due to error:%v", err)
+	}
+	return nil
+}
+func (d *HistoryRepository) ReplaceChanges(ctx context.Context, id string, old, changes []history.Change) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return apperrors.ErrPreconditionFailed
+	}
+	// the changes are encoded the same way as when the record was created
+	filter := bson.M{"_id": oid, "tenant_id": tenant, "changes": old}
+	result, err := d.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"changes": changes}})
+	if err != nil {
+		return fmt.Errorf("error replacing changes of history record %s: %w", id, err)
+	}
+	if result.MatchedCount == 0 {
+		return apperrors.ErrPreconditionFailed
+	}
+	return nil
+}
+func NewHistoryRepository(database *mongo.Database, collection string, logger *logging.Logger) *HistoryRepository {
+	return &HistoryRepository{
+		collection: database.Collection(collection),
+		logger:     logger,
+	}
+}
@@ -0,0 +1,261 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"rest-api-go/pkg/logging"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	migrationsCollection     = "schema_migrations"
+	migrationsLockCollection = "schema_migrations_lock"
+	migrationsLockID         = "migrations"
+	// migrationsLockTTL frees the lock of an instance that died holding it.
+	// The holder renews it every migrationsLockRenewal while migrating.
+	migrationsLockTTL     = 10 * time.Minute
+	migrationsLockRenewal = migrationsLockTTL / 3
+)
+
+// Migration changes the database from the version before it to Version.
+// Up and Down run again if the process stops before the migration is
+// recorded, so they must be idempotent. A nil Down cannot be reverted.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, database *mongo.Database, collections Collections) error
+	Down    func(ctx context.Context, database *mongo.Database, collections Collections) error
+}
+
+// MigrationStatus tells whether a migration is applied and when
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+type appliedMigration struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Migrator applies and reverts migrations, recording the applied ones in
+// schema_migrations. A lock document keeps instances from running at once.
+type Migrator struct {
+	database    *mongo.Database
+	collections Collections
+	migrations  []Migration
+	logger      *logging.Logger
+}
+
+// Up applies every migration that is not applied yet, in version order
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := migration.Up(ctx, m.database, m.collections); err != nil {
+				return fmt.Errorf("error applying migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			record := appliedMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now().UTC()}
+			if _, err := m.database.Collection(migrationsCollection).InsertOne(ctx, record); err != nil {
+				return fmt.Errorf("error recording migration %d: %w", migration.Version, err)
+			}
+			m.logger.Infof("applied migration %04d_%s", migration.Version, migration.Name)
+		}
+		return nil
+	})
+}
+
+// Down reverts the last steps applied migrations, newest first
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %04d_%s cannot be reverted", migration.Version, migration.Name)
+			}
+			if err := migration.Down(ctx, m.database, m.collections); err != nil {
+				return fmt.Errorf("error reverting migration %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			_, err := m.database.Collection(migrationsCollection).DeleteOne(ctx, bson.M{"_id": migration.Version})
+			if err != nil {
+				return fmt.Errorf("error unrecording migration %d: %w", migration.Version, err)
+			}
+			m.logger.Infof("reverted migration %04d_%s", migration.Version, migration.Name)
+			steps--
+		}
+		return nil
+	})
+}
+
+// Status lists every known migration in version order
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			appliedAt := record.AppliedAt
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	result, err := m.database.Collection(migrationsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("error reading applied migrations: %w", err)
+	}
+	var records []appliedMigration
+	if err := result.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("error decoding applied migrations: %w", err)
+	}
+	applied := make(map[int]appliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// withLock runs fn while holding the migrations lock, waiting for it if
+// another instance holds it
+func (m *Migrator) withLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	locks := m.database.Collection(migrationsLockCollection)
+	owner := primitive.NewObjectID().Hex()
+	if hostname, err := os.Hostname(); err == nil {
+		owner = hostname + "/" + owner
+	}
+
+	for {
+		// takes a free or expired lock; a held one makes the upsert collide with it
+		now := time.Now().UTC()
+		_, err := locks.UpdateOne(ctx,
+			bson.M{"_id": migrationsLockID, "expires_at": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(migrationsLockTTL)}},
+			options.Update().SetUpsert(true))
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("error locking migrations: %w", err)
+		}
+		m.logger.Info("waiting for another instance to finish migrating")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	defer func() {
+		// release with a fresh context so a cancelled one cannot leak the lock
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := locks.DeleteOne(releaseCtx, bson.M{"_id": migrationsLockID, "owner": owner}); err != nil {
+			m.logger.Errorf("failed to release migrations lock due to error %v", err)
+		}
+	}()
+
+	// a migration outliving the TTL would let another instance take the lock,
+	// so it is renewed while fn runs and fn is cancelled once it is lost
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lost := make(chan error, 1)
+	go func() {
+		lost <- m.renewLock(runCtx, locks, owner, cancel)
+	}()
+	err := fn(runCtx)
+	cancel()
+	if lostErr := <-lost; lostErr != nil {
+		return lostErr
+	}
+	return err
+}
+
+// renewLock pushes the expiry of the lock held by owner forward until ctx is
+// done. It cancels the migration through abort and returns an error when
+// another instance took the lock or the lock expired before a renewal
+// succeeded.
+func (m *Migrator) renewLock(ctx context.Context, locks *mongo.Collection, owner string, abort context.CancelFunc) error {
+	expiresAt := time.Now().Add(migrationsLockTTL)
+	ticker := time.NewTicker(migrationsLockRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		result, err := locks.UpdateOne(ctx,
+			bson.M{"_id": migrationsLockID, "owner": owner},
+			bson.M{"$set": bson.M{"expires_at": now.UTC().Add(migrationsLockTTL)}})
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil && now.Before(expiresAt):
+			m.logger.Warnf("failed to renew migrations lock due to error %v", err)
+		case err != nil:
+			abort()
+			return fmt.Errorf("error renewing migrations lock, it expired while migrating: %w", err)
+		case result.MatchedCount == 0:
+			abort()
+			return errors.New("migrations lock was taken by another instance while migrating")
+		default:
+			expiresAt = now.Add(migrationsLockTTL)
+		}
+	}
+}
+
+// NewMigrator returns a migrator for the migrations of this package.
+// Documents stored before tenancy are moved to defaultTenant.
+func NewMigrator(database *mongo.Database, collections Collections, defaultTenant string, logger *logging.Logger) (*Migrator, error) {
+	sorted := migrations(defaultTenant)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := range sorted {
+		if sorted[i].Up == nil {
+			return nil, fmt.Errorf("migration %d has no Up", sorted[i].Version)
+		}
+		if i > 0 && sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", sorted[i].Version)
+		}
+	}
+	return &Migrator{
+		database:    database,
+		collections: collections,
+		migrations:  sorted,
+		logger:      logger,
+	}, nil
+}
+
+// isNotFound matches dropping an index or collection that is gone already
+func isNotFound(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && (serverErr.HasErrorCode(26) || serverErr.HasErrorCode(27))
+}
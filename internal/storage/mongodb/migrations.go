@@ -0,0 +1,216 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrations must never change once released, add a new one instead.
+// The index definitions are copied into each migration rather than shared
+// with the repositories so a later change cannot rewrite history.
+// defaultTenant is the configured tenancy.default.
+func migrations(defaultTenant string) []Migration {
+	return []Migration{
+		{Version: 1, Name: "create_indexes", Up: createIndexes, Down: dropIndexes},
+		{Version: 2, Name: "backfill_user_timestamps", Up: backfillUserTimestamps, Down: keepUserTimestamps},
+		{Version: 3, Name: "scope_by_tenant", Up: scopeByTenant(defaultTenant), Down: unscopeByTenant},
+		{Version: 4, Name: "index_outbox_aggregate", Up: indexOutboxAggregate, Down: dropOutboxAggregateIndex},
+		{Version: 5, Name: "index_refresh_tokens", Up: indexRefreshTokens, Down: dropRefreshTokenIndexes},
+	}
+}
+
+// createIndexes creates the unique indexes on email and username, the index
+// used to filter and sort users by creation time, the one the purger uses to
+// find deleted users, the one used to look up the history of a user and the
+// one the relay uses to find pending events.
+func createIndexes(ctx context.Context, database *mongo.Database, collections Collections) error {
+	users := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+	}
+	if _, err := database.Collection(collections.Users).Indexes().CreateMany(ctx, users); err != nil {
+		return fmt.Errorf("error creating user indexes: %w", err)
+	}
+	history := mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "timestamp", Value: 1}}}
+	if _, err := database.Collection(collections.History).Indexes().CreateOne(ctx, history); err != nil {
+		return fmt.Errorf("error creating history indexes: %w", err)
+	}
+	outbox := mongo.IndexModel{Keys: bson.D{{Key: "published_at", Value: 1}, {Key: "_id", Value: 1}}}
+	if _, err := database.Collection(collections.Outbox).Indexes().CreateOne(ctx, outbox); err != nil {
+		return fmt.Errorf("error creating outbox indexes: %w", err)
+	}
+	return nil
+}
+func dropIndexes(ctx context.Context, database *mongo.Database, collections Collections) error {
+	return dropIndexesByName(ctx, database, map[string][]string{
+		collections.Users:   {"email_1", "username_1", "created_at_1", "deleted_at_1"},
+		collections.History: {"user_id_1_timestamp_1"},
+		collections.Outbox:  {"published_at_1__id_1"},
+	})
+}
+
+// dropIndexesByName drops the named indexes of each collection, skipping
+// the ones that are gone already
+func dropIndexesByName(ctx context.Context, database *mongo.Database, indexes map[string][]string) error {
+	for collection, names := range indexes {
+		for _, name := range names {
+			_, err := database.Collection(collection).Indexes().DropOne(ctx, name)
+			if err != nil && !isNotFound(err) {
+				return fmt.Errorf("error dropping index %s of %s: %w", name, collection, err)
+			}
+		}
+	}
+	return nil
+}
+
+// backfillUserTimestamps fills in the fields users created by earlier
+// versions of the service may lack. Users with an ObjectID take their
+// creation time from it, the others are stamped with the current time.
+func backfillUserTimestamps(ctx context.Context, database *mongo.Database, collections Collections) error {
+	users := database.Collection(collections.Users)
+	missing := func(field string) bson.M { return bson.M{field: bson.M{"$exists": false}} }
+	updates := []struct {
+		filter bson.M
+		update interface{}
+	}{
+		{
+			filter: bson.M{"created_at": bson.M{"$exists": false}, "_id": bson.M{"$type": "objectId"}},
+			update: mongo.Pipeline{{{Key: "$set", Value: bson.M{"created_at": bson.M{"$toDate": "$_id"}}}}},
+		},
+		{
+			filter: missing("created_at"),
+			update: bson.M{"$set": bson.M{"created_at": time.Now().UTC()}},
+		},
+		{
+			filter: missing("updated_at"),
+			update: mongo.Pipeline{{{Key: "$set", Value: bson.M{"updated_at": "$created_at"}}}},
+		},
+		{
+			filter: missing("version"),
+			update: bson.M{"$set": bson.M{"version": 1}},
+		},
+	}
+	for _, u := range updates {
+		if _, err := users.UpdateMany(ctx, u.filter, u.update); err != nil {
+			return fmt.Errorf("error backfilling users: %w", err)
+		}
+	}
+	return nil
+}
+
+// keepUserTimestamps reverts backfillUserTimestamps by doing nothing, the
+// filled in values are valid for the older schema as well
+func keepUserTimestamps(ctx context.Context, database *mongo.Database, collections Collections) error {
+	return nil
+}
+
+// scopeByTenant moves the documents stored before tenancy to the default
+// tenant and makes emails and usernames unique per tenant. Without a default
+// tenant it fails if there is anything to move, since no request could
+// reach those documents.
+func scopeByTenant(defaultTenant string) func(ctx context.Context, database *mongo.Database, collections Collections) error {
+	return func(ctx context.Context, database *mongo.Database, collections Collections) error {
+		unscoped := bson.M{"tenant_id": bson.M{"$exists": false}}
+		for _, collection := range []string{collections.Users, collections.History, collections.Outbox} {
+			if defaultTenant == "" {
+				count, err := database.Collection(collection).CountDocuments(ctx, unscoped)
+				if err != nil {
+					return fmt.Errorf("error counting documents of %s without tenant: %w", collection, err)
+				}
+				if count > 0 {
+					return fmt.Errorf("%d documents of %s have no tenant, configure tenancy.default to move them to", count, collection)
+				}
+				continue
+			}
+			_, err := database.Collection(collection).UpdateMany(ctx, unscoped,
+				bson.M{"$set": bson.M{"tenant_id": defaultTenant}})
+			if err != nil {
+				return fmt.Errorf("error backfilling tenant of %s: %w", collection, err)
+			}
+		}
+		return indexByTenant(ctx, database, collections)
+	}
+}
+
+// indexByTenant replaces the indexes unique across tenants with ones unique
+// per tenant
+func indexByTenant(ctx context.Context, database *mongo.Database, collections Collections) error {
+	users := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: 1}}},
+	}
+	if _, err := database.Collection(collections.Users).Indexes().CreateMany(ctx, users); err != nil {
+		return fmt.Errorf("error creating user indexes: %w", err)
+	}
+	history := mongo.IndexModel{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "timestamp", Value: 1}}}
+	if _, err := database.Collection(collections.History).Indexes().CreateOne(ctx, history); err != nil {
+		return fmt.Errorf("error creating history indexes: %w", err)
+	}
+	return dropIndexesByName(ctx, database, map[string][]string{
+		collections.Users:   {"email_1", "username_1", "created_at_1"},
+		collections.History: {"user_id_1_timestamp_1"},
+	})
+}
+
+// unscopeByTenant restores the indexes unique across tenants, which fails if
+// two tenants share an email or username. tenant_id is left in place.
+func unscopeByTenant(ctx context.Context, database *mongo.Database, collections Collections) error {
+	users := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+	}
+	if _, err := database.Collection(collections.Users).Indexes().CreateMany(ctx, users); err != nil {
+		return fmt.Errorf("error creating user indexes: %w", err)
+	}
+	history := mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "timestamp", Value: 1}}}
+	if _, err := database.Collection(collections.History).Indexes().CreateOne(ctx, history); err != nil {
+		return fmt.Errorf("error creating history indexes: %w", err)
+	}
+	return dropIndexesByName(ctx, database, map[string][]string{
+		collections.Users:   {"tenant_id_1_email_1", "tenant_id_1_username_1", "tenant_id_1_created_at_1"},
+		collections.History: {"tenant_id_1_user_id_1_timestamp_1"},
+	})
+}
+
+// indexOutboxAggregate creates the index used to find the events of a user
+// for data exports and erasure
+func indexOutboxAggregate(ctx context.Context, database *mongo.Database, collections Collections) error {
+	model := mongo.IndexModel{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "aggregate_id", Value: 1}, {Key: "_id", Value: 1}}}
+	if _, err := database.Collection(collections.Outbox).Indexes().CreateOne(ctx, model); err != nil {
+		return fmt.Errorf("error creating outbox indexes: %w", err)
+	}
+	return nil
+}
+func dropOutboxAggregateIndex(ctx context.Context, database *mongo.Database, collections Collections) error {
+	return dropIndexesByName(ctx, database, map[string][]string{
+		collections.Outbox: {"tenant_id_1_aggregate_id_1__id_1"},
+	})
+}
+
+// indexRefreshTokens creates the indexes used to revoke the tokens of a
+// family or of a user and the one used to purge expired tokens
+func indexRefreshTokens(ctx context.Context, database *mongo.Database, collections Collections) error {
+	models := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "family_id", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+	}
+	if _, err := database.Collection(collections.RefreshTokens).Indexes().CreateMany(ctx, models); err != nil {
+		return fmt.Errorf("error creating refresh token indexes: %w", err)
+	}
+	return nil
+}
+func dropRefreshTokenIndexes(ctx context.Context, database *mongo.Database, collections Collections) error {
+	return dropIndexesByName(ctx, database, map[string][]string{
+		collections.RefreshTokens: {"tenant_id_1_family_id_1", "tenant_id_1_user_id_1", "expires_at_1"},
+	})
+}
@@ -0,0 +1,141 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/outbox"
+	"rest-api-go/internal/storage"
+	"rest-api-go/pkg/logging"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OutboxRepository struct {
+	collection *mongo.Collection
+	logger     *logging.Logger
+}
+
+func (d *OutboxRepository) Add(ctx context.Context, event outbox.Event) error {
+	d.logger.Debug("add outbox event")
+	event.ID = ""
+	event.PublishedAt = nil
+	if _, err := d.collection.InsertOne(ctx, event); err != nil {
+		return fmt.Errorf("error adding outbox event: %w", err)
+	}
+	return nil
+}
+func (d *OutboxRepository) FetchPending(ctx context.Context, limit int) (e []outbox.Event, err error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "published_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+	result, err := d.collection.Find(ctx, bson.M{"published_at": nil}, findOptions)
+	if err != nil {
+		return e, fmt.Errorf("error fetching outbox events, due to error:%v", err)
+	}
+	// the payload is kept as the JSON bytes of the event, stored as binary
+	if err := result.All(ctx, &e); err != nil {
+		return e, fmt.Errorf("error decoding outbox events, due to error:%v", err)
+	}
+	return e, nil
+}
+func (d *OutboxRepository) MarkPublished(ctx context.Context, id string, publishedAt time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return apperrors.ErrNotFound
+	}
+	result, err := d.collection.UpdateOne(ctx, bson.M{"_id": oid},
+		bson.M{"$set": bson.M{"published_at": publishedAt}})
+	if err != nil {
+		return fmt.Errorf("error marking outbox event %s as published: %v", id, err)
+	}
+	if result.MatchedCount == 0 {
+		return apperrors.ErrNotFound
+	}
+	return nil
+}
+func (d *OutboxRepository) FindByAggregateID(ctx context.Context, aggregateID string) (e []outbox.Event, err error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return e, err
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	result, err := d.collection.Find(ctx, bson.M{"tenant_id": tenant, "aggregate_id": aggregateID}, findOptions)
+	if err != nil {
+		return e, fmt.Errorf("error finding events of %s, due to error:%v", aggregateID, err)
+	}
+	if err := result.All(ctx, &e); err != nil {
+		return e, fmt.Errorf("error decoding events of %s, due to error:%v", aggregateID, err)
+	}
+	return e, nil
+}
+func (d *OutboxRepository) Anonymize(ctx context.Context, aggregateID string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = d.collection.UpdateMany(ctx,
+		bson.M{"tenant_id": tenant, "aggregate_id": aggregateID},
+		bson.M{"$set": bson.M{"payload": []byte(outbox.AnonymousPayload(aggregateID))}})
+	if err != nil {
+		return fmt.Errorf("error anonymizing events of %s: %w", aggregateID, err)
+	}
+	return nil
+}
+func (d *OutboxRepository) Stream(ctx context.Context, fn func(outbox.Event) error) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	result, err := d.collection.Find(ctx, bson.M{"tenant_id": tenant}, findOptions)
+	if err != nil {
+		return fmt.Errorf("error streaming outbox events, due to error:%v", err)
+	}
+	defer result.Close(ctx)
+
+	for result.Next(ctx) {
+		var event outbox.Event
+		if err := result.Decode(&event); err != nil {
+			return fmt.Errorf("error decoding outbox events, due to error:%v", err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("error streaming outbox events, due to error:%v", err)
+	}
+	return nil
+}
+func (d *OutboxRepository) ReplacePayload(ctx context.Context, id string, old, payload json.RawMessage) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return apperrors.ErrPreconditionFailed
+	}
+	result, err := d.collection.UpdateOne(ctx,
+		bson.M{"_id": oid, "tenant_id": tenant, "payload": []byte(old)},
+		bson.M{"$set": bson.M{"payload": []byte(payload)}})
+	if err != nil {
+		return fmt.Errorf("error replacing payload of outbox event %s: %w", id, err)
+	}
+	if result.MatchedCount == 0 {
+		return apperrors.ErrPreconditionFailed
+	}
+	return nil
+}
+func NewOutboxRepository(database *mongo.Database, collection string, logger *logging.Logger) *OutboxRepository {
+	return &OutboxRepository{
+		collection: database.Collection(collection),
+		logger:     logger,
+	}
+}
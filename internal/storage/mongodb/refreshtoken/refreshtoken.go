@@ -0,0 +1,139 @@
+package refreshtoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/storage"
+	"rest-api-go/pkg/logging"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RefreshTokenRepository struct {
+	collection *mongo.Collection
+	logger     *logging.Logger
+}
+
+func (d *RefreshTokenRepository) Create(ctx context.Context, token auth.RefreshToken) error {
+	d.logger.Debug("create refresh token")
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	token.TenantID = tenant
+	token.RotatedAt, token.RevokedAt = nil, nil
+	_, err = d.collection.InsertOne(ctx, token)
+	if mongo.IsDuplicateKeyError(err) {
+		return apperrors.ConflictError("hash")
+	}
+	if err != nil {
+		return fmt.Errorf("error creating refresh token: %w", err)
+	}
+	return nil
+}
+func (d *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (token auth.RefreshToken, err error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return token, err
+	}
+	err = d.collection.FindOne(ctx, bson.M{"_id": hash, "tenant_id": tenant}).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return auth.RefreshToken{}, apperrors.ErrNotFound
+	}
+	if err != nil {
+		return token, fmt.Errorf("error finding refresh token: %w", err)
+	}
+	return token, nil
+}
+func (d *RefreshTokenRepository) Rotate(ctx context.Context, hash string, rotatedAt time.Time) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	// the state is checked and changed in one update, so of two concurrent
+	// rotations only one matches the document
+	result, err := d.collection.UpdateOne(ctx,
+		bson.M{"_id": hash, "tenant_id": tenant, "rotated_at": nil, "revoked_at": nil},
+		bson.M{"$set": bson.M{"rotated_at": rotatedAt}})
+	if err != nil {
+		return fmt.Errorf("error rotating refresh token: %w", err)
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	count, err := d.collection.CountDocuments(ctx, bson.M{"_id": hash, "tenant_id": tenant})
+	if err != nil {
+		return fmt.Errorf("error checking refresh token: %w", err)
+	}
+	if count == 0 {
+		return apperrors.ErrNotFound
+	}
+	return apperrors.ErrPreconditionFailed
+}
+func (d *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	return d.revoke(ctx, "family_id", familyID, revokedAt)
+}
+func (d *RefreshTokenRepository) RevokeUser(ctx context.Context, userID string, revokedAt time.Time) error {
+	return d.revoke(ctx, "user_id", userID, revokedAt)
+}
+
+// revoke revokes the tokens of the tenant of ctx whose field holds value
+func (d *RefreshTokenRepository) revoke(ctx context.Context, field, value string, revokedAt time.Time) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = d.collection.UpdateMany(ctx,
+		bson.M{"tenant_id": tenant, field: value, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": revokedAt}})
+	if err != nil {
+		return fmt.Errorf("error revoking refresh tokens by %s: %w", field, err)
+	}
+	return nil
+}
+func (d *RefreshTokenRepository) FindByUserID(ctx context.Context, userID string) ([]auth.RefreshToken, error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := d.collection.Find(ctx, bson.M{"tenant_id": tenant, "user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "issued_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("error finding refresh tokens of user %s: %w", userID, err)
+	}
+	var tokens []auth.RefreshToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, fmt.Errorf("error decoding refresh tokens of user %s: %w", userID, err)
+	}
+	return tokens, nil
+}
+func (d *RefreshTokenRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := d.collection.DeleteMany(ctx, bson.M{"tenant_id": tenant, "user_id": userID}); err != nil {
+		return fmt.Errorf("error deleting refresh tokens of user %s: %w", userID, err)
+	}
+	return nil
+}
+func (d *RefreshTokenRepository) Purge(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	result, err := d.collection.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lt": expiredBefore}})
+	if err != nil {
+		return 0, fmt.Errorf("error purging refresh tokens: %w", err)
+	}
+	return result.DeletedCount, nil
+}
+func NewRefreshTokenRepository(database *mongo.Database, collection string, logger *logging.Logger) *RefreshTokenRepository {
+	return &RefreshTokenRepository{
+		collection: database.Collection(collection),
+		logger:     logger,
+	}
+}
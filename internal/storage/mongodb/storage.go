@@ -2,16 +2,36 @@ package mongodb
 
 import (
 	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/mongodb/history"
+	"rest-api-go/internal/storage/mongodb/outbox"
+	"rest-api-go/internal/storage/mongodb/refreshtoken"
 	"rest-api-go/internal/storage/mongodb/user"
 	"rest-api-go/pkg/logging"
 
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Collections names the collection of every repository.
+type Collections struct {
+	Users         string
+	History       string
+	Outbox        string
+	RefreshTokens string
+}
+
 // NewRepository implementation for storage of all repositories.
-func NewRepository(database *mongo.Database, collection string, logger *logging.Logger) *storage.Repository {
+// Transactions need a replica set, disable them for a standalone server.
+func NewRepository(database *mongo.Database, collections Collections, transactions bool, logger *logging.Logger) *storage.Repository {
+	transactor := NewTransactor(nil)
+	if transactions {
+		transactor = NewTransactor(database.Client())
+	}
 	return &storage.Repository{
-		User: user.NewUserRepository(database, collection, logger),
+		User:         user.NewUserRepository(database, collections.Users, logger),
+		History:      history.NewHistoryRepository(database, collections.History, logger),
+		Outbox:       outbox.NewOutboxRepository(database, collections.Outbox, logger),
+		RefreshToken: refreshtoken.NewRefreshTokenRepository(database, collections.RefreshTokens, logger),
+		Transactor:   transactor,
 		//add other repositories here
 	}
 }
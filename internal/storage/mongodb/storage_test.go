@@ -0,0 +1,88 @@
+package mongodb_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/mongodb"
+	"rest-api-go/internal/storage/storagetest"
+	"rest-api-go/pkg/logging"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	clientOnce sync.Once
+	client     *mongo.Client
+	clientErr  error
+)
+
+var collections = mongodb.Collections{
+	Users:         "users",
+	History:       "users_history",
+	Outbox:        "users_outbox",
+	RefreshTokens: "users_refresh_tokens",
+}
+
+// newTestRepository returns the repositories of a new migrated database of
+// the server of MONGODB_TEST_URI, which is dropped when the test ends.
+// Tests are skipped without it.
+func newTestRepository(t *testing.T) *storage.Repository {
+	t.Helper()
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+	ctx := context.Background()
+	clientOnce.Do(func() {
+		client, clientErr = mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	})
+	if clientErr != nil {
+		t.Fatalf("failed to connect to %s: %v", uri, clientErr)
+	}
+
+	database := client.Database(fmt.Sprintf("storagetest_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		if err := database.Drop(ctx); err != nil {
+			t.Errorf("failed to drop %s: %v", database.Name(), err)
+		}
+	})
+	migrator, err := mongodb.NewMigrator(database, collections, storagetest.Tenant, logging.GetLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatalf("failed to migrate %s: %v", database.Name(), err)
+	}
+	// transactions need a replica set, which the suite does not
+	return mongodb.NewRepository(database, collections, false, logging.GetLogger())
+}
+
+func TestUserRepository(t *testing.T) {
+	storagetest.RunUserRepositoryTests(t, func(t *testing.T) storage.UserRepository {
+		return newTestRepository(t).User
+	})
+}
+
+func TestHistoryRepository(t *testing.T) {
+	storagetest.RunHistoryRepositoryTests(t, func(t *testing.T) storage.HistoryRepository {
+		return newTestRepository(t).History
+	})
+}
+
+func TestOutboxRepository(t *testing.T) {
+	storagetest.RunOutboxRepositoryTests(t, func(t *testing.T) storage.OutboxRepository {
+		return newTestRepository(t).Outbox
+	})
+}
+
+func TestRefreshTokenRepository(t *testing.T) {
+	storagetest.RunRefreshTokenRepositoryTests(t, func(t *testing.T) storage.RefreshTokenRepository {
+		return newTestRepository(t).RefreshToken
+	})
+}
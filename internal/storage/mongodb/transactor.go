@@ -0,0 +1,59 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transactor runs units of work in multi-document transactions, which need a
+// replica set or sharded cluster. With a nil client every unit of work runs
+// without a transaction and nothing is rolled back, for standalone servers
+// used in development.
+type Transactor struct {
+	client *mongo.Client
+}
+
+func (t *Transactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if t.client == nil || mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := t.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("error starting session: %w", err)
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
+
+// Atomic is false without a client
+func (t *Transactor) Atomic() bool {
+	return t.client != nil
+}
+
+// CheckTransactions returns an error unless the server of database is part
+// of a replica set or a sharded cluster, the deployments with transactions
+func CheckTransactions(ctx context.Context, database *mongo.Database) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := database.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return fmt.Errorf("error checking mongodb topology: %w", err)
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("mongodb transactions need a replica set or a sharded cluster, disable mongodb.transactions for a standalone server")
+	}
+	return nil
+}
+
+func NewTransactor(client *mongo.Client) *Transactor {
+	return &Transactor{client: client}
+}
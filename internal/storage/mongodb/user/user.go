@@ -4,15 +4,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"rest-api-go/internal/apperrors"
 	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/storage"
 	"rest-api-go/pkg/logging"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// uniqueFields are backed by unique indexes per tenant named
+// "tenant_id_1_<field>_1", created by the migrations of the mongodb package
+var uniqueFields = []string{"email", "username"}
+
 type UserRepository struct {
 	collection *mongo.Collection
 	logger     *logging.Logger
@@ -20,24 +29,77 @@ type UserRepository struct {
 
 func (d *UserRepository) Create(ctx context.Context, user user.User) (string, error) {
 	d.logger.Debug("create user")
-	result, err := d.collection.InsertOne(ctx, user)
+	if user.ID == "" {
+		return "", storage.ErrMissingID
+	}
+	tenant, err := storage.Tenant(ctx)
 	if err != nil {
+		return "", err
+	}
+	user.TenantID = tenant
+	user.Version = 1
+	if _, err := d.collection.InsertOne(ctx, user); err != nil {
+		if conflictErr := conflictError(err); conflictErr != nil {
+			return "", conflictErr
+		}
 		return "", fmt.Errorf("error creating user: %w", err)
 	}
-	d.logger.Debug("convert objectId to hex")
-	oid, ok := result.InsertedID.(primitive.ObjectID)
-	if ok {
-		return oid.Hex(), nil
+	return user.ID, nil
+}
+func (d *UserRepository) CreateMany(ctx context.Context, users []user.User) ([]user.BatchItem, error) {
+	d.logger.Debugf("create %d users", len(users))
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]user.BatchItem, len(users))
+	if len(users) == 0 {
+		return items, nil
+	}
+	documents := make([]interface{}, len(users))
+	for i, u := range users {
+		if u.ID == "" {
+			return nil, storage.ErrMissingID
+		}
+		u.TenantID = tenant
+		u.Version = 1
+		documents[i] = u
+	}
+	// unordered, so a failing document does not stop the ones after it
+	_, err = d.collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if err != nil && (!errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil) {
+		return nil, fmt.Errorf("error creating users: %w", err)
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if conflictErr := conflictError(writeErr.WriteError); conflictErr != nil {
+			items[writeErr.Index].Error = conflictErr
+			continue
+		}
+		items[writeErr.Index].Error = fmt.Errorf("error creating user: %w", writeErr.WriteError)
+	}
+	for i := range items {
+		if items[i].Error == nil {
+			items[i].ID = users[i].ID
+		}
 	}
-	d.logger.Trace(user)
-	return "", fmt.Errorf("failed to convert objectId to hex. oid: %s", oid)
+	return items, nil
 }
 func (d *UserRepository) FindOne(ctx context.Context, id string) (u user.User, err error) {
-	oid, err := primitive.ObjectIDFromHex(id)
+	return d.find(ctx, id, false)
+}
+func (d *UserRepository) FindOneWithDeleted(ctx context.Context, id string) (u user.User, err error) {
+	return d.find(ctx, id, true)
+}
+func (d *UserRepository) find(ctx context.Context, id string, withDeleted bool) (u user.User, err error) {
+	tenant, err := storage.Tenant(ctx)
 	if err != nil {
-		return u, fmt.Errorf("error converting hex to objectId: %s", id)
+		return u, err
+	}
+	filter := bson.M{"_id": idFilter(id), "tenant_id": tenant}
+	if !withDeleted {
+		filter["deleted_at"] = nil
 	}
-	filter := bson.M{"_id": oid}
 	result := d.collection.FindOne(ctx, filter)
 	if result.Err() != nil {
 		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
@@ -51,22 +113,121 @@ func (d *UserRepository) FindOne(ctx context.Context, id string) (u user.User, e
 	}
 	return u, nil
 }
-func (d *UserRepository) FindAll(ctx context.Context) (u []user.User, err error) {
-	result, err := d.collection.Find(ctx, bson.M{})
-	if result.Err() != nil {
-		return u, fmt.Errorf("error finding users, due to error:%v", err)
+func (d *UserRepository) FindAll(ctx context.Context, query user.ListQuery) (page user.Page, err error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return page, err
+	}
+	conditions := bson.A{bson.M{"tenant_id": tenant, "deleted_at": nil}}
+	if query.Email != "" {
+		conditions = append(conditions, bson.M{"email": query.Email})
 	}
+	if query.Username != "" {
+		conditions = append(conditions, bson.M{"username": query.Username})
+	}
+	if query.UsernamePrefix != "" {
+		// an anchored, case sensitive regex can use the username index
+		conditions = append(conditions, bson.M{"username": bson.M{"$regex": "^" + regexp.QuoteMeta(query.UsernamePrefix)}})
+	}
+	if !query.CreatedAfter.IsZero() {
+		conditions = append(conditions, bson.M{"created_at": bson.M{"$gt": query.CreatedAfter}})
+	}
+	if query.Cursor != "" {
+		position, err := storage.DecodeCursor(query.Cursor, query.Sort)
+		if err != nil {
+			return page, err
+		}
+		conditions = append(conditions, afterFilter(position, query.Sort))
+	}
+	filter := bson.M{"$and": conditions}
+
+	sort := bson.D{}
+	for _, field := range query.Sort {
+		direction := 1
+		if field.Desc {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: field.Field, Value: direction})
+	}
+	sort = append(sort, bson.E{Key: "_id", Value: 1})
+
+	findOptions := options.Find().
+		SetSort(sort).
+		SetLimit(int64(query.Limit) + 1)
+	result, err := d.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return page, fmt.Errorf("error finding users, due to error:%v", err)
+	}
+	var u []user.User
 	if err := result.All(ctx, &u); err != nil {
-		return u, fmt.Errorf("error decoding users, due to error:%v", err)
+		return page, fmt.Errorf("error decoding users, due to error:%v", err)
 	}
-	return u, nil
+	return storage.NewPage(u, query), nil
+}
+
+// afterFilter matches the users that sort after position:
+// (f1 > v1) or (f1 = v1 and f2 > v2) or ... or (all equal and _id > id).
+func afterFilter(position user.User, sort []user.SortField) bson.M {
+	var or bson.A
+	equal := bson.M{}
+	condition := func(key string, value interface{}) bson.M {
+		c := bson.M{key: value}
+		for k, v := range equal {
+			c[k] = v
+		}
+		return c
+	}
+	for _, field := range sort {
+		op := "$gt"
+		if field.Desc {
+			op = "$lt"
+		}
+		var value interface{} = storage.SortValue(position, field.Field)
+		if field.Field == user.FieldCreatedAt {
+			value = position.CreatedAt
+		}
+		or = append(or, condition(field.Field, bson.M{op: value}))
+		equal[field.Field] = value
+	}
+	or = append(or, condition("_id", bson.M{"$gt": position.ID}))
+	return bson.M{"$or": or}
+}
+func (d *UserRepository) Stream(ctx context.Context, fn func(user.User) error) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	result, err := d.collection.Find(ctx, bson.M{"tenant_id": tenant, "deleted_at": nil}, findOptions)
+	if err != nil {
+		return fmt.Errorf("error streaming users, due to error:%v", err)
+	}
+	defer result.Close(ctx)
+
+	for result.Next(ctx) {
+		var u user.User
+		if err := result.Decode(&u); err != nil {
+			return fmt.Errorf("error decoding user, due to error:%v", err)
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("error streaming users, due to error:%v", err)
+	}
+	return nil
 }
 func (d *UserRepository) Update(ctx context.Context, user user.User) error {
-	objectID, objConvError := primitive.ObjectIDFromHex(user.ID)
-	if objConvError != nil {
-		return fmt.Errorf("error converting hex to objectId: %s", user.ID)
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": idFilter(user.ID), "tenant_id": tenant, "deleted_at": nil}
+	if user.Version != 0 {
+		// the write only matches if nobody changed the user in between
+		filter["version"] = user.Version
 	}
-	filter := bson.M{"_id": objectID}
 
 	// Create a map for the fields to update
 	updateUserObj := make(map[string]interface{})
@@ -81,36 +242,143 @@ func (d *UserRepository) Update(ctx context.Context, user user.User) error {
 	if user.PasswordHash != "" {
 		updateUserObj["password"] = user.PasswordHash
 	}
+	if !user.UpdatedAt.IsZero() {
+		updateUserObj["updated_at"] = user.UpdatedAt
+	}
+	if user.EncryptedFields != "" {
+		updateUserObj["encrypted_fields"] = user.EncryptedFields
+	}
 
-	update := bson.M{"$set": updateUserObj}
+	update := bson.M{"$set": updateUserObj, "$inc": bson.M{"version": 1}}
 	result, err := d.collection.UpdateOne(ctx, filter, update)
 	if err != nil {
+		if conflictErr := conflictError(err); conflictErr != nil {
+			return conflictErr
+		}
 		return fmt.Errorf("error updating user: %v", err)
 	}
 
 	if result.MatchedCount == 0 {
-		return apperrors.ErrNotFound
+		return d.notMatchedError(ctx, user.ID, user.Version)
 	}
 	d.logger.Tracef("Matched %d, documents and updated %d documents.\n", result.MatchedCount, result.ModifiedCount)
 
 	return nil
 }
-func (d *UserRepository) Delete(ctx context.Context, id string) error {
-	objectID, objConvError := primitive.ObjectIDFromHex(id)
-	if objConvError != nil {
-		return fmt.Errorf("error converting hex to objectId: %s", id)
+func (d *UserRepository) Delete(ctx context.Context, id string, version int64, deletedAt time.Time) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": idFilter(id), "tenant_id": tenant, "deleted_at": nil}
+	if version != 0 {
+		filter["version"] = version
 	}
-	filter := bson.M{"_id": objectID}
-	result, err := d.collection.DeleteOne(ctx, filter)
+	update := bson.M{
+		"$set": bson.M{"deleted_at": deletedAt, "updated_at": deletedAt},
+		"$inc": bson.M{"version": 1},
+	}
+	result, err := d.collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return fmt.Errorf("error deleting user by id %s:error: %v", id, err)
 	}
+	if result.MatchedCount == 0 {
+		return d.notMatchedError(ctx, id, version)
+	}
+	d.logger.Tracef("Marked %d documents as deleted.\n", result.ModifiedCount)
+	return nil
+}
+func (d *UserRepository) Restore(ctx context.Context, id string, restoredAt time.Time) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": idFilter(id), "tenant_id": tenant, "deleted_at": bson.M{"$ne": nil}}
+	update := bson.M{
+		"$unset": bson.M{"deleted_at": ""},
+		"$set":   bson.M{"updated_at": restoredAt},
+		"$inc":   bson.M{"version": 1},
+	}
+	result, err := d.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error restoring user by id %s:error: %v", id, err)
+	}
+	if result.MatchedCount == 0 {
+		return apperrors.ErrNotFound
+	}
+	d.logger.Tracef("Restored %d documents.\n", result.ModifiedCount)
+	return nil
+}
+func (d *UserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	filter := bson.M{"deleted_at": bson.M{"$lt": deletedBefore}}
+	result, err := d.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("error purging deleted users: %v", err)
+	}
+	d.logger.Tracef("Purged %d documents.\n", result.DeletedCount)
+	return result.DeletedCount, nil
+}
+
+func (d *UserRepository) Erase(ctx context.Context, id string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	result, err := d.collection.DeleteOne(ctx, bson.M{"_id": idFilter(id), "tenant_id": tenant})
+	if err != nil {
+		return fmt.Errorf("error erasing user by id %s:error: %v", id, err)
+	}
 	if result.DeletedCount == 0 {
 		return apperrors.ErrNotFound
 	}
-	d.logger.Tracef("Deleted %d documents.\n", result.DeletedCount)
 	return nil
 }
+
+// notMatchedError tells apart a missing user from a stale version after a
+// versioned write matched no document.
+func (d *UserRepository) notMatchedError(ctx context.Context, id string, version int64) error {
+	if version == 0 {
+		return apperrors.ErrNotFound
+	}
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	count, err := d.collection.CountDocuments(ctx, bson.M{"_id": idFilter(id), "tenant_id": tenant, "deleted_at": nil})
+	if err != nil {
+		return fmt.Errorf("error checking user version: %v", err)
+	}
+	if count == 0 {
+		return apperrors.ErrNotFound
+	}
+	return apperrors.ErrPreconditionFailed
+}
+
+// idFilter matches the user with id. Users created before ids were assigned
+// by the service have an ObjectID, which is matched as well.
+func idFilter(id string) interface{} {
+	if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+		return bson.M{"$in": bson.A{id, oid}}
+	}
+	return id
+}
+
+// conflictError converts a duplicate key error into apperrors.ConflictError
+// naming the violated field, or returns nil for any other error.
+func conflictError(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if strings.Contains(err.Error(), "index: _id_") {
+		return apperrors.ConflictError("id")
+	}
+	for _, field := range uniqueFields {
+		if strings.Contains(err.Error(), "index: tenant_id_1_"+field+"_1") {
+			return apperrors.ConflictError(field)
+		}
+	}
+	return apperrors.ConflictError("user")
+}
 func NewUserRepository(database *mongo.Database, collection string, logger *logging.Logger) *UserRepository {
 	return &UserRepository{
 		collection: database.Collection(collection),
@@ -0,0 +1,156 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/postgres/transaction"
+	"rest-api-go/pkg/logging"
+	"strconv"
+)
+
+type HistoryRepository struct {
+	db     *sql.DB
+	logger *logging.Logger
+}
+
+func (d *HistoryRepository) Create(ctx context.Context, record history.Record) error {
+	d.logger.Debug("create history record")
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	changes, err := json.Marshal(record.Changes)
+	if err != nil {
+		return fmt.Errorf("error encoding history changes: %w", err)
+	}
+	_, err = transaction.Conn(ctx, d.db).ExecContext(ctx,
+		"INSERT INTO user_history (tenant_id, user_id, actor, action, timestamp, changes) VALUES ($1, $2, $3, $4, $5, $6)",
+		tenant, record.UserID, record.Actor, record.Action, record.Timestamp, changes)
+	if err != nil {
+		return fmt.Errorf("error creating history record: %w", err)
+	}
+	return nil
+}
+func (d *HistoryRepository) FindByUserID(ctx context.Context, userID string) (r []history.Record, err error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return r, err
+	}
+	rows, err := transaction.Conn(ctx, d.db).QueryContext(ctx,
+		`SELECT `+recordColumns+` FROM user_history
+		WHERE tenant_id = $1 AND user_id = $2 ORDER BY timestamp, id`, tenant, userID)
+	if err != nil {
+		return r, fmt.Errorf("error finding history of user %s, due to error:%v", userID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return r, fmt.Errorf("error decoding history of user %s, due to error:%v", userID, err)
+		}
+		r = append(r, record)
+	}
+	if err := rows.Err(); err != nil {
+		return r, fmt.Errorf("error decoding history of user %s, due to error:%v", userID, err)
+	}
+	return r, nil
+}
+
+const recordColumns = "id::text, tenant_id, user_id, actor, action, timestamp, changes"
+
+func scanRecord(rows *sql.Rows) (record history.Record, err error) {
+	var changes []byte
+	err = rows.Scan(&record.ID, &record.TenantID, &record.UserID, &record.Actor, &record.Action, &record.Timestamp, &changes)
+	if err != nil {
+		return record, err
+	}
+	if err := json.Unmarshal(changes, &record.Changes); err != nil {
+		return record, fmt.Errorf("error decoding history changes: %v", err)
+	}
+	record.Timestamp = record.Timestamp.UTC()
+	return record, nil
+}
+func (d *HistoryRepository) Anonymize(ctx context.Context, userID string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Conn(ctx, d.db).ExecContext(ctx,
+		`UPDATE user_history SET changes = (
+			SELECT COALESCE(jsonb_agg(change - 'before' - 'after' ORDER BY position), '[]')
+			FROM jsonb_array_elements(changes) WITH ORDINALITY AS c(change, position))
+		WHERE tenant_id = $1 AND user_id = $2`, tenant, userID)
+	if err != nil {
+		return fmt.Errorf("error anonymizing history of user %s: %w", userID, err)
+	}
+	return nil
+}
+func (d *HistoryRepository) Stream(ctx context.Context, fn func(history.Record) error) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	rows, err := transaction.Conn(ctx, d.db).QueryContext(ctx,
+		`SELECT `+recordColumns+` FROM user_history WHERE tenant_id = $1 ORDER BY id`, tenant)
+	if err != nil {
+		return fmt.Errorf("error streaming history, due to error:%v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return fmt.Errorf("error decoding history, due to error:%v", err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error streaming history, due to error:%v", err)
+	}
+	return nil
+}
+func (d *HistoryRepository) ReplaceChanges(ctx context.Context, id string, old, changes []history.Change) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return apperrors.ErrPreconditionFailed
+	}
+	oldJSON, err := json.Marshal(old)
+	if err != nil {
+		return fmt.Errorf("error encoding history changes: %w", err)
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("error encoding history changes: %w", err)
+	}
+	result, err := transaction.Conn(ctx, d.db).ExecContext(ctx,
+		`UPDATE user_history SET changes = $1 WHERE id = $2 AND tenant_id = $3 AND changes = $4::jsonb`,
+		changesJSON, id, tenant, oldJSON)
+	if err != nil {
+		return fmt.Errorf("error replacing changes of history record %s: %w", id, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error replacing changes of history record %s: %w", id, err)
+	}
+	if affected == 0 {
+		return apperrors.ErrPreconditionFailed
+	}
+	return nil
+}
+func NewHistoryRepository(db *sql.DB, logger *logging.Logger) *HistoryRepository {
+	return &HistoryRepository{
+		db:     db,
+		logger: logger,
+	}
+}
@@ -0,0 +1,177 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"rest-api-go/pkg/logging"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the pg_advisory_xact_lock key that keeps several
+// instances from applying the same migration at once.
+const migrationLockID = 7_358_204_611
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+	// after runs in the transaction of the migration once its SQL is applied,
+	// for the steps that depend on the configuration
+	after func(ctx context.Context, tx *sql.Tx) error
+}
+
+// Migrate applies every embedded migration that is not recorded in
+// schema_migrations yet. Files are named <version>_<name>.sql and are applied
+// in version order, each in its own transaction. Rows stored before tenancy
+// are moved to defaultTenant.
+func Migrate(ctx context.Context, db *sql.DB, defaultTenant string, logger *logging.Logger) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	scoped := false
+	for i := range migrations {
+		if migrations[i].version == tenantsMigration {
+			migrations[i].after = scopeByTenant(defaultTenant)
+			scoped = true
+		}
+	}
+	if !scoped {
+		return fmt.Errorf("migration %d that scopes rows by tenant is missing", tenantsMigration)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+
+	for _, m := range migrations {
+		applied, err := applyMigration(ctx, db, m)
+		if err != nil {
+			return err
+		}
+		if applied {
+			logger.Infof("applied migration %04d_%s", m.version, m.name)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m migration) (applied bool, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting migration %d: %w", m.version, err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+		return false, fmt.Errorf("error locking migrations: %w", err)
+	}
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.version).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking migration %d: %w", m.version, err)
+	}
+	if exists {
+		return false, tx.Commit()
+	}
+
+	if _, err = tx.ExecContext(ctx, m.sql); err != nil {
+		return false, fmt.Errorf("error applying migration %04d_%s: %w", m.version, m.name, err)
+	}
+	if m.after != nil {
+		if err = m.after(ctx, tx); err != nil {
+			return false, fmt.Errorf("error applying migration %04d_%s: %w", m.version, m.name, err)
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+	if err != nil {
+		return false, fmt.Errorf("error recording migration %d: %w", m.version, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing migration %d: %w", m.version, err)
+	}
+	return true, nil
+}
+
+// tenantsMigration is 0009_tenants, which adds tenant_id without filling it
+const tenantsMigration = 9
+
+// scopeByTenant moves the rows stored before tenancy to the default tenant
+// and makes tenant_id required. Without a default tenant it fails if there
+// is anything to move, since no request could reach those rows.
+func scopeByTenant(defaultTenant string) func(ctx context.Context, tx *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, table := range []string{"users", "user_history", "outbox"} {
+			if defaultTenant == "" {
+				var count int64
+				err := tx.QueryRowContext(ctx, `SELECT count(*) FROM `+table+` WHERE tenant_id IS NULL`).Scan(&count)
+				if err != nil {
+					return fmt.Errorf("error counting rows of %s without tenant: %w", table, err)
+				}
+				if count > 0 {
+					return fmt.Errorf("%d rows of %s have no tenant, configure tenancy.default to move them to", count, table)
+				}
+			} else if _, err := tx.ExecContext(ctx,
+				`UPDATE `+table+` SET tenant_id = $1 WHERE tenant_id IS NULL`, defaultTenant); err != nil {
+				return fmt.Errorf("error backfilling tenant of %s: %w", table, err)
+			}
+			if _, err := tx.ExecContext(ctx, `ALTER TABLE `+table+` ALTER COLUMN tenant_id SET NOT NULL`); err != nil {
+				return fmt.Errorf("error requiring tenant of %s: %w", table, err)
+			}
+		}
+		return nil
+	}
+}
+
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(files))
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		version, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name: %s", file)
+		}
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", file, err)
+		}
+		body, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: v, name: name, sql: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].version)
+		}
+	}
+	return migrations, nil
+}
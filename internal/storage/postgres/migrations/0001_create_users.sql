@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS users (
+    id       TEXT PRIMARY KEY,
+    username TEXT NOT NULL,
+    email    TEXT NOT NULL,
+    password TEXT NOT NULL
+);
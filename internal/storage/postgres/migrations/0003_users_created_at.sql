@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX users_created_at_idx ON users (created_at);
+-- lets username LIKE 'prefix%' use an index regardless of the collation
+CREATE INDEX users_username_pattern_idx ON users (username text_pattern_ops);
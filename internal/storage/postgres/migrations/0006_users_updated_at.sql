@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN updated_at TIMESTAMPTZ;
+UPDATE users SET updated_at = created_at;
+ALTER TABLE users ALTER COLUMN updated_at SET NOT NULL;
+ALTER TABLE users ALTER COLUMN updated_at SET DEFAULT now();
@@ -0,0 +1,9 @@
+CREATE TABLE user_history (
+    id        BIGSERIAL PRIMARY KEY,
+    user_id   TEXT NOT NULL,
+    actor     TEXT NOT NULL,
+    action    TEXT NOT NULL,
+    timestamp TIMESTAMPTZ NOT NULL,
+    changes   JSONB NOT NULL DEFAULT '[]'
+);
+CREATE INDEX user_history_user_id_idx ON user_history (user_id, timestamp);
@@ -0,0 +1,9 @@
+CREATE TABLE outbox (
+    id           BIGSERIAL PRIMARY KEY,
+    type         TEXT NOT NULL,
+    aggregate_id TEXT NOT NULL,
+    payload      JSONB NOT NULL,
+    occurred_at  TIMESTAMPTZ NOT NULL,
+    published_at TIMESTAMPTZ
+);
+CREATE INDEX outbox_pending_idx ON outbox (id) WHERE published_at IS NULL;
@@ -0,0 +1,17 @@
+-- rows stored before tenancy are moved to the configured default tenant by
+-- scopeByTenant in migrate.go, which then makes tenant_id NOT NULL
+ALTER TABLE users ADD COLUMN tenant_id TEXT;
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+ALTER TABLE users DROP CONSTRAINT users_username_key;
+ALTER TABLE users ADD CONSTRAINT users_tenant_email_key UNIQUE (tenant_id, email);
+ALTER TABLE users ADD CONSTRAINT users_tenant_username_key UNIQUE (tenant_id, username);
+DROP INDEX users_created_at_idx;
+CREATE INDEX users_tenant_created_at_idx ON users (tenant_id, created_at);
+DROP INDEX users_username_pattern_idx;
+CREATE INDEX users_tenant_username_pattern_idx ON users (tenant_id, username text_pattern_ops);
+
+ALTER TABLE user_history ADD COLUMN tenant_id TEXT;
+DROP INDEX user_history_user_id_idx;
+CREATE INDEX user_history_tenant_user_id_idx ON user_history (tenant_id, user_id, timestamp);
+
+ALTER TABLE outbox ADD COLUMN tenant_id TEXT;
@@ -0,0 +1,2 @@
+-- finds the events of a user for data exports and erasure
+CREATE INDEX outbox_aggregate_idx ON outbox (tenant_id, aggregate_id, id);
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN encrypted_fields TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+CREATE TABLE refresh_tokens (
+    hash       TEXT PRIMARY KEY,
+    tenant_id  TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    family_id  TEXT NOT NULL,
+    issued_at  TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (tenant_id, family_id);
+CREATE INDEX refresh_tokens_user_idx ON refresh_tokens (tenant_id, user_id);
+CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
@@ -0,0 +1,170 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/outbox"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/postgres/transaction"
+	"rest-api-go/pkg/logging"
+	"strconv"
+	"time"
+)
+
+type OutboxRepository struct {
+	db     *sql.DB
+	logger *logging.Logger
+}
+
+func (d *OutboxRepository) Add(ctx context.Context, event outbox.Event) error {
+	d.logger.Debug("add outbox event")
+	_, err := transaction.Conn(ctx, d.db).ExecContext(ctx,
+		"INSERT INTO outbox (type, tenant_id, aggregate_id, payload, occurred_at) VALUES ($1, $2, $3, $4, $5)",
+		event.Type, event.TenantID, event.AggregateID, []byte(event.Payload), event.OccurredAt)
+	if err != nil {
+		return fmt.Errorf("error adding outbox event: %w", err)
+	}
+	return nil
+}
+func (d *OutboxRepository) FetchPending(ctx context.Context, limit int) (e []outbox.Event, err error) {
+	rows, err := transaction.Conn(ctx, d.db).QueryContext(ctx,
+		"SELECT "+eventColumns+" FROM outbox WHERE published_at IS NULL ORDER BY id LIMIT $1", limit)
+	if err != nil {
+		return e, fmt.Errorf("error fetching outbox events, due to error:%v", err)
+	}
+	return scanEvents(rows)
+}
+func (d *OutboxRepository) FindByAggregateID(ctx context.Context, aggregateID string) (e []outbox.Event, err error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return e, err
+	}
+	rows, err := transaction.Conn(ctx, d.db).QueryContext(ctx,
+		"SELECT "+eventColumns+" FROM outbox WHERE tenant_id = $1 AND aggregate_id = $2 ORDER BY id",
+		tenant, aggregateID)
+	if err != nil {
+		return e, fmt.Errorf("error finding events of %s, due to error:%v", aggregateID, err)
+	}
+	return scanEvents(rows)
+}
+
+const eventColumns = "id::text, type, tenant_id, aggregate_id, payload, occurred_at, published_at"
+
+func scanEvents(rows *sql.Rows) (e []outbox.Event, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return e, fmt.Errorf("error decoding outbox events, due to error:%v", err)
+		}
+		e = append(e, event)
+	}
+	if err := rows.Err(); err != nil {
+		return e, fmt.Errorf("error decoding outbox events, due to error:%v", err)
+	}
+	return e, nil
+}
+func scanEvent(rows *sql.Rows) (event outbox.Event, err error) {
+	var payload []byte
+	var publishedAt sql.NullTime
+	err = rows.Scan(&event.ID, &event.Type, &event.TenantID, &event.AggregateID, &payload, &event.OccurredAt, &publishedAt)
+	if err != nil {
+		return event, err
+	}
+	event.Payload = payload
+	event.OccurredAt = event.OccurredAt.UTC()
+	if publishedAt.Valid {
+		published := publishedAt.Time.UTC()
+		event.PublishedAt = &published
+	}
+	return event, nil
+}
+func (d *OutboxRepository) MarkPublished(ctx context.Context, id string, publishedAt time.Time) error {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return apperrors.ErrNotFound
+	}
+	result, err := transaction.Conn(ctx, d.db).ExecContext(ctx,
+		"UPDATE outbox SET published_at = $1 WHERE id = $2", publishedAt, id)
+	if err != nil {
+		return fmt.Errorf("error marking outbox event %s as published: %v", id, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error marking outbox event %s as published: %v", id, err)
+	}
+	if affected == 0 {
+		return apperrors.ErrNotFound
+	}
+	return nil
+}
+func (d *OutboxRepository) Anonymize(ctx context.Context, aggregateID string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Conn(ctx, d.db).ExecContext(ctx,
+		"UPDATE outbox SET payload = $1 WHERE tenant_id = $2 AND aggregate_id = $3",
+		[]byte(outbox.AnonymousPayload(aggregateID)), tenant, aggregateID)
+	if err != nil {
+		return fmt.Errorf("error anonymizing events of %s: %w", aggregateID, err)
+	}
+	return nil
+}
+func (d *OutboxRepository) Stream(ctx context.Context, fn func(outbox.Event) error) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	rows, err := transaction.Conn(ctx, d.db).QueryContext(ctx,
+		"SELECT "+eventColumns+" FROM outbox WHERE tenant_id = $1 ORDER BY id", tenant)
+	if err != nil {
+		return fmt.Errorf("error streaming outbox events, due to error:%v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return fmt.Errorf("error decoding outbox events, due to error:%v", err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error streaming outbox events, due to error:%v", err)
+	}
+	return nil
+}
+func (d *OutboxRepository) ReplacePayload(ctx context.Context, id string, old, payload json.RawMessage) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return apperrors.ErrPreconditionFailed
+	}
+	result, err := transaction.Conn(ctx, d.db).ExecContext(ctx,
+		"UPDATE outbox SET payload = $1 WHERE id = $2 AND tenant_id = $3 AND payload = $4::jsonb",
+		[]byte(payload), id, tenant, []byte(old))
+	if err != nil {
+		return fmt.Errorf("error replacing payload of outbox event %s: %w", id, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error replacing payload of outbox event %s: %w", id, err)
+	}
+	if affected == 0 {
+		return apperrors.ErrPreconditionFailed
+	}
+	return nil
+}
+func NewOutboxRepository(db *sql.DB, logger *logging.Logger) *OutboxRepository {
+	return &OutboxRepository{
+		db:     db,
+		logger: logger,
+	}
+}
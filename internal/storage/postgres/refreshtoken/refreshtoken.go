@@ -0,0 +1,184 @@
+package refreshtoken
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/postgres/transaction"
+	"rest-api-go/pkg/logging"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type RefreshTokenRepository struct {
+	db     *sql.DB
+	logger *logging.Logger
+}
+
+func (d *RefreshTokenRepository) Create(ctx context.Context, token auth.RefreshToken) error {
+	d.logger.Debug("create refresh token")
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Conn(ctx, d.db).ExecContext(ctx,
+		`INSERT INTO refresh_tokens (hash, tenant_id, user_id, family_id, issued_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		token.Hash, tenant, token.UserID, token.FamilyID, token.IssuedAt, token.ExpiresAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return apperrors.ConflictError("hash")
+	}
+	if err != nil {
+		return fmt.Errorf("error creating refresh token: %w", err)
+	}
+	return nil
+}
+func (d *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (token auth.RefreshToken, err error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return token, err
+	}
+	row := transaction.Conn(ctx, d.db).QueryRowContext(ctx,
+		"SELECT "+tokenColumns+" FROM refresh_tokens WHERE hash = $1 AND tenant_id = $2", hash, tenant)
+	token, err = scanToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.RefreshToken{}, apperrors.ErrNotFound
+	}
+	if err != nil {
+		return token, fmt.Errorf("error finding refresh token: %w", err)
+	}
+	return token, nil
+}
+func (d *RefreshTokenRepository) FindByUserID(ctx context.Context, userID string) ([]auth.RefreshToken, error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := transaction.Conn(ctx, d.db).QueryContext(ctx,
+		"SELECT "+tokenColumns+" FROM refresh_tokens WHERE tenant_id = $1 AND user_id = $2 ORDER BY issued_at, hash",
+		tenant, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error finding refresh tokens of user %s: %w", userID, err)
+	}
+	defer rows.Close()
+	var tokens []auth.RefreshToken
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning refresh token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+func (d *RefreshTokenRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Conn(ctx, d.db).ExecContext(ctx,
+		"DELETE FROM refresh_tokens WHERE tenant_id = $1 AND user_id = $2", tenant, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting refresh tokens of user %s: %w", userID, err)
+	}
+	return nil
+}
+
+const tokenColumns = "hash, tenant_id, user_id, family_id, issued_at, expires_at, rotated_at, revoked_at"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanToken(row scanner) (token auth.RefreshToken, err error) {
+	var rotatedAt, revokedAt sql.NullTime
+	err = row.Scan(&token.Hash, &token.TenantID, &token.UserID, &token.FamilyID,
+		&token.IssuedAt, &token.ExpiresAt, &rotatedAt, &revokedAt)
+	token.IssuedAt = token.IssuedAt.UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	token.RotatedAt = utcTime(rotatedAt)
+	token.RevokedAt = utcTime(revokedAt)
+	return token, err
+}
+
+func utcTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
+func (d *RefreshTokenRepository) Rotate(ctx context.Context, hash string, rotatedAt time.Time) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	// the state is checked and changed in one statement, so of two concurrent
+	// rotations only one updates the row
+	result, err := transaction.Conn(ctx, d.db).ExecContext(ctx,
+		`UPDATE refresh_tokens SET rotated_at = $1
+		WHERE hash = $2 AND tenant_id = $3 AND rotated_at IS NULL AND revoked_at IS NULL`,
+		rotatedAt, hash, tenant)
+	if err != nil {
+		return fmt.Errorf("error rotating refresh token: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error rotating refresh token: %w", err)
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	var exists bool
+	err = transaction.Conn(ctx, d.db).QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE hash = $1 AND tenant_id = $2)", hash, tenant).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error checking refresh token: %w", err)
+	}
+	if !exists {
+		return apperrors.ErrNotFound
+	}
+	return apperrors.ErrPreconditionFailed
+}
+func (d *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	return d.revoke(ctx, "family_id", familyID, revokedAt)
+}
+func (d *RefreshTokenRepository) RevokeUser(ctx context.Context, userID string, revokedAt time.Time) error {
+	return d.revoke(ctx, "user_id", userID, revokedAt)
+}
+
+// revoke revokes the tokens of the tenant of ctx whose column holds value
+func (d *RefreshTokenRepository) revoke(ctx context.Context, column, value string, revokedAt time.Time) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Conn(ctx, d.db).ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = $1 WHERE tenant_id = $2 AND "+column+" = $3 AND revoked_at IS NULL",
+		revokedAt, tenant, value)
+	if err != nil {
+		return fmt.Errorf("error revoking refresh tokens by %s: %w", column, err)
+	}
+	return nil
+}
+func (d *RefreshTokenRepository) Purge(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	result, err := transaction.Conn(ctx, d.db).ExecContext(ctx,
+		"DELETE FROM refresh_tokens WHERE expires_at < $1", expiredBefore)
+	if err != nil {
+		return 0, fmt.Errorf("error purging refresh tokens: %w", err)
+	}
+	return result.RowsAffected()
+}
+func NewRefreshTokenRepository(db *sql.DB, logger *logging.Logger) *RefreshTokenRepository {
+	return &RefreshTokenRepository{
+		db:     db,
+		logger: logger,
+	}
+}
@@ -0,0 +1,25 @@
+package postgres
+
+import (
+	"database/sql"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/postgres/history"
+	"rest-api-go/internal/storage/postgres/outbox"
+	"rest-api-go/internal/storage/postgres/refreshtoken"
+	"rest-api-go/internal/storage/postgres/transaction"
+	"rest-api-go/internal/storage/postgres/user"
+	"rest-api-go/pkg/logging"
+)
+
+// NewRepository implementation for postgres storage of all repositories.
+// Run Migrate before using it so the schema is up to date.
+func NewRepository(db *sql.DB, logger *logging.Logger) *storage.Repository {
+	return &storage.Repository{
+		User:         user.NewUserRepository(db, logger),
+		History:      history.NewHistoryRepository(db, logger),
+		Outbox:       outbox.NewOutboxRepository(db, logger),
+		RefreshToken: refreshtoken.NewRefreshTokenRepository(db, logger),
+		Transactor:   transaction.NewTransactor(db),
+		//add other repositories here
+	}
+}
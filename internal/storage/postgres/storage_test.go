@@ -0,0 +1,70 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/postgres"
+	"rest-api-go/internal/storage/storagetest"
+	"rest-api-go/pkg/logging"
+	"sync"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+var (
+	dbOnce sync.Once
+	db     *sql.DB
+	dbErr  error
+)
+
+// newTestRepository returns the repositories of the database of
+// POSTGRES_TEST_DSN with every table emptied, the database is migrated once.
+// Tests are skipped without it.
+func newTestRepository(t *testing.T) *storage.Repository {
+	t.Helper()
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+	ctx := context.Background()
+	dbOnce.Do(func() {
+		if db, dbErr = sql.Open("postgres", dsn); dbErr != nil {
+			return
+		}
+		dbErr = postgres.Migrate(ctx, db, storagetest.Tenant, logging.GetLogger())
+	})
+	if dbErr != nil {
+		t.Fatalf("failed to set up %s: %v", dsn, dbErr)
+	}
+	if _, err := db.ExecContext(ctx, `TRUNCATE users, user_history, outbox, refresh_tokens`); err != nil {
+		t.Fatalf("failed to empty the tables: %v", err)
+	}
+	return postgres.NewRepository(db, logging.GetLogger())
+}
+
+func TestUserRepository(t *testing.T) {
+	storagetest.RunUserRepositoryTests(t, func(t *testing.T) storage.UserRepository {
+		return newTestRepository(t).User
+	})
+}
+
+func TestHistoryRepository(t *testing.T) {
+	storagetest.RunHistoryRepositoryTests(t, func(t *testing.T) storage.HistoryRepository {
+		return newTestRepository(t).History
+	})
+}
+
+func TestOutboxRepository(t *testing.T) {
+	storagetest.RunOutboxRepositoryTests(t, func(t *testing.T) storage.OutboxRepository {
+		return newTestRepository(t).Outbox
+	})
+}
+
+func TestRefreshTokenRepository(t *testing.T) {
+	storagetest.RunRefreshTokenRepositoryTests(t, func(t *testing.T) storage.RefreshTokenRepository {
+		return newTestRepository(t).RefreshToken
+	})
+}
@@ -0,0 +1,422 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/storage"
+	"rest-api-go/internal/storage/postgres/transaction"
+	"rest-api-go/pkg/logging"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const userColumns = "id, tenant_id, username, email, password, created_at, updated_at, version, encrypted_fields"
+
+// selectColumns are the columns scanUser reads
+const selectColumns = userColumns + ", deleted_at"
+
+// sortColumns maps sortable fields to their columns
+var sortColumns = map[string]string{
+	user.FieldUsername:  "username",
+	user.FieldEmail:     "email",
+	user.FieldCreatedAt: "created_at",
+}
+
+// uniqueConstraints maps unique constraint names to the field they protect
+var uniqueConstraints = map[string]string{
+	"users_pkey":                "id",
+	"users_tenant_email_key":    "email",
+	"users_tenant_username_key": "username",
+}
+
+type UserRepository struct {
+	db     *sql.DB
+	logger *logging.Logger
+}
+
+func (d *UserRepository) Create(ctx context.Context, user user.User) (string, error) {
+	d.logger.Debug("create user")
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	user.TenantID = tenant
+	return insert(ctx, transaction.Conn(ctx, d.db), user)
+}
+func (d *UserRepository) CreateMany(ctx context.Context, users []user.User) ([]user.BatchItem, error) {
+	d.logger.Debugf("create %d users", len(users))
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]user.BatchItem, len(users))
+	// one transaction saves a commit per user, a savepoint per user keeps a
+	// failing insert from aborting it
+	err = transaction.NewTransactor(d.db).WithinTransaction(ctx, func(ctx context.Context) error {
+		conn := transaction.Conn(ctx, d.db)
+		for i, u := range users {
+			if _, err := conn.ExecContext(ctx, "SAVEPOINT create_many"); err != nil {
+				return fmt.Errorf("error creating users: %w", err)
+			}
+			u.TenantID = tenant
+			items[i].ID, items[i].Error = insert(ctx, conn, u)
+			statement := "RELEASE SAVEPOINT create_many"
+			if items[i].Error != nil {
+				statement = "ROLLBACK TO SAVEPOINT create_many"
+			}
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("error creating users: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// insert stores user in user.TenantID and returns its id
+func insert(ctx context.Context, conn transaction.Executor, user user.User) (string, error) {
+	if user.ID == "" {
+		return "", storage.ErrMissingID
+	}
+	user.Version = 1
+	_, err := conn.ExecContext(ctx,
+		"INSERT INTO users ("+userColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		user.ID, user.TenantID, user.Username, user.Email, user.PasswordHash, user.CreatedAt, user.UpdatedAt, user.Version,
+		user.EncryptedFields)
+	if err != nil {
+		if conflictErr := conflictError(err); conflictErr != nil {
+			return "", conflictErr
+		}
+		return "", fmt.Errorf("error creating user: %w", err)
+	}
+	return user.ID, nil
+}
+func (d *UserRepository) FindOne(ctx context.Context, id string) (u user.User, err error) {
+	return d.find(ctx, id, " AND deleted_at IS NULL")
+}
+func (d *UserRepository) FindOneWithDeleted(ctx context.Context, id string) (u user.User, err error) {
+	return d.find(ctx, id, "")
+}
+func (d *UserRepository) find(ctx context.Context, id, deletedCondition string) (u user.User, err error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return u, err
+	}
+	row := transaction.Conn(ctx, d.db).QueryRowContext(ctx,
+		"SELECT "+selectColumns+" FROM users WHERE id = $1 AND tenant_id = $2"+deletedCondition, id, tenant)
+	if u, err = scanUser(row); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return u, apperrors.ErrNotFound
+		}
+		return u, fmt.Errorf("error finding user by id: %s, due to error:%v", id, err)
+	}
+	return u, nil
+}
+func (d *UserRepository) FindAll(ctx context.Context, query user.ListQuery) (page user.Page, err error) {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return page, err
+	}
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conditions := []string{"tenant_id = " + arg(tenant), "deleted_at IS NULL"}
+
+	if query.Email != "" {
+		conditions = append(conditions, "email = "+arg(query.Email))
+	}
+	if query.Username != "" {
+		conditions = append(conditions, "username = "+arg(query.Username))
+	}
+	if query.UsernamePrefix != "" {
+		conditions = append(conditions, "username LIKE "+arg(likePrefix(query.UsernamePrefix)))
+	}
+	if !query.CreatedAfter.IsZero() {
+		conditions = append(conditions, "created_at > "+arg(query.CreatedAfter))
+	}
+	if query.Cursor != "" {
+		position, err := storage.DecodeCursor(query.Cursor, query.Sort)
+		if err != nil {
+			return page, err
+		}
+		// (f1 > v1) OR (f1 = v1 AND f2 > v2) OR ... OR (all equal AND id > id)
+		var or, equal []string
+		for _, field := range query.Sort {
+			column := sortColumns[field.Field]
+			op := ">"
+			if field.Desc {
+				op = "<"
+			}
+			var value interface{} = storage.SortValue(position, field.Field)
+			if field.Field == user.FieldCreatedAt {
+				value = position.CreatedAt
+			}
+			placeholder := arg(value)
+			or = append(or, "("+strings.Join(append(equal, column+" "+op+" "+placeholder), " AND ")+")")
+			equal = append(equal, column+" = "+placeholder)
+		}
+		or = append(or, "("+strings.Join(append(equal, "id > "+arg(position.ID)), " AND ")+")")
+		conditions = append(conditions, "("+strings.Join(or, " OR ")+")")
+	}
+
+	var order []string
+	for _, field := range query.Sort {
+		direction := "ASC"
+		if field.Desc {
+			direction = "DESC"
+		}
+		order = append(order, sortColumns[field.Field]+" "+direction)
+	}
+	order = append(order, "id ASC")
+
+	statement := "SELECT " + selectColumns + " FROM users WHERE " + strings.Join(conditions, " AND ") +
+		" ORDER BY " + strings.Join(order, ", ") + " LIMIT " + arg(query.Limit+1)
+
+	rows, err := transaction.Conn(ctx, d.db).QueryContext(ctx, statement, args...)
+	if err != nil {
+		return page, fmt.Errorf("error finding users, due to error:%v", err)
+	}
+	defer rows.Close()
+
+	var u []user.User
+	for rows.Next() {
+		usr, err := scanUser(rows)
+		if err != nil {
+			return page, fmt.Errorf("error decoding users, due to error:%v", err)
+		}
+		u = append(u, usr)
+	}
+	if err := rows.Err(); err != nil {
+		return page, fmt.Errorf("error decoding users, due to error:%v", err)
+	}
+	return storage.NewPage(u, query), nil
+}
+
+func (d *UserRepository) Stream(ctx context.Context, fn func(user.User) error) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	rows, err := transaction.Conn(ctx, d.db).QueryContext(ctx,
+		"SELECT "+selectColumns+" FROM users WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY id", tenant)
+	if err != nil {
+		return fmt.Errorf("error streaming users, due to error:%v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return fmt.Errorf("error decoding user, due to error:%v", err)
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error streaming users, due to error:%v", err)
+	}
+	return nil
+}
+
+// likePrefix escapes LIKE wildcards in prefix and appends one
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (u user.User, err error) {
+	var deletedAt sql.NullTime
+	err = row.Scan(&u.ID, &u.TenantID, &u.Username, &u.Email, &u.PasswordHash, &u.CreatedAt, &u.UpdatedAt, &u.Version,
+		&u.EncryptedFields, &deletedAt)
+	u.CreatedAt = u.CreatedAt.UTC()
+	u.UpdatedAt = u.UpdatedAt.UTC()
+	if deletedAt.Valid {
+		deleted := deletedAt.Time.UTC()
+		u.DeletedAt = &deleted
+	}
+	return u, err
+}
+func (d *UserRepository) Update(ctx context.Context, user user.User) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Only include non-empty fields in the SET clause
+	sets := []string{"version = version + 1"}
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if user.Email != "" {
+		set("email", user.Email)
+	}
+	if user.Username != "" {
+		set("username", user.Username)
+	}
+	if user.PasswordHash != "" {
+		set("password", user.PasswordHash)
+	}
+	if !user.UpdatedAt.IsZero() {
+		set("updated_at", user.UpdatedAt)
+	}
+	if user.EncryptedFields != "" {
+		set("encrypted_fields", user.EncryptedFields)
+	}
+
+	args = append(args, user.ID, tenant)
+	query := fmt.Sprintf("UPDATE users SET %s WHERE id = $%d AND tenant_id = $%d AND deleted_at IS NULL",
+		strings.Join(sets, ", "), len(args)-1, len(args))
+	if user.Version != 0 {
+		// the write only matches if nobody changed the user in between
+		args = append(args, user.Version)
+		query += fmt.Sprintf(" AND version = $%d", len(args))
+	}
+	result, err := transaction.Conn(ctx, d.db).ExecContext(ctx, query, args...)
+	if err != nil {
+		if conflictErr := conflictError(err); conflictErr != nil {
+			return conflictErr
+		}
+		return fmt.Errorf("error updating user: %v", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error updating user: %v", err)
+	}
+	if affected == 0 {
+		return d.notMatchedError(ctx, user.ID, user.Version)
+	}
+	d.logger.Tracef("Updated %d rows.\n", affected)
+	return nil
+}
+func (d *UserRepository) Delete(ctx context.Context, id string, version int64, deletedAt time.Time) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	result, err := transaction.Conn(ctx, d.db).ExecContext(ctx,
+		`UPDATE users SET deleted_at = $4, updated_at = $4, version = version + 1
+		WHERE id = $1 AND tenant_id = $3 AND deleted_at IS NULL AND ($2::bigint = 0 OR version = $2)`, id, version, tenant, deletedAt)
+	if err != nil {
+		return fmt.Errorf("error deleting user by id %s:error: %v", id, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error deleting user by id %s:error: %v", id, err)
+	}
+	if affected == 0 {
+		return d.notMatchedError(ctx, id, version)
+	}
+	d.logger.Tracef("Marked %d rows as deleted.\n", affected)
+	return nil
+}
+func (d *UserRepository) Restore(ctx context.Context, id string, restoredAt time.Time) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	result, err := transaction.Conn(ctx, d.db).ExecContext(ctx,
+		`UPDATE users SET deleted_at = NULL, updated_at = $3, version = version + 1
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NOT NULL`, id, tenant, restoredAt)
+	if err != nil {
+		return fmt.Errorf("error restoring user by id %s:error: %v", id, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error restoring user by id %s:error: %v", id, err)
+	}
+	if affected == 0 {
+		return apperrors.ErrNotFound
+	}
+	d.logger.Tracef("Restored %d rows.\n", affected)
+	return nil
+}
+func (d *UserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	result, err := transaction.Conn(ctx, d.db).ExecContext(ctx, "DELETE FROM users WHERE deleted_at < $1", deletedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("error purging deleted users: %v", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error purging deleted users: %v", err)
+	}
+	d.logger.Tracef("Purged %d rows.\n", affected)
+	return affected, nil
+}
+
+func (d *UserRepository) Erase(ctx context.Context, id string) error {
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	result, err := transaction.Conn(ctx, d.db).ExecContext(ctx,
+		"DELETE FROM users WHERE id = $1 AND tenant_id = $2", id, tenant)
+	if err != nil {
+		return fmt.Errorf("error erasing user by id %s:error: %v", id, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error erasing user by id %s:error: %v", id, err)
+	}
+	if affected == 0 {
+		return apperrors.ErrNotFound
+	}
+	return nil
+}
+
+// notMatchedError tells apart a missing user from a stale version after a
+// versioned write matched no row.
+func (d *UserRepository) notMatchedError(ctx context.Context, id string, version int64) error {
+	if version == 0 {
+		return apperrors.ErrNotFound
+	}
+	tenant, err := storage.Tenant(ctx)
+	if err != nil {
+		return err
+	}
+	var exists bool
+	err = transaction.Conn(ctx, d.db).QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL)", id, tenant).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error checking user version: %v", err)
+	}
+	if !exists {
+		return apperrors.ErrNotFound
+	}
+	return apperrors.ErrPreconditionFailed
+}
+
+// conflictError converts a unique_violation into apperrors.ConflictError
+// naming the violated field, or returns nil for any other error.
+func conflictError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return nil
+	}
+	if field, ok := uniqueConstraints[pqErr.Constraint]; ok {
+		return apperrors.ConflictError(field)
+	}
+	return apperrors.ConflictError("user")
+}
+func NewUserRepository(db *sql.DB, logger *logging.Logger) *UserRepository {
+	return &UserRepository{
+		db:     db,
+		logger: logger,
+	}
+}
@@ -3,19 +3,156 @@ package storage
 //repository interface abstraction
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/entities/outbox"
 	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/requestctx"
+	"time"
 )
 
+// ErrMissingID is returned when a user is created without an id. Ids are
+// assigned by the service, repositories store them as they are.
+var ErrMissingID = errors.New("user has no id")
+
+// ErrMissingTenant is returned by tenant scoped repositories called with a
+// context that carries no tenant, so a forgotten scope fails instead of
+// reaching the data of every tenant. It is a bad request, since requests
+// only lack a tenant when they name none and there is no default.
+var ErrMissingTenant error = apperrors.NewAppError(nil, "context has no tenant", "", "400")
+
+// Tenant returns the tenant of ctx that scopes user and history queries
+func Tenant(ctx context.Context) (string, error) {
+	tenant := requestctx.Tenant(ctx)
+	if tenant == "" {
+		return "", ErrMissingTenant
+	}
+	return tenant, nil
+}
+
+// UserRepository only sees the users of the tenant of the context, see
+// Tenant. Emails and usernames are unique per tenant, ids across tenants.
 type UserRepository interface {
 	Create(ctx context.Context, user user.User) (string, error)
+	// CreateMany creates every user it can in as few round trips as the
+	// backend allows and returns one item per user, in order. A failing user
+	// does not stop the others, use a Transactor to make it all or nothing.
+	CreateMany(ctx context.Context, users []user.User) ([]user.BatchItem, error)
 	FindOne(ctx context.Context, id string) (user.User, error)
-	FindAll(ctx context.Context) ([]user.User, error)
+	// FindOneWithDeleted is FindOne that finds deleted users too, with
+	// DeletedAt set
+	FindOneWithDeleted(ctx context.Context, id string) (user.User, error)
+	// FindAll returns the users matching query ordered by query.Sort and
+	// then by ID, starting after query.Cursor. query.Limit must be positive.
+	FindAll(ctx context.Context, query user.ListQuery) (user.Page, error)
+	// Stream calls fn for every user that is not deleted, ordered by ID,
+	// without loading them all at once. It stops at the first error of fn
+	// and returns it.
+	Stream(ctx context.Context, fn func(user.User) error) error
+	// Update only changes non-empty fields, UpdatedAt included. If user.Version is not 0 it must
+	// match the stored version, otherwise apperrors.ErrPreconditionFailed is
+	// returned. Every write increments the stored version.
 	Update(ctx context.Context, user user.User) error
-	Delete(ctx context.Context, id string) error
+	// Delete only marks the user as deleted at deletedAt, which becomes its
+	// UpdatedAt too. Deleted users are invisible to FindOne, FindAll and
+	// Update until they are restored or purged.
+	// version is checked the same way as in Update.
+	Delete(ctx context.Context, id string, version int64, deletedAt time.Time) error
+	// Restore undoes Delete, restoredAt becomes the UpdatedAt of the user
+	Restore(ctx context.Context, id string, restoredAt time.Time) error
+	// Purge hard-deletes users that were deleted before the given time, in
+	// every tenant.
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+	// Erase hard-deletes a user whether it is deleted or not, it returns
+	// apperrors.ErrNotFound if there is none.
+	Erase(ctx context.Context, id string) error
+}
+
+// HistoryRepository is append-only, records are never changed once stored
+// except when a user is erased or their values are resealed with another
+// key. Records are stored in and found within the tenant of the context.
+type HistoryRepository interface {
+	Create(ctx context.Context, record history.Record) error
+	// FindByUserID returns the records of a user, oldest first
+	FindByUserID(ctx context.Context, userID string) ([]history.Record, error)
+	// Anonymize clears the Before and After values of the changes of every
+	// record of a user, which keeps who changed which field and when.
+	Anonymize(ctx context.Context, userID string) error
+	// Stream calls fn with every record of the tenant, in the order they
+	// were stored, until fn returns an error and returns it.
+	Stream(ctx context.Context, fn func(history.Record) error) error
+	// ReplaceChanges replaces the changes of a record if they still equal
+	// old, otherwise or if the record is gone it returns
+	// apperrors.ErrPreconditionFailed.
+	ReplaceChanges(ctx context.Context, id string, old, changes []history.Change) error
+}
+
+// OutboxRepository is shared by all tenants for the relay, the methods
+// about one aggregate only see the events of the tenant of the context.
+type OutboxRepository interface {
+	Add(ctx context.Context, event outbox.Event) error
+	// FetchPending returns up to limit unpublished events, oldest first
+	FetchPending(ctx context.Context, limit int) ([]outbox.Event, error)
+	MarkPublished(ctx context.Context, id string, publishedAt time.Time) error
+	// FindByAggregateID returns the events of an aggregate, oldest first,
+	// whether they are published or not
+	FindByAggregateID(ctx context.Context, aggregateID string) ([]outbox.Event, error)
+	// Anonymize replaces the payload of every event of an aggregate with
+	// {"id": aggregateID}
+	Anonymize(ctx context.Context, aggregateID string) error
+	// Stream calls fn with every event of the tenant of the context, oldest
+	// first, until fn returns an error and returns it.
+	Stream(ctx context.Context, fn func(outbox.Event) error) error
+	// ReplacePayload replaces the payload of an event of the tenant of the
+	// context if it still equals old, otherwise or if the event is gone it
+	// returns apperrors.ErrPreconditionFailed.
+	ReplacePayload(ctx context.Context, id string, old, payload json.RawMessage) error
+}
+
+// RefreshTokenRepository stores refresh tokens by their hash in the tenant
+// of the context
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token auth.RefreshToken) error
+	// FindByHash returns apperrors.ErrNotFound if there is no such token
+	FindByHash(ctx context.Context, hash string) (auth.RefreshToken, error)
+	// Rotate marks a token that is neither rotated nor revoked as rotated at
+	// the given time. It returns apperrors.ErrPreconditionFailed otherwise,
+	// so only one of concurrent rotations succeeds.
+	Rotate(ctx context.Context, hash string, rotatedAt time.Time) error
+	// RevokeFamily revokes the tokens of a family that are not revoked yet
+	RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	// RevokeUser revokes the tokens of a user that are not revoked yet
+	RevokeUser(ctx context.Context, userID string, revokedAt time.Time) error
+	// FindByUserID returns the tokens of a user, oldest first
+	FindByUserID(ctx context.Context, userID string) ([]auth.RefreshToken, error)
+	// DeleteByUserID deletes the tokens of a user, which revokes them for
+	// good
+	DeleteByUserID(ctx context.Context, userID string) error
+	// Purge deletes the tokens that expired before the given time, in every
+	// tenant
+	Purge(ctx context.Context, expiredBefore time.Time) (int64, error)
+}
+
+// Transactor runs fn as one unit of work. Repositories called with the
+// context passed to fn take part in it, and all their writes are rolled
+// back if fn returns an error. Nested calls join the outer unit of work.
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	// Atomic reports whether writes are rolled back. It is false for a
+	// backend running without transactions, whose writes are kept when fn
+	// fails.
+	Atomic() bool
 }
 
 // add other repositories interfaces here
 type Repository struct {
-	User UserRepository
+	User         UserRepository
+	History      HistoryRepository
+	Outbox       OutboxRepository
+	RefreshToken RefreshTokenRepository
+	Transactor   Transactor
 	//add other repositories here
 }
@@ -0,0 +1,188 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/history"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/internal/storage"
+	"testing"
+	"time"
+)
+
+// HistoryRepositoryFactory returns an empty repository for a single subtest.
+type HistoryRepositoryFactory func(t *testing.T) storage.HistoryRepository
+
+// RunHistoryRepositoryTests checks the storage.HistoryRepository contract.
+func RunHistoryRepositoryTests(t *testing.T, newRepository HistoryRepositoryFactory) {
+	t.Run("FindByUserID", func(t *testing.T) {
+		testHistoryFindByUserID(t, newRepository(t))
+	})
+	t.Run("Anonymize", func(t *testing.T) {
+		testHistoryAnonymize(t, newRepository(t))
+	})
+	t.Run("StreamAndReplaceChanges", func(t *testing.T) {
+		testHistoryStreamAndReplaceChanges(t, newRepository(t))
+	})
+}
+
+func testHistoryFindByUserID(t *testing.T, repo storage.HistoryRepository) {
+	ctx := tenantContext()
+	records := []history.Record{
+		{UserID: "a", Actor: "admin", Action: history.ActionCreated, Timestamp: epoch,
+			Changes: []history.Change{{Field: "username", After: "alice"}, {Field: "password"}}},
+		{UserID: "b", Actor: "admin", Action: history.ActionCreated, Timestamp: epoch.Add(time.Second)},
+		{UserID: "a", Actor: "support", Action: history.ActionUpdated, Timestamp: epoch.Add(2 * time.Second),
+			Changes: []history.Change{{Field: "email", Before: "old@example.com", After: "new@example.com"}}},
+		{UserID: "a", Actor: "support", Action: history.ActionDeleted, Timestamp: epoch.Add(3 * time.Second)},
+	}
+	for _, record := range records {
+		if err := repo.Create(ctx, record); err != nil {
+			t.Fatalf("Create: unexpected error: %v", err)
+		}
+	}
+
+	found, err := repo.FindByUserID(ctx, "a")
+	if err != nil {
+		t.Fatalf("FindByUserID: unexpected error: %v", err)
+	}
+	want := []history.Record{records[0], records[2], records[3]}
+	if len(found) != len(want) {
+		t.Fatalf("FindByUserID: got %d records, want %d", len(found), len(want))
+	}
+	ids := map[string]bool{}
+	for i, record := range found {
+		if record.ID == "" || ids[record.ID] {
+			t.Fatalf("FindByUserID: record %d has missing or duplicate id %q", i, record.ID)
+		}
+		ids[record.ID] = true
+		w := want[i]
+		if record.UserID != w.UserID || record.Actor != w.Actor || record.Action != w.Action ||
+			!record.Timestamp.Equal(w.Timestamp) || len(record.Changes) != len(w.Changes) {
+			t.Fatalf("FindByUserID: record %d is %+v, want %+v", i, record, w)
+		}
+		for j, change := range record.Changes {
+			if change != w.Changes[j] {
+				t.Fatalf("FindByUserID: change %d of record %d is %+v, want %+v", j, i, change, w.Changes[j])
+			}
+		}
+	}
+
+	// records stay in the tenant they were stored in
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	found, err = repo.FindByUserID(other, "a")
+	if err != nil {
+		t.Fatalf("FindByUserID from another tenant: unexpected error: %v", err)
+	}
+	if len(found) != 0 {
+		t.Fatalf("FindByUserID from another tenant: got %d records", len(found))
+	}
+	if _, err := repo.FindByUserID(context.Background(), "a"); !errors.Is(err, storage.ErrMissingTenant) {
+		t.Fatalf("FindByUserID without tenant: got error %v, want %v", err, storage.ErrMissingTenant)
+	}
+
+	found, err = repo.FindByUserID(ctx, "unknown")
+	if err != nil {
+		t.Fatalf("FindByUserID of unknown user: unexpected error: %v", err)
+	}
+	if len(found) != 0 {
+		t.Fatalf("FindByUserID of unknown user: got %d records", len(found))
+	}
+}
+
+func testHistoryAnonymize(t *testing.T, repo storage.HistoryRepository) {
+	ctx := tenantContext()
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	changes := []history.Change{{Field: "username", Before: "alice", After: "bob"}, {Field: "password"}}
+	for _, c := range []context.Context{ctx, other} {
+		for _, userID := range []string{"a", "b"} {
+			record := history.Record{UserID: userID, Actor: "admin", Action: history.ActionUpdated, Timestamp: epoch, Changes: changes}
+			if err := repo.Create(c, record); err != nil {
+				t.Fatalf("Create: unexpected error: %v", err)
+			}
+		}
+		deleted := history.Record{UserID: "a", Actor: "admin", Action: history.ActionDeleted, Timestamp: epoch.Add(time.Second)}
+		if err := repo.Create(c, deleted); err != nil {
+			t.Fatalf("Create: unexpected error: %v", err)
+		}
+	}
+
+	if err := repo.Anonymize(ctx, "a"); err != nil {
+		t.Fatalf("Anonymize: unexpected error: %v", err)
+	}
+	found, err := repo.FindByUserID(ctx, "a")
+	if err != nil {
+		t.Fatalf("FindByUserID: unexpected error: %v", err)
+	}
+	if len(found) != 2 || len(found[0].Changes) != 2 {
+		t.Fatalf("Anonymize: got %+v, want both records and the changed fields", found)
+	}
+	for i, change := range found[0].Changes {
+		if change != (history.Change{Field: changes[i].Field}) {
+			t.Fatalf("Anonymize: change %d is %+v, want only the field", i, change)
+		}
+	}
+
+	// other users and the same user in other tenants keep their values
+	for _, check := range []struct {
+		ctx    context.Context
+		userID string
+	}{{ctx, "b"}, {other, "a"}} {
+		found, err := repo.FindByUserID(check.ctx, check.userID)
+		if err != nil {
+			t.Fatalf("FindByUserID: unexpected error: %v", err)
+		}
+		if len(found) == 0 || found[0].Changes[0] != changes[0] {
+			t.Fatalf("Anonymize changed the records of another user: %+v", found)
+		}
+	}
+}
+
+func testHistoryStreamAndReplaceChanges(t *testing.T, repo storage.HistoryRepository) {
+	ctx := tenantContext()
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	changes := []history.Change{{Field: "email", Before: "old@example.com", After: "new@example.com"}, {Field: "password"}}
+	for i, c := range []context.Context{ctx, other, ctx} {
+		record := history.Record{UserID: "a", Actor: "admin", Action: history.ActionUpdated,
+			Timestamp: epoch.Add(time.Duration(i) * time.Second), Changes: changes}
+		if err := repo.Create(c, record); err != nil {
+			t.Fatalf("Create: unexpected error: %v", err)
+		}
+	}
+
+	var streamed []history.Record
+	err := repo.Stream(ctx, func(record history.Record) error {
+		streamed = append(streamed, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream: unexpected error: %v", err)
+	}
+	if len(streamed) != 2 || !streamed[0].Timestamp.Equal(epoch) || !streamed[1].Timestamp.Equal(epoch.Add(2*time.Second)) {
+		t.Fatalf("Stream: got %+v, want the records 0 and 2 of the tenant", streamed)
+	}
+	stop := errors.New("stop")
+	if err := repo.Stream(ctx, func(history.Record) error { return stop }); !errors.Is(err, stop) {
+		t.Fatalf("Stream: got error %v, want the error of fn", err)
+	}
+
+	replaced := []history.Change{{Field: "email", Before: "sealed old", After: "sealed new"}, {Field: "password"}}
+	if err := repo.ReplaceChanges(ctx, streamed[0].ID, streamed[0].Changes, replaced); err != nil {
+		t.Fatalf("ReplaceChanges: unexpected error: %v", err)
+	}
+	// the changes are not the ones read anymore
+	if err := repo.ReplaceChanges(ctx, streamed[0].ID, streamed[0].Changes, changes); !errors.Is(err, apperrors.ErrPreconditionFailed) {
+		t.Fatalf("ReplaceChanges of changed changes: got error %v, want %v", err, apperrors.ErrPreconditionFailed)
+	}
+	if err := repo.ReplaceChanges(other, streamed[1].ID, streamed[1].Changes, replaced); !errors.Is(err, apperrors.ErrPreconditionFailed) {
+		t.Fatalf("ReplaceChanges from another tenant: got error %v, want %v", err, apperrors.ErrPreconditionFailed)
+	}
+	found, err := repo.FindByUserID(ctx, "a")
+	if err != nil {
+		t.Fatalf("FindByUserID: unexpected error: %v", err)
+	}
+	if len(found) != 2 || found[0].Changes[0] != replaced[0] || found[1].Changes[0] != changes[0] {
+		t.Fatalf("FindByUserID after ReplaceChanges: got %+v, want only the first record replaced", found)
+	}
+}
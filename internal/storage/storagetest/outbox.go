@@ -0,0 +1,195 @@
+package storagetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/outbox"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/internal/storage"
+	"testing"
+	"time"
+)
+
+// OutboxRepositoryFactory returns an empty repository for a single subtest.
+type OutboxRepositoryFactory func(t *testing.T) storage.OutboxRepository
+
+// RunOutboxRepositoryTests checks the storage.OutboxRepository contract.
+func RunOutboxRepositoryTests(t *testing.T, newRepository OutboxRepositoryFactory) {
+	t.Run("FetchAndMarkPublished", func(t *testing.T) {
+		testOutboxFetchAndMarkPublished(t, newRepository(t))
+	})
+	t.Run("FindAndAnonymizeByAggregateID", func(t *testing.T) {
+		testOutboxFindAndAnonymizeByAggregateID(t, newRepository(t))
+	})
+	t.Run("StreamAndReplacePayload", func(t *testing.T) {
+		testOutboxStreamAndReplacePayload(t, newRepository(t))
+	})
+}
+
+func testOutboxFetchAndMarkPublished(t *testing.T, repo storage.OutboxRepository) {
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		event := outbox.Event{
+			Type:        outbox.UserCreated,
+			AggregateID: fmt.Sprintf("user-%d", i),
+			Payload:     json.RawMessage(fmt.Sprintf(`{"n":%d}`, i)),
+			OccurredAt:  epoch.Add(time.Duration(i) * time.Second),
+		}
+		if err := repo.Add(ctx, event); err != nil {
+			t.Fatalf("Add: unexpected error: %v", err)
+		}
+	}
+
+	pending, err := repo.FetchPending(ctx, 2)
+	if err != nil {
+		t.Fatalf("FetchPending: unexpected error: %v", err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("FetchPending: got %d events, want 2", len(pending))
+	}
+	for i, event := range pending {
+		if event.ID == "" || event.AggregateID != fmt.Sprintf("user-%d", i) || event.Type != outbox.UserCreated ||
+			!event.OccurredAt.Equal(epoch.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("FetchPending: event %d is %+v", i, event)
+		}
+		var payload struct{ N int }
+		if err := json.Unmarshal(event.Payload, &payload); err != nil || payload.N != i {
+			t.Fatalf("FetchPending: event %d has payload %s", i, event.Payload)
+		}
+	}
+
+	if err := repo.MarkPublished(ctx, pending[0].ID, epoch); err != nil {
+		t.Fatalf("MarkPublished: unexpected error: %v", err)
+	}
+	pending, err = repo.FetchPending(ctx, 10)
+	if err != nil {
+		t.Fatalf("FetchPending: unexpected error: %v", err)
+	}
+	if len(pending) != 2 || pending[0].AggregateID != "user-1" || pending[1].AggregateID != "user-2" {
+		t.Fatalf("FetchPending after MarkPublished: got %+v", pending)
+	}
+
+	if err := repo.MarkPublished(ctx, "999999", epoch); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("MarkPublished of unknown event: got %v, want ErrNotFound", err)
+	}
+}
+
+func testOutboxFindAndAnonymizeByAggregateID(t *testing.T, repo storage.OutboxRepository) {
+	ctx := tenantContext()
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	events := []struct {
+		tenant      string
+		aggregateID string
+	}{{Tenant, "a"}, {Tenant, "b"}, {OtherTenant, "a"}, {Tenant, "a"}}
+	for i, e := range events {
+		event := outbox.Event{
+			Type:        outbox.UserUpdated,
+			TenantID:    e.tenant,
+			AggregateID: e.aggregateID,
+			Payload:     json.RawMessage(fmt.Sprintf(`{"id":%q,"email":"secret%d@example.com"}`, e.aggregateID, i)),
+			OccurredAt:  epoch.Add(time.Duration(i) * time.Second),
+		}
+		if err := repo.Add(ctx, event); err != nil {
+			t.Fatalf("Add: unexpected error: %v", err)
+		}
+	}
+
+	found, err := repo.FindByAggregateID(ctx, "a")
+	if err != nil {
+		t.Fatalf("FindByAggregateID: unexpected error: %v", err)
+	}
+	if len(found) != 2 || !found[0].OccurredAt.Equal(epoch) || !found[1].OccurredAt.Equal(epoch.Add(3*time.Second)) {
+		t.Fatalf("FindByAggregateID: got %+v, want the events 0 and 3", found)
+	}
+
+	if err := repo.Anonymize(ctx, "a"); err != nil {
+		t.Fatalf("Anonymize: unexpected error: %v", err)
+	}
+	payloadOf := func(ctx context.Context, aggregateID string) []string {
+		t.Helper()
+		found, err := repo.FindByAggregateID(ctx, aggregateID)
+		if err != nil {
+			t.Fatalf("FindByAggregateID: unexpected error: %v", err)
+		}
+		var payloads []string
+		for _, event := range found {
+			var payload map[string]string
+			if err := json.Unmarshal(event.Payload, &payload); err != nil {
+				t.Fatalf("FindByAggregateID: event has payload %s", event.Payload)
+			}
+			payloads = append(payloads, payload["email"])
+		}
+		return payloads
+	}
+	for _, email := range payloadOf(ctx, "a") {
+		if email != "" {
+			t.Fatalf("Anonymize: event still holds %q", email)
+		}
+	}
+	// other aggregates and the same aggregate in other tenants are untouched
+	if emails := payloadOf(ctx, "b"); len(emails) != 1 || emails[0] == "" {
+		t.Fatalf("Anonymize changed another aggregate: %v", emails)
+	}
+	if emails := payloadOf(other, "a"); len(emails) != 1 || emails[0] == "" {
+		t.Fatalf("Anonymize changed another tenant: %v", emails)
+	}
+}
+
+func testOutboxStreamAndReplacePayload(t *testing.T, repo storage.OutboxRepository) {
+	ctx := tenantContext()
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	for i, tenant := range []string{Tenant, OtherTenant, Tenant} {
+		event := outbox.Event{
+			Type:        outbox.UserCreated,
+			TenantID:    tenant,
+			AggregateID: "a",
+			Payload:     json.RawMessage(fmt.Sprintf(`"payload %d"`, i)),
+			OccurredAt:  epoch.Add(time.Duration(i) * time.Second),
+		}
+		if err := repo.Add(ctx, event); err != nil {
+			t.Fatalf("Add: unexpected error: %v", err)
+		}
+	}
+
+	var streamed []outbox.Event
+	err := repo.Stream(ctx, func(event outbox.Event) error {
+		streamed = append(streamed, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream: unexpected error: %v", err)
+	}
+	if len(streamed) != 2 || !streamed[0].OccurredAt.Equal(epoch) || !streamed[1].OccurredAt.Equal(epoch.Add(2*time.Second)) {
+		t.Fatalf("Stream: got %+v, want the events 0 and 2 of the tenant", streamed)
+	}
+
+	replaced := json.RawMessage(`"sealed"`)
+	if err := repo.ReplacePayload(ctx, streamed[0].ID, streamed[0].Payload, replaced); err != nil {
+		t.Fatalf("ReplacePayload: unexpected error: %v", err)
+	}
+	// the payload is not the one read anymore
+	if err := repo.ReplacePayload(ctx, streamed[0].ID, streamed[0].Payload, replaced); !errors.Is(err, apperrors.ErrPreconditionFailed) {
+		t.Fatalf("ReplacePayload of a changed payload: got error %v, want %v", err, apperrors.ErrPreconditionFailed)
+	}
+	if err := repo.ReplacePayload(other, streamed[1].ID, streamed[1].Payload, replaced); !errors.Is(err, apperrors.ErrPreconditionFailed) {
+		t.Fatalf("ReplacePayload from another tenant: got error %v, want %v", err, apperrors.ErrPreconditionFailed)
+	}
+	found, err := repo.FindByAggregateID(ctx, "a")
+	if err != nil {
+		t.Fatalf("FindByAggregateID: unexpected error: %v", err)
+	}
+	var payloads []string
+	for _, event := range found {
+		var payload string
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			t.Fatalf("FindByAggregateID: event has payload %s", event.Payload)
+		}
+		payloads = append(payloads, payload)
+	}
+	if len(payloads) != 2 || payloads[0] != "sealed" || payloads[1] != "payload 2" {
+		t.Fatalf("FindByAggregateID after ReplacePayload: got %v, want only the first payload replaced", payloads)
+	}
+}
@@ -0,0 +1,209 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/auth"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/internal/storage"
+	"testing"
+	"time"
+)
+
+// RefreshTokenRepositoryFactory returns an empty repository for a single subtest.
+type RefreshTokenRepositoryFactory func(t *testing.T) storage.RefreshTokenRepository
+
+// RunRefreshTokenRepositoryTests checks the storage.RefreshTokenRepository contract.
+func RunRefreshTokenRepositoryTests(t *testing.T, newRepository RefreshTokenRepositoryFactory) {
+	t.Run("CreateAndFindByHash", func(t *testing.T) {
+		testRefreshTokenCreateAndFindByHash(t, newRepository(t))
+	})
+	t.Run("Rotate", func(t *testing.T) {
+		testRefreshTokenRotate(t, newRepository(t))
+	})
+	t.Run("Revoke", func(t *testing.T) {
+		testRefreshTokenRevoke(t, newRepository(t))
+	})
+	t.Run("Purge", func(t *testing.T) {
+		testRefreshTokenPurge(t, newRepository(t))
+	})
+	t.Run("ByUserID", func(t *testing.T) {
+		testRefreshTokenByUserID(t, newRepository(t))
+	})
+}
+
+func newRefreshToken(hash, userID, familyID string) auth.RefreshToken {
+	return auth.RefreshToken{
+		Hash:      hash,
+		UserID:    userID,
+		FamilyID:  familyID,
+		IssuedAt:  epoch,
+		ExpiresAt: epoch.Add(time.Hour),
+	}
+}
+
+func mustCreateRefreshToken(t *testing.T, ctx context.Context, repo storage.RefreshTokenRepository, token auth.RefreshToken) {
+	t.Helper()
+	if err := repo.Create(ctx, token); err != nil {
+		t.Fatalf("Create: unexpected error: %v", err)
+	}
+}
+
+func mustFindRefreshToken(t *testing.T, ctx context.Context, repo storage.RefreshTokenRepository, hash string) auth.RefreshToken {
+	t.Helper()
+	token, err := repo.FindByHash(ctx, hash)
+	if err != nil {
+		t.Fatalf("FindByHash(%s): unexpected error: %v", hash, err)
+	}
+	return token
+}
+
+func testRefreshTokenCreateAndFindByHash(t *testing.T, repo storage.RefreshTokenRepository) {
+	ctx := tenantContext()
+	want := newRefreshToken("h1", "u1", "f1")
+	mustCreateRefreshToken(t, ctx, repo, want)
+
+	got := mustFindRefreshToken(t, ctx, repo, "h1")
+	if got.Hash != want.Hash || got.TenantID != Tenant || got.UserID != want.UserID || got.FamilyID != want.FamilyID ||
+		!got.IssuedAt.Equal(want.IssuedAt) || !got.ExpiresAt.Equal(want.ExpiresAt) ||
+		got.RotatedAt != nil || got.RevokedAt != nil {
+		t.Fatalf("FindByHash: got %+v, want %+v", got, want)
+	}
+
+	if err := repo.Create(ctx, want); !errors.Is(err, apperrors.ErrConflict) {
+		t.Fatalf("Create of a duplicate hash: got %v, want ErrConflict", err)
+	}
+	if _, err := repo.FindByHash(ctx, "unknown"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindByHash of unknown hash: got %v, want ErrNotFound", err)
+	}
+	// tokens stay in the tenant they were stored in
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	if _, err := repo.FindByHash(other, "h1"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindByHash from another tenant: got %v, want ErrNotFound", err)
+	}
+	if _, err := repo.FindByHash(context.Background(), "h1"); !errors.Is(err, storage.ErrMissingTenant) {
+		t.Fatalf("FindByHash without tenant: got error %v, want %v", err, storage.ErrMissingTenant)
+	}
+}
+
+func testRefreshTokenRotate(t *testing.T, repo storage.RefreshTokenRepository) {
+	ctx := tenantContext()
+	mustCreateRefreshToken(t, ctx, repo, newRefreshToken("h1", "u1", "f1"))
+	mustCreateRefreshToken(t, ctx, repo, newRefreshToken("h2", "u1", "f1"))
+
+	rotatedAt := epoch.Add(time.Minute)
+	if err := repo.Rotate(ctx, "h1", rotatedAt); err != nil {
+		t.Fatalf("Rotate: unexpected error: %v", err)
+	}
+	if got := mustFindRefreshToken(t, ctx, repo, "h1"); got.RotatedAt == nil || !got.RotatedAt.Equal(rotatedAt) {
+		t.Fatalf("Rotate: got rotated at %v, want %v", got.RotatedAt, rotatedAt)
+	}
+	if err := repo.Rotate(ctx, "h1", rotatedAt); !errors.Is(err, apperrors.ErrPreconditionFailed) {
+		t.Fatalf("Rotate of a rotated token: got %v, want ErrPreconditionFailed", err)
+	}
+
+	if err := repo.RevokeFamily(ctx, "f1", rotatedAt); err != nil {
+		t.Fatalf("RevokeFamily: unexpected error: %v", err)
+	}
+	if err := repo.Rotate(ctx, "h2", rotatedAt); !errors.Is(err, apperrors.ErrPreconditionFailed) {
+		t.Fatalf("Rotate of a revoked token: got %v, want ErrPreconditionFailed", err)
+	}
+	if err := repo.Rotate(ctx, "unknown", rotatedAt); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Rotate of unknown hash: got %v, want ErrNotFound", err)
+	}
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	if err := repo.Rotate(other, "h2", rotatedAt); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Rotate from another tenant: got %v, want ErrNotFound", err)
+	}
+}
+
+func testRefreshTokenRevoke(t *testing.T, repo storage.RefreshTokenRepository) {
+	ctx := tenantContext()
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	mustCreateRefreshToken(t, ctx, repo, newRefreshToken("h1", "u1", "f1"))
+	mustCreateRefreshToken(t, ctx, repo, newRefreshToken("h2", "u1", "f1"))
+	mustCreateRefreshToken(t, ctx, repo, newRefreshToken("h3", "u1", "f2"))
+	mustCreateRefreshToken(t, ctx, repo, newRefreshToken("h4", "u2", "f3"))
+	mustCreateRefreshToken(t, other, repo, newRefreshToken("h5", "u1", "f1"))
+
+	revoked := func(ctx context.Context, hash string) bool {
+		t.Helper()
+		return mustFindRefreshToken(t, ctx, repo, hash).RevokedAt != nil
+	}
+
+	first := epoch.Add(time.Minute)
+	if err := repo.RevokeFamily(ctx, "f1", first); err != nil {
+		t.Fatalf("RevokeFamily: unexpected error: %v", err)
+	}
+	if !revoked(ctx, "h1") || !revoked(ctx, "h2") || revoked(ctx, "h3") || revoked(ctx, "h4") || revoked(other, "h5") {
+		t.Fatalf("RevokeFamily revoked the wrong tokens")
+	}
+
+	second := epoch.Add(2 * time.Minute)
+	if err := repo.RevokeUser(ctx, "u1", second); err != nil {
+		t.Fatalf("RevokeUser: unexpected error: %v", err)
+	}
+	if !revoked(ctx, "h3") || revoked(ctx, "h4") || revoked(other, "h5") {
+		t.Fatalf("RevokeUser revoked the wrong tokens")
+	}
+	// a revoked token keeps the time it was first revoked at
+	if got := mustFindRefreshToken(t, ctx, repo, "h1"); !got.RevokedAt.Equal(first) {
+		t.Fatalf("RevokeUser: token revoked before got revoked at %v, want %v", got.RevokedAt, first)
+	}
+}
+
+func testRefreshTokenPurge(t *testing.T, repo storage.RefreshTokenRepository) {
+	ctx := tenantContext()
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	expired := newRefreshToken("h1", "u1", "f1")
+	expired.ExpiresAt = epoch.Add(time.Minute)
+	mustCreateRefreshToken(t, ctx, repo, expired)
+	expiredElsewhere := newRefreshToken("h2", "u2", "f2")
+	expiredElsewhere.ExpiresAt = epoch.Add(time.Minute)
+	mustCreateRefreshToken(t, other, repo, expiredElsewhere)
+	mustCreateRefreshToken(t, ctx, repo, newRefreshToken("h3", "u1", "f1"))
+
+	purged, err := repo.Purge(context.Background(), epoch.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("Purge: unexpected error: %v", err)
+	}
+	if purged != 2 {
+		t.Fatalf("Purge: purged %d tokens, want 2", purged)
+	}
+	if _, err := repo.FindByHash(ctx, "h1"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindByHash of a purged token: got %v, want ErrNotFound", err)
+	}
+	mustFindRefreshToken(t, ctx, repo, "h3")
+}
+
+func testRefreshTokenByUserID(t *testing.T, repo storage.RefreshTokenRepository) {
+	ctx := tenantContext()
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	later := newRefreshToken("h1", "u1", "f1")
+	later.IssuedAt = epoch.Add(time.Minute)
+	mustCreateRefreshToken(t, ctx, repo, later)
+	mustCreateRefreshToken(t, ctx, repo, newRefreshToken("h2", "u1", "f1"))
+	mustCreateRefreshToken(t, ctx, repo, newRefreshToken("h3", "u2", "f2"))
+	mustCreateRefreshToken(t, other, repo, newRefreshToken("h4", "u1", "f3"))
+
+	tokens, err := repo.FindByUserID(ctx, "u1")
+	if err != nil {
+		t.Fatalf("FindByUserID: unexpected error: %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].Hash != "h2" || tokens[1].Hash != "h1" || !tokens[1].IssuedAt.Equal(later.IssuedAt) {
+		t.Fatalf("FindByUserID: got %+v, want h2 and h1", tokens)
+	}
+
+	if err := repo.DeleteByUserID(ctx, "u1"); err != nil {
+		t.Fatalf("DeleteByUserID: unexpected error: %v", err)
+	}
+	if tokens, err := repo.FindByUserID(ctx, "u1"); err != nil || len(tokens) != 0 {
+		t.Fatalf("FindByUserID after DeleteByUserID: got %+v, %v, want none", tokens, err)
+	}
+	if _, err := repo.FindByHash(ctx, "h1"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindByHash of a deleted token: got %v, want ErrNotFound", err)
+	}
+	mustFindRefreshToken(t, ctx, repo, "h3")
+	mustFindRefreshToken(t, other, repo, "h4")
+}
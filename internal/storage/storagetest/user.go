@@ -0,0 +1,912 @@
+// Package storagetest is a conformance suite for storage implementations.
+// Every backend and decorator runs the same behavioral spec from its own
+// _test.go file, such as memory/user/user_test.go:
+//
+//	func TestUserRepository(t *testing.T) {
+//		storagetest.RunUserRepositoryTests(t, func(t *testing.T) storage.UserRepository {
+//			return user.NewUserRepository(logging.GetLogger())
+//		})
+//	}
+//
+// The postgres and mongodb suites need a server and are skipped unless
+// POSTGRES_TEST_DSN or MONGODB_TEST_URI points to one.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-api-go/internal/apperrors"
+	"rest-api-go/internal/entities/user"
+	"rest-api-go/internal/requestctx"
+	"rest-api-go/internal/storage"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserRepositoryFactory returns an empty repository for a single subtest.
+// Backends that share a database should clean it up with t.Cleanup.
+type UserRepositoryFactory func(t *testing.T) storage.UserRepository
+
+// ForeignID is not in the format of any id strategy, repositories must
+// still store and find it because ids are the business of the service.
+const ForeignID = "not-a-generated-id!"
+
+// Tenant scopes the context of every subtest, OtherTenant checks that
+// nothing leaks out of it
+const (
+	Tenant      = "tenant-a"
+	OtherTenant = "tenant-b"
+)
+
+func tenantContext() context.Context {
+	return requestctx.WithTenant(context.Background(), Tenant)
+}
+
+// Option adapts the suite to a decorator that deliberately departs from
+// the behavior of the backends
+type Option func(*options)
+
+type options struct {
+	unsortable     map[string]bool
+	encryptsFields bool
+}
+
+// Unsortable expects FindAll to reject sorting by any of fields with a bad
+// request instead of returning users
+func Unsortable(fields ...string) Option {
+	return func(o *options) {
+		for _, field := range fields {
+			o.unsortable[field] = true
+		}
+	}
+}
+
+// EncryptsFields expects the repository to own user.EncryptedFields: it
+// ignores the value callers set and returns users with it empty and their
+// personal fields opened
+func EncryptsFields() Option {
+	return func(o *options) {
+		o.encryptsFields = true
+	}
+}
+
+// RunUserRepositoryTests checks the full storage.UserRepository contract.
+func RunUserRepositoryTests(t *testing.T, newRepository UserRepositoryFactory, opts ...Option) {
+	o := options{unsortable: map[string]bool{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo storage.UserRepository)
+	}{
+		{"CreateAndFindOne", testCreateAndFindOne},
+		{"CreateMany", testCreateMany},
+		{"FindAll", testFindAll},
+		{"Pagination", testPagination},
+		{"Filters", testFilters},
+		{"Sort", func(t *testing.T, repo storage.UserRepository) { testSort(t, repo, o) }},
+		{"Stream", testStream},
+		{"Update", testUpdate},
+		{"PartialUpdate", testPartialUpdate},
+		{"Delete", testDelete},
+		{"FindOneWithDeleted", testFindOneWithDeleted},
+		{"RestoreAndPurge", testRestoreAndPurge},
+		{"NotFound", testNotFound},
+		{"AnyID", testAnyID},
+		{"UniqueFields", testUniqueFields},
+		{"Versioning", testVersioning},
+		{"ConcurrentWriters", testConcurrentWriters},
+		{"Tenancy", testTenancy},
+		{"Erase", testErase},
+		{"EncryptedFields", func(t *testing.T, repo storage.UserRepository) { testEncryptedFields(t, repo, o) }},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newRepository(t))
+		})
+	}
+}
+
+// epoch is the creation time of newUser(0); later users are a second apart
+var epoch = time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+
+// deletedAt is the deletion time the cases pass to Delete, restoredAt the
+// time they pass to Restore
+var (
+	deletedAt  = epoch.Add(time.Hour)
+	restoredAt = epoch.Add(2 * time.Hour)
+)
+
+// newUser returns a user with a new random id
+func newUser(n int) user.User {
+	return user.User{
+		ID:           uuid.NewString(),
+		Username:     fmt.Sprintf("user%d", n),
+		Email:        fmt.Sprintf("user%d@example.com", n),
+		PasswordHash: fmt.Sprintf("hash%d", n),
+		CreatedAt:    epoch.Add(time.Duration(n) * time.Second),
+		UpdatedAt:    epoch.Add(time.Duration(n) * time.Second),
+	}
+}
+
+func mustCreate(t *testing.T, repo storage.UserRepository, u user.User) user.User {
+	t.Helper()
+	id, err := repo.Create(tenantContext(), u)
+	if err != nil {
+		t.Fatalf("Create: unexpected error: %v", err)
+	}
+	if id != u.ID {
+		t.Fatalf("Create: returned id %q, want %q", id, u.ID)
+	}
+	return u
+}
+
+func assertUser(t *testing.T, got, want user.User) {
+	t.Helper()
+	if got.ID != want.ID || got.Username != want.Username ||
+		got.Email != want.Email || got.PasswordHash != want.PasswordHash ||
+		!got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Fatalf("got user %+v, want %+v", got, want)
+	}
+}
+
+func testCreateAndFindOne(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	created := mustCreate(t, repo, newUser(1))
+	other := mustCreate(t, repo, newUser(2))
+	if created.ID == other.ID {
+		t.Fatalf("Create: returned duplicate id %s", created.ID)
+	}
+
+	found, err := repo.FindOne(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	assertUser(t, found, created)
+}
+
+func testCreateMany(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	existing := mustCreate(t, repo, newUser(1))
+
+	emailTaken := newUser(3)
+	emailTaken.Email = existing.Email
+	usernameTakenInBatch := newUser(5)
+	usernameTakenInBatch.Username = newUser(2).Username
+	users := []user.User{newUser(2), emailTaken, newUser(4), usernameTakenInBatch}
+
+	items, err := repo.CreateMany(ctx, users)
+	if err != nil {
+		t.Fatalf("CreateMany: unexpected error: %v", err)
+	}
+	if len(items) != len(users) {
+		t.Fatalf("CreateMany: got %d items, want %d", len(items), len(users))
+	}
+	assertConflict(t, "CreateMany", "email", items[1].Error)
+	assertConflict(t, "CreateMany", "username", items[3].Error)
+	for _, i := range []int{0, 2} {
+		if items[i].Error != nil || items[i].ID == "" {
+			t.Fatalf("CreateMany: item %d is %+v, want a created user", i, items[i])
+		}
+		found, err := repo.FindOne(ctx, items[i].ID)
+		if err != nil {
+			t.Fatalf("FindOne: unexpected error: %v", err)
+		}
+		want := users[i]
+		want.ID = items[i].ID
+		assertUser(t, found, want)
+		if found.Version != 1 {
+			t.Fatalf("CreateMany: item %d has version %d, want 1", i, found.Version)
+		}
+	}
+	if got := len(findAll(t, repo)); got != 3 {
+		t.Fatalf("CreateMany: got %d users, want 3", got)
+	}
+}
+
+func testFindAll(t *testing.T, repo storage.UserRepository) {
+	users := findAll(t, repo)
+	if len(users) != 0 {
+		t.Fatalf("FindAll: got %d users from an empty repository", len(users))
+	}
+
+	want := map[string]user.User{}
+	for i := 0; i < 3; i++ {
+		u := mustCreate(t, repo, newUser(i))
+		want[u.ID] = u
+	}
+
+	users = findAll(t, repo)
+	if len(users) != len(want) {
+		t.Fatalf("FindAll: got %d users, want %d", len(users), len(want))
+	}
+	for _, u := range users {
+		assertUser(t, u, want[u.ID])
+	}
+}
+
+func testPagination(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	for i := 0; i < 5; i++ {
+		mustCreate(t, repo, newUser(i))
+	}
+
+	var sizes []int
+	seen := map[string]bool{}
+	query := user.ListQuery{Limit: 2}
+	for {
+		page, err := repo.FindAll(ctx, query)
+		if err != nil {
+			t.Fatalf("FindAll: unexpected error: %v", err)
+		}
+		sizes = append(sizes, len(page.Users))
+		for _, u := range page.Users {
+			if seen[u.ID] {
+				t.Fatalf("FindAll: user %s returned on two pages", u.ID)
+			}
+			seen[u.ID] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	if fmt.Sprint(sizes) != "[2 2 1]" {
+		t.Fatalf("FindAll: got page sizes %v, want [2 2 1]", sizes)
+	}
+
+	// an exactly full last page must not advertise another one
+	page, err := repo.FindAll(ctx, user.ListQuery{Limit: 5})
+	if err != nil {
+		t.Fatalf("FindAll: unexpected error: %v", err)
+	}
+	if len(page.Users) != 5 || page.NextCursor != "" {
+		t.Fatalf("FindAll: got %d users and cursor %q, want 5 users and no cursor",
+			len(page.Users), page.NextCursor)
+	}
+
+	_, err = repo.FindAll(ctx, user.ListQuery{Limit: 2, Cursor: "%%%"})
+	var appErr *apperrors.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode() != 400 {
+		t.Fatalf("FindAll with invalid cursor: got error %v, want a bad request", err)
+	}
+}
+
+func testFilters(t *testing.T, repo storage.UserRepository) {
+	names := []string{"alice", "alina", "al_x", "bob"}
+	created := map[string]user.User{}
+	for i, name := range names {
+		u := newUser(i)
+		u.Username = name
+		u.Email = name + "@example.com"
+		created[name] = mustCreate(t, repo, u)
+	}
+
+	tests := []struct {
+		name  string
+		query user.ListQuery
+		want  []string
+	}{
+		{"email", user.ListQuery{Email: "bob@example.com"}, []string{"bob"}},
+		{"unknown email", user.ListQuery{Email: "nobody@example.com"}, nil},
+		{"username", user.ListQuery{Username: "alice"}, []string{"alice"}},
+		{"username is exact", user.ListQuery{Username: "ali"}, nil},
+		{"username prefix", user.ListQuery{UsernamePrefix: "ali"}, []string{"alice", "alina"}},
+		{"prefix is not a pattern", user.ListQuery{UsernamePrefix: "al_"}, []string{"al_x"}},
+		{"created after", user.ListQuery{CreatedAfter: epoch.Add(time.Second)}, []string{"al_x", "bob"}},
+		{"combined", user.ListQuery{UsernamePrefix: "al", CreatedAfter: epoch}, []string{"alina", "al_x"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, u := range findAllWith(t, repo, tt.query) {
+			assertUser(t, u, created[u.Username])
+			got = append(got, u.Username)
+		}
+		sort.Strings(got)
+		want := append([]string(nil), tt.want...)
+		sort.Strings(want)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("FindAll by %s: got %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func testSort(t *testing.T, repo storage.UserRepository, o options) {
+	// usernames descend while creation times ascend, and two users share
+	// a creation time so the ID tiebreaker is exercised across pages
+	names := []string{"eve", "dave", "carol", "bob", "alice"}
+	for i, name := range names {
+		u := newUser(i)
+		u.Username = name
+		u.Email = name + "@example.com"
+		if i == 4 {
+			u.CreatedAt = epoch.Add(3 * time.Second)
+		}
+		mustCreate(t, repo, u)
+	}
+
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"username", []string{"alice", "bob", "carol", "dave", "eve"}},
+		{"-email", []string{"eve", "dave", "carol", "bob", "alice"}},
+		{"created_at,username", []string{"eve", "dave", "carol", "alice", "bob"}},
+		{"-created_at,-username", []string{"bob", "alice", "carol", "dave", "eve"}},
+	}
+	for _, tt := range tests {
+		fields, err := user.ParseSort(tt.sort)
+		if err != nil {
+			t.Fatalf("ParseSort(%q): unexpected error: %v", tt.sort, err)
+		}
+		if unsortable(fields, o) {
+			_, err := repo.FindAll(tenantContext(), user.ListQuery{Limit: 2, Sort: fields})
+			var appErr *apperrors.AppError
+			if !errors.As(err, &appErr) || appErr.StatusCode() != http.StatusBadRequest {
+				t.Errorf("FindAll sorted by %s: got error %v, want a bad request", tt.sort, err)
+			}
+			continue
+		}
+		var got []string
+		for _, u := range findAllWith(t, repo, user.ListQuery{Sort: fields}) {
+			got = append(got, u.Username)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("FindAll sorted by %s: got %v, want %v", tt.sort, got, tt.want)
+		}
+	}
+}
+
+func unsortable(fields []user.SortField, o options) bool {
+	for _, field := range fields {
+		if o.unsortable[field.Field] {
+			return true
+		}
+	}
+	return false
+}
+
+// findAll walks every page with a small limit so that all tests exercise paging.
+func testStream(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	var want []user.User
+	for n := 1; n <= 4; n++ {
+		want = append(want, mustCreate(t, repo, newUser(n)))
+	}
+	if err := repo.Delete(ctx, want[2].ID, 0, deletedAt); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+	want = append(want[:2], want[3])
+	sort.Slice(want, func(i, j int) bool { return want[i].ID < want[j].ID })
+
+	var got []user.User
+	err := repo.Stream(ctx, func(u user.User) error {
+		got = append(got, u)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream: unexpected error: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Stream: got %d users, want %d", len(got), len(want))
+	}
+	for i := range want {
+		assertUser(t, got[i], want[i])
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = repo.Stream(ctx, func(u user.User) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("Stream: got error %v after %d calls, want %v after 1", err, calls, stop)
+	}
+}
+
+func findAll(t *testing.T, repo storage.UserRepository) []user.User {
+	t.Helper()
+	return findAllWith(t, repo, user.ListQuery{})
+}
+
+func findAllWith(t *testing.T, repo storage.UserRepository, query user.ListQuery) []user.User {
+	t.Helper()
+	var users []user.User
+	query.Limit = 2
+	for {
+		page, err := repo.FindAll(tenantContext(), query)
+		if err != nil {
+			t.Fatalf("FindAll: unexpected error: %v", err)
+		}
+		users = append(users, page.Users...)
+		if page.NextCursor == "" {
+			return users
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func testUpdate(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	created := mustCreate(t, repo, newUser(1))
+
+	updated := user.User{
+		ID:           created.ID,
+		Username:     "renamed",
+		Email:        "renamed@example.com",
+		PasswordHash: "new-hash",
+		CreatedAt:    created.CreatedAt,
+		UpdatedAt:    created.UpdatedAt.Add(time.Hour),
+	}
+	if err := repo.Update(ctx, updated); err != nil {
+		t.Fatalf("Update: unexpected error: %v", err)
+	}
+
+	found, err := repo.FindOne(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	assertUser(t, found, updated)
+}
+
+func testPartialUpdate(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	created := mustCreate(t, repo, newUser(1))
+
+	// empty fields must be left untouched
+	if err := repo.Update(ctx, user.User{ID: created.ID, Username: "renamed"}); err != nil {
+		t.Fatalf("Update: unexpected error: %v", err)
+	}
+	want := created
+	want.Username = "renamed"
+
+	found, err := repo.FindOne(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	assertUser(t, found, want)
+
+	if err := repo.Update(ctx, user.User{ID: created.ID, PasswordHash: "new-hash"}); err != nil {
+		t.Fatalf("Update: unexpected error: %v", err)
+	}
+	want.PasswordHash = "new-hash"
+
+	found, err = repo.FindOne(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	assertUser(t, found, want)
+}
+
+func testDelete(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	created := mustCreate(t, repo, newUser(1))
+	kept := mustCreate(t, repo, newUser(2))
+
+	if err := repo.Delete(ctx, created.ID, 0, deletedAt); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+	if _, err := repo.FindOne(ctx, created.ID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindOne after Delete: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	if err := repo.Delete(ctx, created.ID, 0, deletedAt); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("second Delete: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+
+	found, err := repo.FindOne(ctx, kept.ID)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	assertUser(t, found, kept)
+}
+
+func testFindOneWithDeleted(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	active := mustCreate(t, repo, newUser(1))
+	deleted := mustCreate(t, repo, newUser(2))
+	if err := repo.Delete(ctx, deleted.ID, 0, deletedAt); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+
+	found, err := repo.FindOneWithDeleted(ctx, active.ID)
+	if err != nil {
+		t.Fatalf("FindOneWithDeleted of an active user: unexpected error: %v", err)
+	}
+	assertUser(t, found, active)
+	if found.DeletedAt != nil {
+		t.Fatalf("FindOneWithDeleted of an active user: got deleted at %v", found.DeletedAt)
+	}
+	found, err = repo.FindOneWithDeleted(ctx, deleted.ID)
+	if err != nil {
+		t.Fatalf("FindOneWithDeleted of a deleted user: unexpected error: %v", err)
+	}
+	if found.ID != deleted.ID || found.Email != deleted.Email || found.DeletedAt == nil ||
+		!found.DeletedAt.Equal(deletedAt) || !found.UpdatedAt.Equal(deletedAt) {
+		t.Fatalf("FindOneWithDeleted of a deleted user: got %+v, want it deleted and updated at %v", found, deletedAt)
+	}
+
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	if _, err := repo.FindOneWithDeleted(other, deleted.ID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindOneWithDeleted from another tenant: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	if err := repo.Erase(ctx, deleted.ID); err != nil {
+		t.Fatalf("Erase: unexpected error: %v", err)
+	}
+	if _, err := repo.FindOneWithDeleted(ctx, deleted.ID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindOneWithDeleted of an erased user: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+}
+
+func testRestoreAndPurge(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	deleted := mustCreate(t, repo, newUser(1))
+	kept := mustCreate(t, repo, newUser(2))
+
+	if err := repo.Restore(ctx, kept.ID, restoredAt); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Restore of an active user: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	if err := repo.Delete(ctx, deleted.ID, 0, deletedAt); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+	if users := findAll(t, repo); len(users) != 1 || users[0].ID != kept.ID {
+		t.Fatalf("FindAll after Delete: got %+v, want only %s", users, kept.ID)
+	}
+	if err := repo.Update(ctx, user.User{ID: deleted.ID, Username: "ghost"}); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Update of a deleted user: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+
+	if err := repo.Restore(ctx, deleted.ID, restoredAt); err != nil {
+		t.Fatalf("Restore: unexpected error: %v", err)
+	}
+	found, err := repo.FindOne(ctx, deleted.ID)
+	if err != nil {
+		t.Fatalf("FindOne after Restore: unexpected error: %v", err)
+	}
+	// the restore bumps updated_at, so a conditional GET sees the change
+	deleted.UpdatedAt = restoredAt
+	assertUser(t, found, deleted)
+	if found.DeletedAt != nil {
+		t.Fatalf("FindOne after Restore: deleted_at is still set to %v", found.DeletedAt)
+	}
+
+	// purging only considers users deleted before the cutoff
+	if err := repo.Delete(ctx, deleted.ID, 0, deletedAt); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+	purged, err := repo.Purge(ctx, deletedAt.Add(-time.Minute))
+	if err != nil || purged != 0 {
+		t.Fatalf("Purge before deletion: got %d, %v, want 0, nil", purged, err)
+	}
+	purged, err = repo.Purge(ctx, deletedAt.Add(time.Minute))
+	if err != nil || purged != 1 {
+		t.Fatalf("Purge after deletion: got %d, %v, want 1, nil", purged, err)
+	}
+	if err := repo.Restore(ctx, deleted.ID, restoredAt); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Restore after Purge: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	if _, err := repo.FindOne(ctx, kept.ID); err != nil {
+		t.Fatalf("FindOne of an active user after Purge: unexpected error: %v", err)
+	}
+}
+
+func testNotFound(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	// a well-formed id that was never stored
+	created := mustCreate(t, repo, newUser(1))
+	if err := repo.Delete(ctx, created.ID, 0, deletedAt); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+	missing := created.ID
+
+	if _, err := repo.FindOne(ctx, missing); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindOne: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	if err := repo.Update(ctx, user.User{ID: missing, Username: "ghost"}); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Update: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	if err := repo.Delete(ctx, missing, 0, deletedAt); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Delete: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+}
+
+func testAnyID(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	if _, err := repo.FindOne(ctx, ForeignID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindOne of unknown id: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+
+	u := newUser(1)
+	u.ID = ForeignID
+	created := mustCreate(t, repo, u)
+	found, err := repo.FindOne(ctx, ForeignID)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	assertUser(t, found, created)
+
+	duplicate := newUser(2)
+	duplicate.ID = ForeignID
+	_, err = repo.Create(ctx, duplicate)
+	assertConflict(t, "Create", "id", err)
+
+	missing := newUser(3)
+	missing.ID = ""
+	if _, err := repo.Create(ctx, missing); !errors.Is(err, storage.ErrMissingID) {
+		t.Fatalf("Create without id: got error %v, want %v", err, storage.ErrMissingID)
+	}
+}
+
+func testUniqueFields(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	first := mustCreate(t, repo, newUser(1))
+	second := mustCreate(t, repo, newUser(2))
+
+	duplicates := map[string]user.User{
+		"email":    {ID: uuid.NewString(), Username: "other", Email: first.Email, PasswordHash: "hash"},
+		"username": {ID: uuid.NewString(), Username: first.Username, Email: "other@example.com", PasswordHash: "hash"},
+	}
+	for field, dup := range duplicates {
+		_, err := repo.Create(ctx, dup)
+		assertConflict(t, "Create", field, err)
+
+		err = repo.Update(ctx, user.User{ID: second.ID, Email: dup.Email, Username: dup.Username})
+		assertConflict(t, "Update", field, err)
+	}
+
+	// updating a user with its own values is not a conflict
+	if err := repo.Update(ctx, first); err != nil {
+		t.Fatalf("Update with unchanged values: unexpected error: %v", err)
+	}
+}
+
+func testVersioning(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	created := mustCreate(t, repo, newUser(1))
+	assertVersion(t, repo, created.ID, 1)
+
+	// unconditional and matching writes both bump the version
+	if err := repo.Update(ctx, user.User{ID: created.ID, Username: "first"}); err != nil {
+		t.Fatalf("Update: unexpected error: %v", err)
+	}
+	assertVersion(t, repo, created.ID, 2)
+	if err := repo.Update(ctx, user.User{ID: created.ID, Username: "second", Version: 2}); err != nil {
+		t.Fatalf("Update with current version: unexpected error: %v", err)
+	}
+	assertVersion(t, repo, created.ID, 3)
+
+	err := repo.Update(ctx, user.User{ID: created.ID, Username: "stale", Version: 2})
+	if !errors.Is(err, apperrors.ErrPreconditionFailed) {
+		t.Fatalf("Update with stale version: got error %v, want %v", err, apperrors.ErrPreconditionFailed)
+	}
+	if err := repo.Delete(ctx, created.ID, 2, deletedAt); !errors.Is(err, apperrors.ErrPreconditionFailed) {
+		t.Fatalf("Delete with stale version: got error %v, want %v", err, apperrors.ErrPreconditionFailed)
+	}
+	found, err := repo.FindOne(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	if found.Username != "second" {
+		t.Fatalf("stale write changed username to %q", found.Username)
+	}
+
+	if err := repo.Delete(ctx, created.ID, 3, deletedAt); err != nil {
+		t.Fatalf("Delete with current version: unexpected error: %v", err)
+	}
+	// a missing user is reported as such even with a version
+	err = repo.Update(ctx, user.User{ID: created.ID, Username: "ghost", Version: 4})
+	if !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Update of a deleted user: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	if err := repo.Restore(ctx, created.ID, restoredAt); err != nil {
+		t.Fatalf("Restore: unexpected error: %v", err)
+	}
+	assertVersion(t, repo, created.ID, 5)
+}
+
+func assertVersion(t *testing.T, repo storage.UserRepository, id string, want int64) {
+	t.Helper()
+	found, err := repo.FindOne(tenantContext(), id)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	if found.Version != want {
+		t.Fatalf("got version %d, want %d", found.Version, want)
+	}
+}
+
+func assertConflict(t *testing.T, op, field string, err error) {
+	t.Helper()
+	if !errors.Is(err, apperrors.ErrConflict) {
+		t.Fatalf("%s with duplicate %s: got error %v, want %v", op, field, err, apperrors.ErrConflict)
+	}
+	var appErr *apperrors.AppError
+	if errors.As(err, &appErr) && appErr.Field != field {
+		t.Fatalf("%s with duplicate %s: conflict names field %q", op, field, appErr.Field)
+	}
+}
+
+func testConcurrentWriters(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	const writers = 16
+
+	target := mustCreate(t, repo, newUser(0))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*2)
+	for i := 1; i <= writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := repo.Create(ctx, newUser(i)); err != nil {
+				errs <- fmt.Errorf("Create: %w", err)
+			}
+			update := user.User{ID: target.ID, PasswordHash: fmt.Sprintf("hash-from-%d", i)}
+			if err := repo.Update(ctx, update); err != nil {
+				errs <- fmt.Errorf("Update: %w", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent writer: unexpected error: %v", err)
+	}
+
+	users := findAll(t, repo)
+	if len(users) != writers+1 {
+		t.Fatalf("FindAll: got %d users, want %d", len(users), writers+1)
+	}
+	found, err := repo.FindOne(ctx, target.ID)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	if found.Username != target.Username || found.Email != target.Email {
+		t.Fatalf("concurrent password updates changed other fields: %+v", found)
+	}
+}
+
+func testTenancy(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	created := mustCreate(t, repo, newUser(1))
+
+	found, err := repo.FindOne(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("FindOne: unexpected error: %v", err)
+	}
+	if found.TenantID != Tenant {
+		t.Fatalf("FindOne: got tenant %q, want %q", found.TenantID, Tenant)
+	}
+
+	// emails and usernames are only unique within a tenant
+	twin := newUser(1)
+	if _, err := repo.Create(other, twin); err != nil {
+		t.Fatalf("Create of the same user in another tenant: unexpected error: %v", err)
+	}
+
+	if _, err := repo.FindOne(other, created.ID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindOne from another tenant: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	err = repo.Update(other, user.User{ID: created.ID, Username: "intruder", Version: 1})
+	if !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Update from another tenant: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	if err := repo.Delete(other, created.ID, 1, deletedAt); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Delete from another tenant: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	page, err := repo.FindAll(other, user.ListQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("FindAll: unexpected error: %v", err)
+	}
+	if len(page.Users) != 1 || page.Users[0].ID != twin.ID {
+		t.Fatalf("FindAll from another tenant: got %+v, want only %s", page.Users, twin.ID)
+	}
+	var streamed []string
+	if err := repo.Stream(other, func(u user.User) error {
+		streamed = append(streamed, u.ID)
+		return nil
+	}); err != nil {
+		t.Fatalf("Stream: unexpected error: %v", err)
+	}
+	if len(streamed) != 1 || streamed[0] != twin.ID {
+		t.Fatalf("Stream from another tenant: got %v, want only %s", streamed, twin.ID)
+	}
+
+	if err := repo.Delete(ctx, created.ID, 0, deletedAt); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+	if err := repo.Restore(other, created.ID, restoredAt); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Restore from another tenant: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+
+	// a context without a tenant must never reach the users of every tenant
+	none := context.Background()
+	if _, err := repo.FindOne(none, twin.ID); !errors.Is(err, storage.ErrMissingTenant) {
+		t.Fatalf("FindOne without tenant: got error %v, want %v", err, storage.ErrMissingTenant)
+	}
+	if _, err := repo.FindAll(none, user.ListQuery{Limit: 10}); !errors.Is(err, storage.ErrMissingTenant) {
+		t.Fatalf("FindAll without tenant: got error %v, want %v", err, storage.ErrMissingTenant)
+	}
+	if _, err := repo.Create(none, newUser(2)); !errors.Is(err, storage.ErrMissingTenant) {
+		t.Fatalf("Create without tenant: got error %v, want %v", err, storage.ErrMissingTenant)
+	}
+}
+
+func testErase(t *testing.T, repo storage.UserRepository) {
+	ctx := tenantContext()
+	active := mustCreate(t, repo, newUser(1))
+	deleted := mustCreate(t, repo, newUser(2))
+	if err := repo.Delete(ctx, deleted.ID, 0, deletedAt); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+
+	other := requestctx.WithTenant(context.Background(), OtherTenant)
+	if err := repo.Erase(other, active.ID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Erase from another tenant: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+	for _, u := range []user.User{active, deleted} {
+		if err := repo.Erase(ctx, u.ID); err != nil {
+			t.Fatalf("Erase: unexpected error: %v", err)
+		}
+		if err := repo.Restore(ctx, u.ID, restoredAt); !errors.Is(err, apperrors.ErrNotFound) {
+			t.Fatalf("Restore of an erased user: got error %v, want %v", err, apperrors.ErrNotFound)
+		}
+		if err := repo.Erase(ctx, u.ID); !errors.Is(err, apperrors.ErrNotFound) {
+			t.Fatalf("Erase twice: got error %v, want %v", err, apperrors.ErrNotFound)
+		}
+	}
+	if _, err := repo.FindOne(ctx, active.ID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("FindOne of an erased user: got error %v, want %v", err, apperrors.ErrNotFound)
+	}
+
+	// the email and username are free again
+	mustCreate(t, repo, newUser(1))
+}
+
+func testEncryptedFields(t *testing.T, repo storage.UserRepository, o options) {
+	ctx := tenantContext()
+	u := newUser(1)
+	u.EncryptedFields = "sealed-1"
+	created := mustCreate(t, repo, u)
+	assertEncryptedFields := func(want string) {
+		t.Helper()
+		if o.encryptsFields {
+			want = ""
+		}
+		found, err := repo.FindOne(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("FindOne: unexpected error: %v", err)
+		}
+		if found.EncryptedFields != want || found.Email != created.Email {
+			t.Fatalf("FindOne: got encrypted fields %q and email %q, want %q and %q",
+				found.EncryptedFields, found.Email, want, created.Email)
+		}
+		page, err := repo.FindAll(ctx, user.ListQuery{Limit: 10})
+		if err != nil {
+			t.Fatalf("FindAll: unexpected error: %v", err)
+		}
+		if len(page.Users) != 1 || page.Users[0].EncryptedFields != want || page.Users[0].Email != created.Email {
+			t.Fatalf("FindAll: got %+v, want encrypted fields %q", page.Users, want)
+		}
+	}
+	assertEncryptedFields("sealed-1")
+
+	// backends store them as they are and only replace them when set
+	if err := repo.Update(ctx, user.User{ID: created.ID, Username: "renamed"}); err != nil {
+		t.Fatalf("Update: unexpected error: %v", err)
+	}
+	assertEncryptedFields("sealed-1")
+	if err := repo.Update(ctx, user.User{ID: created.ID, EncryptedFields: "sealed-2"}); err != nil {
+		t.Fatalf("Update: unexpected error: %v", err)
+	}
+	assertEncryptedFields("sealed-2")
+}
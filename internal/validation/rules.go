@@ -0,0 +1,161 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+func init() {
+	Register("required", required)
+	Register("email", email)
+	Register("min", minimum)
+	Register("max", maximum)
+	Register("charset", charset)
+	Register("regex", pattern)
+}
+
+// required rejects zero values and blank strings
+func required(value reflect.Value, _ string) (string, error) {
+	if value.IsZero() || value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "" {
+		return "is required", nil
+	}
+	return "", nil
+}
+
+// email accepts a bare address such as jane@example.com, without a name
+func email(value reflect.Value, _ string) (string, error) {
+	s, err := stringOf(value)
+	if err != nil {
+		return "", err
+	}
+	address, err := mail.ParseAddress(s)
+	if err != nil || address.Address != s || !strings.Contains(s[strings.LastIndex(s, "@")+1:], ".") {
+		return "must be a valid email address", nil
+	}
+	return "", nil
+}
+
+// minimum bounds the length of strings in characters, of slices and maps in
+// elements and numbers by value
+func minimum(value reflect.Value, param string) (string, error) {
+	n, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid bound %q", param)
+	}
+	size, unit, err := sizeOf(value)
+	if err != nil {
+		return "", err
+	}
+	if size < n {
+		if unit == "" {
+			return fmt.Sprintf("must be at least %d", n), nil
+		}
+		return fmt.Sprintf("must be at least %d %s long", n, unit), nil
+	}
+	return "", nil
+}
+
+// maximum is the upper bound counterpart of minimum
+func maximum(value reflect.Value, param string) (string, error) {
+	n, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid bound %q", param)
+	}
+	size, unit, err := sizeOf(value)
+	if err != nil {
+		return "", err
+	}
+	if size > n {
+		if unit == "" {
+			return fmt.Sprintf("must be at most %d", n), nil
+		}
+		return fmt.Sprintf("must be at most %d %s long", n, unit), nil
+	}
+	return "", nil
+}
+
+func sizeOf(value reflect.Value) (size int64, unit string, err error) {
+	switch value.Kind() {
+	case reflect.String:
+		return int64(utf8.RuneCountInString(value.String())), "characters", nil
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return int64(value.Len()), "elements", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), "", nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint()), "", nil
+	}
+	return 0, "", fmt.Errorf("cannot measure a %s", value.Type())
+}
+
+// charsets are the character classes of the charset rule, with the
+// description used in its message
+var charsets = map[string]struct {
+	description string
+	allows      func(r rune) bool
+}{
+	"alpha":     {"letters", unicode.IsLetter},
+	"numeric":   {"digits", unicode.IsDigit},
+	"alnum":     {"letters and digits", func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }},
+	"ascii":     {"ASCII", func(r rune) bool { return r <= unicode.MaxASCII }},
+	"printable": {"printable", unicode.IsPrint},
+}
+
+// charset only accepts strings made of the characters of a named class
+func charset(value reflect.Value, param string) (string, error) {
+	class, ok := charsets[param]
+	if !ok {
+		return "", fmt.Errorf("unknown charset %q", param)
+	}
+	s, err := stringOf(value)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range s {
+		if !class.allows(r) {
+			return fmt.Sprintf("must only hold %s characters", class.description), nil
+		}
+	}
+	return "", nil
+}
+
+// patterns caches the compiled expressions of the regex rule
+var patterns sync.Map
+
+// pattern only accepts strings matching a regular expression, which should
+// be anchored to match the whole string
+func pattern(value reflect.Value, param string) (string, error) {
+	var re *regexp.Regexp
+	if cached, ok := patterns.Load(param); ok {
+		re = cached.(*regexp.Regexp)
+	} else {
+		compiled, err := regexp.Compile(param)
+		if err != nil {
+			return "", fmt.Errorf("invalid expression %q", param)
+		}
+		patterns.Store(param, compiled)
+		re = compiled
+	}
+	s, err := stringOf(value)
+	if err != nil {
+		return "", err
+	}
+	if !re.MatchString(s) {
+		return fmt.Sprintf("must match %s", param), nil
+	}
+	return "", nil
+}
+
+func stringOf(value reflect.Value) (string, error) {
+	if value.Kind() != reflect.String {
+		return "", fmt.Errorf("cannot check a %s, only strings", value.Type())
+	}
+	return value.String(), nil
+}
@@ -0,0 +1,155 @@
+// Package validation checks structs against the rules of their validate
+// tags, such as `validate:"required,email,max=254"`. Rules are separated by
+// commas and register themselves by name, so adding one needs no change
+// elsewhere. A regex rule can therefore not hold a comma.
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"rest-api-go/internal/apperrors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Rule checks value against param, the text after = in the tag or "" for a
+// bare rule. It returns the message of the violation, "" when value passes,
+// and an error when param is not valid for the rule.
+type Rule func(value reflect.Value, param string) (violation string, err error)
+
+// OmitEmpty is the pseudo rule that skips the rules of a field holding its
+// zero value, which makes the other rules of optional fields conditional
+const OmitEmpty = "omitempty"
+
+var (
+	mu    sync.RWMutex
+	rules = map[string]Rule{}
+)
+
+// Register makes a rule available under name, replacing any rule
+// registered under it before
+func Register(name string, rule Rule) {
+	mu.Lock()
+	defer mu.Unlock()
+	rules[name] = rule
+}
+
+func lookup(name string) (Rule, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	rule, ok := rules[name]
+	return rule, ok
+}
+
+// Names returns the names of all registered rules, sorted
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type check struct {
+	name  string
+	param string
+}
+
+type field struct {
+	index     int
+	name      string
+	omitEmpty bool
+	checks    []check
+}
+
+// fields caches the parsed tags of every struct type seen
+var fields sync.Map
+
+// Struct checks every exported field of v, a struct or a pointer to one,
+// against the rules of its validate tag. It returns an
+// apperrors.ValidationError listing every violation, named after the JSON
+// names of the fields, or nil when there is none. Fields of nested structs
+// are not checked.
+func Struct(v interface{}) error {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return fmt.Errorf("validation: cannot validate a nil %s", value.Type())
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("validation: cannot validate a %s, only structs", value.Type())
+	}
+
+	var violations []apperrors.FieldError
+	for _, f := range parse(value.Type()) {
+		fieldValue := value.Field(f.index)
+		if f.omitEmpty && fieldValue.IsZero() {
+			continue
+		}
+		for _, c := range f.checks {
+			rule, ok := lookup(c.name)
+			if !ok {
+				return fmt.Errorf("validation: unknown rule %q on %s.%s", c.name, value.Type(), f.name)
+			}
+			violation, err := rule(fieldValue, c.param)
+			if err != nil {
+				return fmt.Errorf("validation: rule %q on %s.%s: %w", c.name, value.Type(), f.name, err)
+			}
+			if violation != "" {
+				violations = append(violations, apperrors.FieldError{Field: f.name, Rule: c.name, Message: violation})
+				// the later rules of a missing field would only repeat it
+				if c.name == "required" {
+					break
+				}
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return apperrors.ValidationError(violations)
+	}
+	return nil
+}
+
+// parse returns the tagged fields of t, parsing them on first use
+func parse(t reflect.Type) []field {
+	if cached, ok := fields.Load(t); ok {
+		return cached.([]field)
+	}
+	var parsed []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("validate")
+		if !ok || tag == "" || tag == "-" || !sf.IsExported() {
+			continue
+		}
+		f := field{index: i, name: jsonName(sf)}
+		for _, part := range strings.Split(tag, ",") {
+			name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch name {
+			case "":
+			case OmitEmpty:
+				f.omitEmpty = true
+			default:
+				f.checks = append(f.checks, check{name: name, param: param})
+			}
+		}
+		parsed = append(parsed, f)
+	}
+	fields.Store(t, parsed)
+	return parsed
+}
+
+// jsonName is the name clients know the field by
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
@@ -0,0 +1,139 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"rest-api-go/internal/apperrors"
+	"testing"
+)
+
+type account struct {
+	Name     string   `json:"name" validate:"required,min=3,max=8,regex=^[a-z]+$"`
+	Email    string   `json:"email,omitempty" validate:"omitempty,email"`
+	Code     string   `json:"code" validate:"omitempty,charset=numeric"`
+	Tags     []string `validate:"max=2"`
+	Age      int      `json:"age" validate:"min=18"`
+	internal string   `validate:"required"`
+}
+
+func violations(t *testing.T, v interface{}) []apperrors.FieldError {
+	t.Helper()
+	err := Struct(v)
+	if err == nil {
+		return nil
+	}
+	var appErr *apperrors.AppError
+	if !errors.As(err, &appErr) || !errors.Is(err, apperrors.ErrValidation) {
+		t.Fatalf("Struct: got error %v, want a validation error", err)
+	}
+	return appErr.Fields
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name  string
+		value account
+		want  []apperrors.FieldError
+	}{
+		{
+			name:  "valid",
+			value: account{Name: "alice", Email: "alice@example.com", Code: "0042", Age: 18},
+		},
+		{
+			name:  "optional fields left out",
+			value: account{Name: "alice", Age: 30},
+		},
+		{
+			name:  "required stops at the first violation",
+			value: account{Name: "  ", Age: 18},
+			want:  []apperrors.FieldError{{Field: "name", Rule: "required", Message: "is required"}},
+		},
+		{
+			name:  "every violation",
+			value: account{Name: "Al", Email: "Alice <alice@example.com>", Code: "4x", Tags: []string{"a", "b", "c"}, Age: 17},
+			want: []apperrors.FieldError{
+				{Field: "name", Rule: "min", Message: "must be at least 3 characters long"},
+				{Field: "name", Rule: "regex", Message: "must match ^[a-z]+$"},
+				{Field: "email", Rule: "email", Message: "must be a valid email address"},
+				{Field: "code", Rule: "charset", Message: "must only hold digits characters"},
+				{Field: "Tags", Rule: "max", Message: "must be at most 2 elements long"},
+				{Field: "age", Rule: "min", Message: "must be at least 18"},
+			},
+		},
+		{
+			name:  "length in characters",
+			value: account{Name: "ééééééééé", Age: 18},
+			want: []apperrors.FieldError{
+				{Field: "name", Rule: "max", Message: "must be at most 8 characters long"},
+				{Field: "name", Rule: "regex", Message: "must match ^[a-z]+$"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := violations(t, &tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got violations %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmail(t *testing.T) {
+	for address, valid := range map[string]bool{
+		"alice@example.com":         true,
+		"alice+tag@mail.example.io": true,
+		"alice@localhost":           false,
+		"alice":                     false,
+		"<alice@example.com>":       false,
+		"alice@example.com ":        false,
+	} {
+		violation, err := email(reflect.ValueOf(address), "")
+		if err != nil {
+			t.Fatalf("email(%q): unexpected error: %v", address, err)
+		}
+		if (violation == "") != valid {
+			t.Errorf("email(%q): got violation %q, want valid %v", address, violation, valid)
+		}
+	}
+}
+
+func TestStructRejectsMisuse(t *testing.T) {
+	var nilAccount *account
+	type unknownRule struct {
+		Name string `validate:"shiny"`
+	}
+	type badParam struct {
+		Name string `validate:"min=three"`
+	}
+	for name, v := range map[string]interface{}{
+		"nil pointer":  nilAccount,
+		"not a struct": "alice",
+		"unknown rule": unknownRule{Name: "alice"},
+		"bad param":    badParam{Name: "alice"},
+	} {
+		err := Struct(v)
+		if err == nil || errors.Is(err, apperrors.ErrValidation) {
+			t.Errorf("Struct of %s: got error %v, want a programming error", name, err)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("even", func(value reflect.Value, _ string) (string, error) {
+		if value.Int()%2 != 0 {
+			return "must be even", nil
+		}
+		return "", nil
+	})
+	type count struct {
+		N int `json:"n" validate:"even"`
+	}
+	if err := Struct(count{N: 2}); err != nil {
+		t.Fatalf("Struct: unexpected error: %v", err)
+	}
+	want := []apperrors.FieldError{{Field: "n", Rule: "even", Message: "must be even"}}
+	if got := violations(t, count{N: 3}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got violations %+v, want %+v", got, want)
+	}
+}
@@ -2,45 +2,151 @@ package mongodb
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-func NewClient(ctx context.Context, host, port, username, password, database, authDb string) (db *mongo.Database, err error) {
-	var mongoDBURL string
-	var isAuth bool
-	if username == "" && password == "" {
-		mongoDBURL = fmt.Sprintf("mongodb://%s:%s", host, port)
-	} else {
-		isAuth = true
-		mongoDBURL = fmt.Sprintf("mongodb://%s:%s@%s:%s", username, password, host, port)
-	}
+// Config describes a MongoDB deployment. URI, when set, is used as the
+// connection string and Host and Port are ignored; every other field that is
+// set overrides the same option of the URI.
+type Config struct {
+	URI      string
+	Host     string
+	Port     string
+	Database string
+	AuthDB   string
+	Username string
+	Password string
 
-	//Connect
+	ReplicaSet string
+	// ReadPreference is a mode such as "primary" or "secondaryPreferred"
+	ReadPreference string
+	TLS            TLSConfig
 
-	clientOptions := options.Client().ApplyURI(mongoDBURL)
-	if isAuth {
-		if authDb == "" {
-			authDb = database
-		}
-		clientOptions.SetAuth(options.Credential{
-			Username:   username,
-			Password:   password,
-			AuthSource: authDb,
-		})
+	MaxPoolSize            uint64
+	MinPoolSize            uint64
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+}
+
+type TLSConfig struct {
+	Enabled bool
+	// CAFile verifies the server instead of the system roots
+	CAFile string
+	// CertFile and KeyFile hold the client certificate for x.509 or mutual TLS
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+func NewClient(ctx context.Context, cfg Config) (db *mongo.Database, err error) {
+	clientOptions, err := clientOptions(cfg)
+	if err != nil {
+		return nil, errors.New("MongoDB Config Error: " + err.Error())
 	}
 
+	//Connect
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		return nil, errors.New("MongoDB Connect Error: " + err.Error())
 	}
 	//Ping
 	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(ctx)
 		return nil, errors.New("MongoDB Ping Error: " + err.Error())
 	}
-	return client.Database(database), nil
+	return client.Database(cfg.Database), nil
+}
+
+func clientOptions(cfg Config) (*options.ClientOptions, error) {
+	uri := cfg.URI
+	if uri == "" {
+		// credentials never go into the URI, so they need no escaping
+		uri = (&url.URL{Scheme: "mongodb", Host: net.JoinHostPort(cfg.Host, cfg.Port)}).String()
+	}
+	clientOptions := options.Client().ApplyURI(uri)
+	if err := clientOptions.Validate(); err != nil {
+		return nil, err
+	}
 
+	if cfg.Username != "" || cfg.Password != "" {
+		authDB := cfg.AuthDB
+		if authDB == "" {
+			authDB = cfg.Database
+		}
+		clientOptions.SetAuth(options.Credential{
+			Username:   cfg.Username,
+			Password:   cfg.Password,
+			AuthSource: authDB,
+		})
+	}
+	if cfg.ReplicaSet != "" {
+		clientOptions.SetReplicaSet(cfg.ReplicaSet)
+	}
+	if cfg.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(cfg.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		readPreference, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetReadPreference(readPreference)
+	}
+	if cfg.TLS.Enabled {
+		tlsConfig, err := newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+	if cfg.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+	if cfg.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(cfg.MinPoolSize)
+	}
+	if cfg.ConnectTimeout > 0 {
+		clientOptions.SetConnectTimeout(cfg.ConnectTimeout)
+	}
+	if cfg.ServerSelectionTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(cfg.ServerSelectionTimeout)
+	}
+	return clientOptions, clientOptions.Validate()
+}
+
+func newTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in CA file %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
 }
@@ -0,0 +1,38 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+
+	_ "github.com/lib/pq"
+)
+
+func NewClient(ctx context.Context, host, port, username, password, database, sslMode string) (db *sql.DB, err error) {
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		Host:     fmt.Sprintf("%s:%s", host, port),
+		Path:     database,
+		RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+	}
+	if username != "" {
+		dsn.User = url.UserPassword(username, password)
+	}
+
+	//Connect
+	db, err = sql.Open("postgres", dsn.String())
+	if err != nil {
+		return nil, errors.New("PostgreSQL Connect Error: " + err.Error())
+	}
+	//Ping
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, errors.New("PostgreSQL Ping Error: " + err.Error())
+	}
+	return db, nil
+}